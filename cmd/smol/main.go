@@ -2,30 +2,156 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/eliothedeman/smol/control"
+	"github.com/eliothedeman/smol/tools"
+	"github.com/eliothedeman/smol/transport"
 	"github.com/eliothedeman/smol/unit"
 )
 
+// remoteFlags collects -remote name=network:address flags.
+type remoteFlags map[string]string
+
+func (f remoteFlags) String() string {
+	return fmt.Sprint(map[string]string(f))
+}
+
+func (f remoteFlags) Set(s string) error {
+	name, addr, ok := strings.Cut(s, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("want name=network:address, got %q", s)
+	}
+	f[name] = addr
+	return nil
+}
+
+// registerUnits adds the units every smol process runs.
+func registerUnits(registry *unit.Registry, dataDir string) {
+	registry.Register("lifecycle", control.NewLifecycle(), unit.WithPriority(unit.PriorityCritical))
+	registry.Register("storage", tools.NewStorage(dataDir), unit.Durable())
+	registry.Register("registers", tools.NewRegisters())
+	registry.Register("math", tools.NewMath())
+	registry.Register(control.CodeUnit, tools.NewCodeExecution(""))
+	registry.Register("executor", control.NewInstructionExecutor(),
+		unit.DependsOn("storage", "registers", control.CodeUnit), unit.WithPriority(unit.PriorityHigh), unit.Durable())
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		if err := replay(os.Args[2:]); err != nil {
+			log.Fatalf("Replay failed: %v", err)
+		}
+		return
+	}
+
+	remotes := remoteFlags{}
+	flag.Var(remotes, "remote", "proxy a unit in another process, as name=network:address (repeatable)")
+	dataDir := flag.String("data", "smol-data", "directory for persistent storage")
+	traceFile := flag.String("trace", "", "write message traces as OTLP JSON lines to this file")
+	metricsAddr := flag.String("metrics", "", "serve Prometheus metrics on this address, e.g. :9090")
+	memoryLimit := flag.Uint64("memory-limit", 0, fmt.Sprintf("memory budget in bytes, e.g. %d; 0 disables the memory monitor", uint64(unit.DefaultMemoryLimit)))
+	listenAddr := flag.String("listen", "", "accept messages from other processes on network:address, e.g. unix:/run/smol.sock")
+	exports := flag.String("export", "", "comma-separated units that other processes may send to")
+	allowRemote := flag.Bool("allow-remote", false, "accept connections from other hosts, not only loopback and unix sockets")
+	journalFile := flag.String("journal", "", "journal delivered messages to this file, redelivering unhandled ones on restart")
+	journalCompact := flag.Int("journal-compact", 0, "compact the journal each time this many messages have been handled; 0 keeps its full history")
+	flag.Parse()
+
 	fmt.Println("smol - neural network system")
 	log.Println("Starting smol...")
 
-	registry := unit.NewRegistry()
-	lifecycle := control.NewLifecycle()
+	var opts []unit.RegistryOption
+	if *memoryLimit > 0 {
+		opts = append(opts, unit.WithMemoryLimit(*memoryLimit, unit.DefaultMemoryInterval))
+	}
+	if *traceFile != "" {
+		f, err := os.Create(*traceFile)
+		if err != nil {
+			log.Fatalf("Failed to create trace file: %v", err)
+		}
+		defer f.Close()
+		opts = append(opts, unit.WithTracer(unit.NewOTLPFile(f, "smol")))
+	}
+
+	if *journalFile != "" {
+		journal, err := unit.OpenJournal(*journalFile, unit.WithCompaction(*journalCompact))
+		if err != nil {
+			log.Fatalf("Failed to open journal: %v", err)
+		}
+		defer journal.Close()
+		if n := len(journal.Pending()); n > 0 {
+			log.Printf("Redelivering %d unhandled messages from %s", n, *journalFile)
+		}
+		opts = append(opts, unit.WithJournal(journal))
+	}
+
+	registry := unit.NewRegistry(opts...)
+	registerUnits(registry, *dataDir)
 
-	registry.Register("lifecycle", lifecycle)
+	var transportOpts []transport.Option
+	if *exports != "" {
+		transportOpts = append(transportOpts, transport.Export(strings.Split(*exports, ",")...))
+	}
+	if *allowRemote {
+		transportOpts = append(transportOpts, transport.AllowRemote())
+	}
+
+	peers := make(map[string]*transport.Peer)
+	for name, addr := range remotes {
+		peer, ok := peers[addr]
+		if !ok {
+			network, address, err := transport.ParseAddr(addr)
+			if err != nil {
+				log.Fatalf("Invalid remote %s: %v", name, err)
+			}
+			if peer, err = transport.Dial(network, address, registry, transportOpts...); err != nil {
+				log.Fatalf("Failed to connect to %s: %v", addr, err)
+			}
+			defer peer.Close()
+			peers[addr] = peer
+		}
+		registry.Register(name, peer.Proxy(name))
+	}
 
 	if err := registry.Start(); err != nil {
 		log.Fatalf("Failed to start registry: %v", err)
 	}
 
+	if *listenAddr != "" {
+		network, address, err := transport.ParseAddr(*listenAddr)
+		if err != nil {
+			log.Fatalf("Invalid listen address: %v", err)
+		}
+		server, err := transport.Listen(registry, network, address, transportOpts...)
+		if err != nil {
+			log.Fatalf("Failed to listen: %v", err)
+		}
+		defer server.Close()
+		log.Printf("Accepting remote messages on %s", *listenAddr)
+	}
+
+	if *metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", registry.Metrics().Handler())
+		server := &http.Server{Addr: *metricsAddr, Handler: mux}
+		go func() {
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("Metrics server failed: %v", err)
+			}
+		}()
+		defer server.Close()
+		log.Printf("Serving metrics on %s/metrics", *metricsAddr)
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -37,20 +163,15 @@ func main() {
 		cancel()
 	}()
 
-	go func() {
-		lifecycle.AddTaskWithContext(func(ctx context.Context) {
-			<-ctx.Done()
-			log.Println("Shutting down lifecycle...")
-			lifecycle.Shutdown()
-		})
-	}()
-
 	<-ctx.Done()
 	log.Println("Shutting down...")
 
-	_, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer shutdownCancel()
 
-	registry.Stop()
+	if err := registry.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Shutdown incomplete: %v", err)
+		return
+	}
 	log.Println("Shutdown complete")
 }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/eliothedeman/smol/unit"
+)
+
+// replay feeds a recorded journal into a fresh registry, one message at a
+// time, to reproduce a run.
+func replay(args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	flags.Usage = func() {
+		log.Printf("usage: smol replay [-data dir] [-timeout d] journal")
+		flags.PrintDefaults()
+	}
+	dataDir := flags.String("data", "", "directory for persistent storage; a temporary one by default")
+	timeout := flags.Duration("timeout", time.Minute, "give up if the replay takes longer than this")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	entries, err := unit.ReadJournal(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("read journal: %w", err)
+	}
+
+	if *dataDir == "" {
+		if *dataDir, err = os.MkdirTemp("", "smol-replay-"); err != nil {
+			return err
+		}
+		defer os.RemoveAll(*dataDir)
+	}
+
+	registry := unit.NewRegistry()
+	registerUnits(registry, *dataDir)
+	if err := registry.Start(); err != nil {
+		return fmt.Errorf("start registry: %w", err)
+	}
+	defer registry.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	n, err := registry.Replay(ctx, entries)
+	log.Printf("Replayed %d messages from %s", n, flags.Arg(0))
+	return err
+}
@@ -0,0 +1,451 @@
+// Package cmdline splits string commands into arguments with POSIX
+// shell-like quoting, escapes and heredocs, and separates --flag options.
+package cmdline
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrSyntax = errors.New("syntax error")
+
+// Pos is a position in a command, counting lines and columns from 1.
+// Columns count characters, not bytes.
+type Pos struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// SyntaxError is a command that cannot be split, such as one with an
+// unterminated quote. Pos is where the problem starts.
+type SyntaxError struct {
+	Pos Pos
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s at %s", e.Msg, e.Pos)
+}
+
+func (e *SyntaxError) Is(target error) bool {
+	return target == ErrSyntax
+}
+
+// Split splits a command into arguments, as a POSIX shell would without
+// expanding anything:
+//
+//   - Whitespace, including newlines, separates arguments.
+//   - Single quotes keep everything up to the next single quote.
+//   - Double quotes keep everything up to the next double quote, except
+//     that a backslash escapes ", \, $, ` and newline.
+//   - Elsewhere a backslash escapes any character, and a backslash before
+//     a newline joins the lines.
+//   - <<TAG starts a heredoc: the lines after the current one, up to a
+//     line holding only TAG, become one argument without the final
+//     newline. <<-TAG also strips leading tabs from them.
+func Split(command string) ([]string, error) {
+	words, err := Lexer{}.Words(command)
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, len(words))
+	for i, w := range words {
+		args[i] = w.String()
+	}
+	return args, nil
+}
+
+// Word is an argument split from a command, keeping how each part of it
+// was quoted for languages that expand some parts but not others.
+type Word struct {
+	Pos   Pos
+	Parts []Part
+	// Op is set when the word is one of the Lexer's operators.
+	Op rune
+}
+
+// Part is a run of a word quoted the same way.
+type Part struct {
+	Text string
+	// Quote is '\'' for single-quoted text and heredoc bodies, '"' for
+	// double-quoted text, '\\' for a character escaped by a backslash and
+	// 0 for the rest.
+	Quote rune
+}
+
+// String returns the word as Split would.
+func (w Word) String() string {
+	if w.Op != 0 {
+		return string(w.Op)
+	}
+	var b strings.Builder
+	for _, p := range w.Parts {
+		b.WriteString(p.Text)
+	}
+	return b.String()
+}
+
+// Quoted reports whether any part of the word was quoted or escaped.
+func (w Word) Quoted() bool {
+	for _, p := range w.Parts {
+		if p.Quote != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Lexer splits commands as Split does, with the extra syntax of languages
+// built on its quoting, such as scripts.
+type Lexer struct {
+	// Operators are runes that, unquoted, end a word and make a word of
+	// their own, such as ';' or '|'. A newline among them is returned
+	// rather than separating words.
+	Operators string
+	// Comments makes an unquoted # at the start of a word skip the rest
+	// of the line.
+	Comments bool
+}
+
+// Words splits a command into words.
+func (l Lexer) Words(command string) ([]Word, error) {
+	s := &scanner{src: command, pos: Pos{Line: 1, Column: 1}, lexer: l}
+	return s.split()
+}
+
+// Command is a command split into arguments and --flag options.
+type Command struct {
+	Args  []string
+	Flags map[string]string
+}
+
+// Flag returns the value of a --flag option, and whether it was given.
+func (c Command) Flag(name string) (string, bool) {
+	v, ok := c.Flags[name]
+	return v, ok
+}
+
+// Parse splits a command like Split and takes out --name=value and --name
+// options, the latter with the value "true". A "--" argument ends the
+// options, so that later arguments may start with "--".
+func Parse(command string) (Command, error) {
+	args, err := Split(command)
+	if err != nil {
+		return Command{}, err
+	}
+
+	c := Command{Flags: make(map[string]string)}
+	for i, arg := range args {
+		if arg == "--" {
+			c.Args = append(c.Args, args[i+1:]...)
+			break
+		}
+		name, ok := strings.CutPrefix(arg, "--")
+		if !ok || name == "" || strings.HasPrefix(name, "=") {
+			c.Args = append(c.Args, arg)
+			continue
+		}
+		name, value, hasValue := strings.Cut(name, "=")
+		if !hasValue {
+			value = "true"
+		}
+		c.Flags[name] = value
+	}
+	return c, nil
+}
+
+// Join quotes args as needed so that Split returns them unchanged.
+func Join(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = Quote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// Quote returns arg quoted for Split, if it needs to be.
+func Quote(arg string) string {
+	if arg == "" {
+		return "''"
+	}
+	if !strings.ContainsAny(arg, " \t\r\n'\"\\<") {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+type scanner struct {
+	src   string
+	pos   Pos
+	lexer Lexer
+	// heredocs are waiting for their bodies, which start on the next line.
+	heredocs []*heredoc
+}
+
+type heredoc struct {
+	start     Pos
+	tag       string
+	stripTabs bool
+	word      *Word
+}
+
+// wordBuilder gathers a word's text into parts.
+type wordBuilder struct {
+	parts   []Part
+	text    strings.Builder
+	quote   rune
+	started bool
+}
+
+// add appends text quoted with quote, starting a new part if the quoting
+// changes. Empty text still starts a part, so that "" makes a word.
+func (b *wordBuilder) add(quote rune, text string) {
+	if !b.started || quote != b.quote {
+		b.flush()
+		b.quote = quote
+		b.started = true
+	}
+	b.text.WriteString(text)
+}
+
+func (b *wordBuilder) addRune(quote rune, r rune) {
+	b.add(quote, string(r))
+}
+
+func (b *wordBuilder) flush() {
+	if b.started {
+		b.parts = append(b.parts, Part{Text: b.text.String(), Quote: b.quote})
+		b.text.Reset()
+	}
+}
+
+func (s *scanner) errorf(pos Pos, format string, args ...any) error {
+	return &SyntaxError{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (s *scanner) peek() (rune, bool) {
+	if s.pos.Offset >= len(s.src) {
+		return 0, false
+	}
+	r, _ := utf8.DecodeRuneInString(s.src[s.pos.Offset:])
+	return r, true
+}
+
+func (s *scanner) next() rune {
+	r, size := utf8.DecodeRuneInString(s.src[s.pos.Offset:])
+	s.pos.Offset += size
+	if r == '\n' {
+		s.pos.Line++
+		s.pos.Column = 1
+	} else {
+		s.pos.Column++
+	}
+	return r
+}
+
+// skip moves past n bytes.
+func (s *scanner) skip(n int) {
+	for end := s.pos.Offset + n; s.pos.Offset < end; {
+		s.next()
+	}
+}
+
+func (s *scanner) isOperator(r rune) bool {
+	return strings.ContainsRune(s.lexer.Operators, r)
+}
+
+func (s *scanner) split() ([]Word, error) {
+	// Heredoc words are filled in once their bodies are read, so words
+	// are kept as pointers until the end.
+	var words []*Word
+	for {
+		r, ok := s.peek()
+		if !ok {
+			break
+		}
+		switch {
+		case r == '\n':
+			if s.isOperator(r) {
+				words = append(words, &Word{Pos: s.pos, Op: r})
+			}
+			s.next()
+			if err := s.readHeredocs(); err != nil {
+				return nil, err
+			}
+		case r == ' ' || r == '\t' || r == '\r':
+			s.next()
+		case s.isOperator(r):
+			words = append(words, &Word{Pos: s.pos, Op: r})
+			s.next()
+		case r == '#' && s.lexer.Comments:
+			for r, ok := s.peek(); ok && r != '\n'; r, ok = s.peek() {
+				s.next()
+			}
+		case strings.HasPrefix(s.src[s.pos.Offset:], "<<"):
+			doc, err := s.heredocStart()
+			if err != nil {
+				return nil, err
+			}
+			words = append(words, doc.word)
+		default:
+			w, err := s.word()
+			if err != nil {
+				return nil, err
+			}
+			if w != nil {
+				words = append(words, w)
+			}
+		}
+	}
+	if len(s.heredocs) > 0 {
+		// The command ends on the heredoc's line, so its body is empty
+		// and its tag missing.
+		doc := s.heredocs[0]
+		return nil, s.errorf(doc.start, "heredoc %s is not terminated", doc.tag)
+	}
+
+	out := make([]Word, len(words))
+	for i, w := range words {
+		out[i] = *w
+	}
+	return out, nil
+}
+
+// word reads one word, or nil if it was only a line continuation.
+func (s *scanner) word() (*Word, error) {
+	w := &Word{Pos: s.pos}
+	var b wordBuilder
+	for {
+		r, ok := s.peek()
+		if !ok || r == ' ' || r == '\t' || r == '\r' || r == '\n' || s.isOperator(r) {
+			break
+		}
+		start := s.pos
+		s.next()
+		switch r {
+		case '\\':
+			c, ok := s.peek()
+			if !ok {
+				return nil, s.errorf(start, "trailing backslash")
+			}
+			s.next()
+			if c == '\n' {
+				continue
+			}
+			b.addRune('\\', c)
+		case '\'':
+			end := strings.IndexByte(s.src[s.pos.Offset:], '\'')
+			if end < 0 {
+				return nil, s.errorf(start, "unterminated single quote")
+			}
+			b.add('\'', s.src[s.pos.Offset:s.pos.Offset+end])
+			s.skip(end + 1)
+		case '"':
+			if err := s.doubleQuoted(start, &b); err != nil {
+				return nil, err
+			}
+		default:
+			b.addRune(0, r)
+		}
+	}
+	if !b.started {
+		return nil, nil
+	}
+	b.flush()
+	w.Parts = b.parts
+	return w, nil
+}
+
+func (s *scanner) doubleQuoted(start Pos, b *wordBuilder) error {
+	b.add('"', "")
+	for {
+		r, ok := s.peek()
+		if !ok {
+			return s.errorf(start, "unterminated double quote")
+		}
+		s.next()
+		switch r {
+		case '"':
+			return nil
+		case '\\':
+			c, ok := s.peek()
+			if !ok {
+				return s.errorf(start, "unterminated double quote")
+			}
+			switch c {
+			case '"', '\\', '$', '`':
+				s.next()
+				b.addRune('\\', c)
+			case '\n':
+				s.next()
+			default:
+				b.addRune('"', r)
+			}
+		default:
+			b.addRune('"', r)
+		}
+	}
+}
+
+// heredocStart reads <<TAG, <<-TAG, or a quoted tag, whose body is read
+// at the end of the line.
+func (s *scanner) heredocStart() (*heredoc, error) {
+	start := s.pos
+	s.next()
+	s.next()
+	doc := &heredoc{start: start, word: &Word{Pos: start}}
+	if r, ok := s.peek(); ok && r == '-' {
+		s.next()
+		doc.stripTabs = true
+	}
+
+	tag, err := s.word()
+	if err != nil {
+		return nil, err
+	}
+	if tag == nil || tag.String() == "" {
+		return nil, s.errorf(start, "heredoc has no tag")
+	}
+	doc.tag = tag.String()
+	s.heredocs = append(s.heredocs, doc)
+	return doc, nil
+}
+
+// readHeredocs reads the bodies of the heredocs started on the line just
+// ended, in order.
+func (s *scanner) readHeredocs() error {
+	for _, doc := range s.heredocs {
+		var body strings.Builder
+		for {
+			if s.pos.Offset >= len(s.src) {
+				return s.errorf(doc.start, "heredoc %s is not terminated", doc.tag)
+			}
+			line, _, found := strings.Cut(s.src[s.pos.Offset:], "\n")
+			if found {
+				s.skip(len(line) + 1)
+			} else {
+				s.skip(len(line))
+			}
+			if doc.stripTabs {
+				line = strings.TrimLeft(line, "\t")
+			}
+			if strings.TrimSuffix(line, "\r") == doc.tag {
+				break
+			}
+			if body.Len() > 0 {
+				body.WriteByte('\n')
+			}
+			body.WriteString(line)
+		}
+		doc.word.Parts = []Part{{Text: body.String(), Quote: '\''}}
+	}
+	s.heredocs = nil
+	return nil
+}
@@ -0,0 +1,116 @@
+package cmdline
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", []string{}},
+		{"  save  k   v ", []string{"save", "k", "v"}},
+		{`save k "hello world"`, []string{"save", "k", "hello world"}},
+		{`save k '{"a": "b c"}'`, []string{"save", "k", `{"a": "b c"}`}},
+		{`a "" ''`, []string{"a", "", ""}},
+		{`it\'s "say \"hi\" \n" 'no \escape'`, []string{"it's", `say "hi" \n`, `no \escape`}},
+		{"one\\\ntwo three\\ four", []string{"onetwo", "three four"}},
+		{`mixed"quo"'ted'`, []string{"mixedquoted"}},
+		{"first\nsecond", []string{"first", "second"}},
+		{"save k <<EOF\n{\n  \"a\": 1\n}\nEOF", []string{"save", "k", "{\n  \"a\": 1\n}"}},
+		{"cat <<-END x\n\t\tindented\n\tEND\nafter", []string{"cat", "indented", "x", "after"}},
+		{"two <<A <<'B'\na\nA\nb\nB", []string{"two", "a", "b"}},
+		{"ünï 'cödé'", []string{"ünï", "cödé"}},
+	}
+	for _, tt := range tests {
+		got, err := Split(tt.in)
+		if err != nil {
+			t.Errorf("Split(%q) failed: %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Split(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSplitErrors(t *testing.T) {
+	tests := []struct {
+		in  string
+		pos Pos
+	}{
+		{`save k "hello`, Pos{Offset: 7, Line: 1, Column: 8}},
+		{"a\nb 'c\nd", Pos{Offset: 4, Line: 2, Column: 3}},
+		{`trailing \`, Pos{Offset: 9, Line: 1, Column: 10}},
+		{"é <<EOF\nbody", Pos{Offset: 3, Line: 1, Column: 3}},
+		{"x <<EOF", Pos{Offset: 2, Line: 1, Column: 3}},
+		{"x << ", Pos{Offset: 2, Line: 1, Column: 3}},
+	}
+	for _, tt := range tests {
+		_, err := Split(tt.in)
+		var syntax *SyntaxError
+		if !errors.As(err, &syntax) || !errors.Is(err, ErrSyntax) {
+			t.Errorf("Split(%q): expected a SyntaxError, got %v", tt.in, err)
+			continue
+		}
+		if syntax.Pos != tt.pos {
+			t.Errorf("Split(%q): error %q at %+v, want %+v", tt.in, err, syntax.Pos, tt.pos)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	c, err := Parse(`query math --timeout=5s --verbose "--quoted=still a flag" -- --literal`)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if want := []string{"query", "math", "--literal"}; !reflect.DeepEqual(c.Args, want) {
+		t.Errorf("Args = %q, want %q", c.Args, want)
+	}
+	want := map[string]string{"timeout": "5s", "verbose": "true", "quoted": "still a flag"}
+	if !reflect.DeepEqual(c.Flags, want) {
+		t.Errorf("Flags = %q, want %q", c.Flags, want)
+	}
+	if v, ok := c.Flag("timeout"); !ok || v != "5s" {
+		t.Errorf("Flag(timeout) = %q, %v", v, ok)
+	}
+}
+
+func TestJoinRoundTrips(t *testing.T) {
+	args := []string{"plain", "two words", "", `it's "quoted"`, "back\\slash", "multi\nline", "<<EOF", "--flag"}
+	got, err := Split(Join(args))
+	if err != nil {
+		t.Fatalf("Split(Join) failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, args) {
+		t.Errorf("Split(Join(%q)) = %q", args, got)
+	}
+}
+
+func TestLexerWords(t *testing.T) {
+	l := Lexer{Operators: "\n;|", Comments: true}
+	words, err := l.Words("a$x \"b $y\\$\"|c 'd|e' # note\nf;g#h")
+	if err != nil {
+		t.Fatalf("Words failed: %v", err)
+	}
+	want := []Word{
+		{Pos: Pos{0, 1, 1}, Parts: []Part{{"a$x", 0}}},
+		{Pos: Pos{4, 1, 5}, Parts: []Part{{"b $y", '"'}, {"$", '\\'}}},
+		{Pos: Pos{12, 1, 13}, Op: '|'},
+		{Pos: Pos{13, 1, 14}, Parts: []Part{{"c", 0}}},
+		{Pos: Pos{15, 1, 16}, Parts: []Part{{"d|e", '\''}}},
+		{Pos: Pos{27, 1, 28}, Op: '\n'},
+		{Pos: Pos{28, 2, 1}, Parts: []Part{{"f", 0}}},
+		{Pos: Pos{29, 2, 2}, Op: ';'},
+		{Pos: Pos{30, 2, 3}, Parts: []Part{{"g#h", 0}}},
+	}
+	if !reflect.DeepEqual(words, want) {
+		t.Errorf("Words = %+v, want %+v", words, want)
+	}
+	if words[0].Quoted() || !words[1].Quoted() {
+		t.Error("Expected only the second word to be quoted")
+	}
+}
@@ -2,10 +2,17 @@ package control
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/eliothedeman/smol/cmdline"
 	"github.com/eliothedeman/smol/unit"
 )
 
@@ -18,13 +25,36 @@ const (
 	CmdGet     CommandType = "get"
 	CmdList    CommandType = "list"
 	CmdHelp    CommandType = "help"
+	CmdRun     CommandType = "run"
+	CmdPlan    CommandType = "plan"
+)
+
+const (
+	// CodeUnit runs the code given to execute.
+	CodeUnit = "code"
+	// ConfigUnit stores the values given to set, under configPrefix.
+	ConfigUnit   = "storage"
+	configPrefix = "config/"
+)
+
+// DefaultInstructionTimeout bounds how long an instruction waits for the
+// units it asks.
+const DefaultInstructionTimeout = 30 * time.Second
+
+var (
+	ErrConfigNotSet = errors.New("config key not set")
+	// ErrAskSelf is returned for instructions that would have the
+	// executor ask itself, which it cannot answer while it waits.
+	ErrAskSelf = errors.New("executor cannot ask itself")
 )
 
 type Instruction struct {
-	Type    CommandType
-	Action  string
-	Args    []string
-	Context map[string]any
+	Type    CommandType    `json:"type"`
+	Action  string         `json:"action,omitempty"`
+	Args    []string       `json:"args,omitempty"`
+	Context map[string]any `json:"context,omitempty"`
+	// Timeout overrides the executor's Timeout for this instruction.
+	Timeout time.Duration `json:"timeout,omitempty"`
 }
 
 type CommandResult struct {
@@ -34,10 +64,52 @@ type CommandResult struct {
 	Data    any
 }
 
+type commandResultJSON struct {
+	Success bool
+	Output  string
+	Error   string `json:",omitempty"`
+	Data    any
+}
+
+// MarshalJSON writes Error as its message, since errors have no JSON form
+// of their own.
+func (r CommandResult) MarshalJSON() ([]byte, error) {
+	m := commandResultJSON{Success: r.Success, Output: r.Output, Data: r.Data}
+	if r.Error != nil {
+		m.Error = r.Error.Error()
+	}
+	return json.Marshal(m)
+}
+
+func (r *CommandResult) UnmarshalJSON(data []byte) error {
+	var m commandResultJSON
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	*r = CommandResult{Success: m.Success, Output: m.Output, Data: m.Data}
+	if m.Error != "" {
+		r.Error = errors.New(m.Error)
+	}
+	return nil
+}
+
+func init() {
+	unit.RegisterMessage[Instruction]("control.Instruction")
+	unit.RegisterMessage[CommandResult]("control.CommandResult")
+	unit.RegisterMessage[ScriptResult]("control.ScriptResult")
+	unit.RegisterMessage[Plan]("control.Plan")
+	unit.RegisterMessage[PlanResult]("control.PlanResult")
+}
+
 type InstructionExecutor struct {
 	mu       sync.RWMutex
 	commands map[CommandType]CommandHandler
 	ctx      unit.Ctx
+	// runs numbers script runs, to keep their variables apart.
+	runs atomic.Uint64
+
+	// Timeout bounds how long an instruction waits for the units it asks.
+	Timeout time.Duration
 }
 
 type CommandHandler func(ctx context.Context, args []string) (CommandResult, error)
@@ -45,6 +117,7 @@ type CommandHandler func(ctx context.Context, args []string) (CommandResult, err
 func NewInstructionExecutor() *InstructionExecutor {
 	ie := &InstructionExecutor{
 		commands: make(map[CommandType]CommandHandler),
+		Timeout:  DefaultInstructionTimeout,
 	}
 	ie.registerDefaultCommands()
 	return ie
@@ -56,12 +129,41 @@ func (ie *InstructionExecutor) Init(ctx unit.Ctx) {
 	ie.ctx = ctx
 }
 
+// Describe lists the executor's commands. They are sent as a map whose
+// "type" is the action name, with the arguments as a list of strings.
+func (ie *InstructionExecutor) Describe() unit.Description {
+	ie.mu.RLock()
+	defer ie.mu.RUnlock()
+
+	args := unit.Object(map[string]unit.Schema{
+		"args": unit.ArrayOf(unit.Prop("string", ""), ""),
+	})
+
+	types := make([]string, 0, len(ie.commands))
+	for cmdType := range ie.commands {
+		types = append(types, string(cmdType))
+	}
+	sort.Strings(types)
+
+	desc := unit.Description{Summary: "Parses and executes instructions"}
+	for _, cmdType := range types {
+		desc.Actions = append(desc.Actions, unit.ActionDesc{
+			Name:  cmdType,
+			Args:  args,
+			Reply: unit.Prop("object", "a CommandResult"),
+		})
+	}
+	return desc
+}
+
 func (ie *InstructionExecutor) Handle(ctx unit.Ctx, from unit.UnitRef, message any) error {
 	switch msg := message.(type) {
 	case string:
 		return ie.handleStringCommand(ctx, from, msg)
 	case Instruction:
 		return ie.handleInstruction(ctx, from, msg)
+	case Plan:
+		return ie.handlePlanMessage(ctx, from, msg)
 	case map[string]any:
 		return ie.handleMapCommand(ctx, from, msg)
 	default:
@@ -69,6 +171,18 @@ func (ie *InstructionExecutor) Handle(ctx unit.Ctx, from unit.UnitRef, message a
 	}
 }
 
+// withTimeout bounds ctx by timeout, or by ie.Timeout if timeout is not
+// set.
+func (ie *InstructionExecutor) withTimeout(ctx unit.Ctx, timeout time.Duration) (unit.Ctx, context.CancelFunc) {
+	if timeout <= 0 {
+		timeout = ie.Timeout
+	}
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return unit.WithTimeout(ctx, timeout)
+}
+
 func (ie *InstructionExecutor) handleStringCommand(ctx unit.Ctx, from unit.UnitRef, cmd string) error {
 	instruction, err := ie.parseCommand(cmd)
 	if err != nil {
@@ -78,12 +192,37 @@ func (ie *InstructionExecutor) handleStringCommand(ctx unit.Ctx, from unit.UnitR
 }
 
 func (ie *InstructionExecutor) handleInstruction(ctx unit.Ctx, from unit.UnitRef, instruction Instruction) error {
-	handler, exists := ie.commands[instruction.Type]
-	if !exists {
-		return fmt.Errorf("unknown command type: %s", instruction.Type)
+	ctx, cancel := ie.withTimeout(ctx, instruction.Timeout)
+	defer cancel()
+
+	result, err := ie.execute(ctx, instruction)
+	if err != nil {
+		return err
 	}
+	from.Send(result)
+	return nil
+}
 
-	result, err := handler(ctx, instruction.Args)
+// execute runs one instruction. Only an unknown command is an error;
+// commands that fail say so in their result.
+func (ie *InstructionExecutor) execute(ctx unit.Ctx, instruction Instruction) (CommandResult, error) {
+	var result CommandResult
+	var err error
+	if target, parts, ok := ie.route(instruction); ok {
+		result, err = ie.forward(ctx, target, parts)
+	} else {
+		handler, exists := ie.command(instruction.Type)
+		if !exists {
+			return CommandResult{}, fmt.Errorf("unknown command type: %s", instruction.Type)
+		}
+
+		// The action is the command's first argument, as in "query math".
+		args := instruction.Args
+		if instruction.Action != "" {
+			args = append([]string{instruction.Action}, args...)
+		}
+		result, err = handler(ctx, args)
+	}
 	if err != nil {
 		result = CommandResult{
 			Success: false,
@@ -91,64 +230,118 @@ func (ie *InstructionExecutor) handleInstruction(ctx unit.Ctx, from unit.UnitRef
 			Output:  fmt.Sprintf("Error: %v", err),
 		}
 	}
+	return result, nil
+}
 
-	from.Send(result)
-	return nil
+// route finds the unit an instruction is for: the action of an execute
+// naming a unit, as in "execute storage list", or a type naming a unit
+// rather than a command, as in "math add 1 2". The rest of the
+// instruction is the message for the unit.
+func (ie *InstructionExecutor) route(instruction Instruction) (string, []string, bool) {
+	if instruction.Type == CmdExecute && instruction.Action != "" {
+		if _, err := ie.findUnit(instruction.Action); err == nil {
+			return instruction.Action, instruction.Args, true
+		}
+	}
+	if _, isCommand := ie.command(instruction.Type); isCommand {
+		return "", nil, false
+	}
+	if _, err := ie.findUnit(string(instruction.Type)); err != nil {
+		return "", nil, false
+	}
+	parts := instruction.Args
+	if instruction.Action != "" {
+		parts = append([]string{instruction.Action}, parts...)
+	}
+	return string(instruction.Type), parts, true
 }
 
-func (ie *InstructionExecutor) handleMapCommand(ctx unit.Ctx, from unit.UnitRef, cmd map[string]any) error {
-	instruction := Instruction{
-		Context: make(map[string]any),
+// forward sends parts to the target unit as a map if they are a JSON
+// object, or else as one string command, quoted for cmdline.Split. It
+// wraps the reply.
+func (ie *InstructionExecutor) forward(ctx context.Context, target string, parts []string) (CommandResult, error) {
+	if len(parts) == 0 {
+		return CommandResult{}, fmt.Errorf("nothing to send to %s", target)
 	}
 
-	if cmdType, ok := cmd["type"].(string); ok {
-		instruction.Type = CommandType(cmdType)
+	var msg any = cmdline.Join(parts)
+	var m map[string]any
+	if json.Unmarshal([]byte(strings.Join(parts, " ")), &m) == nil {
+		msg = m
 	}
-	if action, ok := cmd["action"].(string); ok {
-		instruction.Action = action
+
+	reply, err := ie.ask(ctx, target, msg)
+	if err != nil {
+		return CommandResult{}, fmt.Errorf("%s: %w", target, err)
 	}
-	if args, ok := cmd["args"].([]string); ok {
-		instruction.Args = args
-	} else if args, ok := cmd["args"].([]any); ok {
-		instruction.Args = make([]string, len(args))
-		for i, arg := range args {
-			if str, ok := arg.(string); ok {
-				instruction.Args[i] = str
-			}
+	return CommandResult{
+		Success: true,
+		Output:  fmt.Sprint(reply),
+		Data:    reply,
+	}, nil
+}
+
+func (ie *InstructionExecutor) handleMapCommand(ctx unit.Ctx, from unit.UnitRef, cmd map[string]any) error {
+	if _, ok := cmd["steps"]; ok {
+		plan, err := unit.Decode[Plan](cmd)
+		if err != nil {
+			return err
 		}
+		return ie.handlePlanMessage(ctx, from, plan)
+	}
+
+	instruction, err := unit.Decode[Instruction](cmd)
+	if err != nil {
+		return err
+	}
+	if instruction.Context == nil {
+		instruction.Context = make(map[string]any)
 	}
 
 	return ie.handleInstruction(ctx, from, instruction)
 }
 
+// parseCommand splits a command as cmdline.Parse does. Its --flag options
+// go into the instruction's Context, and --timeout sets its Timeout.
 func (ie *InstructionExecutor) parseCommand(input string) (Instruction, error) {
-	input = strings.TrimSpace(input)
-	if input == "" {
-		return Instruction{}, fmt.Errorf("empty command")
+	cmd, err := cmdline.Parse(input)
+	if err != nil {
+		return Instruction{}, err
 	}
-
-	parts := strings.Fields(input)
+	parts := cmd.Args
 	if len(parts) == 0 {
-		return Instruction{}, fmt.Errorf("invalid command format")
+		return Instruction{}, fmt.Errorf("empty command")
 	}
 
-	cmdType := CommandType(strings.ToLower(parts[0]))
-	action := ""
-	args := []string{}
-
+	instruction := Instruction{
+		Type:    CommandType(strings.ToLower(parts[0])),
+		Args:    []string{},
+		Context: make(map[string]any),
+	}
 	if len(parts) > 1 {
-		action = parts[1]
+		instruction.Action = parts[1]
 	}
 	if len(parts) > 2 {
-		args = parts[2:]
+		instruction.Args = parts[2:]
+	}
+	for name, value := range cmd.Flags {
+		instruction.Context[name] = value
 	}
+	if timeout, ok := cmd.Flag("timeout"); ok {
+		if instruction.Timeout, err = time.ParseDuration(timeout); err != nil {
+			return Instruction{}, fmt.Errorf("invalid --timeout: %w", err)
+		}
+	}
+	return instruction, nil
+}
 
-	return Instruction{
-		Type:    cmdType,
-		Action:  action,
-		Args:    args,
-		Context: make(map[string]any),
-	}, nil
+// command looks up a handler. Plans execute instructions concurrently,
+// while RegisterCommand may add handlers.
+func (ie *InstructionExecutor) command(cmdType CommandType) (CommandHandler, bool) {
+	ie.mu.RLock()
+	defer ie.mu.RUnlock()
+	handler, ok := ie.commands[cmdType]
+	return handler, ok
 }
 
 func (ie *InstructionExecutor) RegisterCommand(cmdType CommandType, handler CommandHandler) {
@@ -161,16 +354,54 @@ func (ie *InstructionExecutor) registerDefaultCommands() {
 	ie.RegisterCommand(CmdHelp, ie.handleHelp)
 	ie.RegisterCommand(CmdList, ie.handleList)
 	ie.RegisterCommand(CmdQuery, ie.handleQuery)
+	ie.RegisterCommand(CmdExecute, ie.handleExecute)
+	ie.RegisterCommand(CmdSet, ie.handleSet)
+	ie.RegisterCommand(CmdGet, ie.handleGet)
+	ie.RegisterCommand(CmdRun, ie.handleRun)
+	ie.RegisterCommand(CmdPlan, ie.handlePlan)
 }
 
 func (ie *InstructionExecutor) handleHelp(ctx context.Context, args []string) (CommandResult, error) {
+	if len(args) > 0 {
+		desc, err := ie.findUnit(args[0])
+		if err != nil {
+			return CommandResult{Success: false, Error: err}, nil
+		}
+		text := describeUnit(desc)
+		return CommandResult{
+			Success: true,
+			Output:  text,
+			Data:    desc.Description,
+		}, nil
+	}
+
 	helpText := `Available commands:
-  help [command] - Show help information
+  help [unit] - Show help information, or the actions a unit accepts
   list [type] - List available units or resources
   query <target> [args...] - Query information from a unit
-  execute <script> - Execute a script or command
+  execute <language> <code...> - Run go, python or bash code
+  execute <unit> <message...> - Send a message to a unit and show its reply
+  <unit> <message...> - Shorthand for execute <unit> <message...>
   set <key> <value> - Set a configuration value
-  get <key> - Get a configuration value`
+  get <key> - Get a configuration value
+  run <script> - Run a script of instructions, separated by ; or newlines
+  plan <json> - Run a DAG of instructions, independent steps in parallel
+
+Arguments may be quoted as in a shell, and <<TAG starts a multi-line
+argument ending at a line holding TAG. Options such as --timeout=5s apply
+to the instruction; put arguments starting with -- after a -- argument.`
+
+	if uctx := ie.executorCtx(); uctx != nil {
+		var described []string
+		for _, u := range uctx.Units() {
+			if u.Description != nil {
+				described = append(described, fmt.Sprintf("  %s - %s", u.Name, u.Description.Summary))
+			}
+		}
+		if len(described) > 0 {
+			helpText += "\n\nUnits:\n" + strings.Join(described, "\n")
+		}
+	}
 
 	return CommandResult{
 		Success: true,
@@ -180,14 +411,15 @@ func (ie *InstructionExecutor) handleHelp(ctx context.Context, args []string) (C
 }
 
 func (ie *InstructionExecutor) handleList(ctx context.Context, args []string) (CommandResult, error) {
-	if ie.ctx == nil {
+	uctx := ie.executorCtx()
+	if uctx == nil {
 		return CommandResult{
 			Success: false,
 			Error:   fmt.Errorf("context not initialized"),
 		}, nil
 	}
 
-	units := ie.ctx.Units()
+	units := uctx.Units()
 	var unitNames []string
 	for _, unit := range units {
 		unitNames = append(unitNames, unit.Name)
@@ -209,27 +441,234 @@ func (ie *InstructionExecutor) handleQuery(ctx context.Context, args []string) (
 		}, nil
 	}
 
-	target := args[0]
-	if ie.ctx == nil {
+	desc, err := ie.findUnit(args[0])
+	if err != nil {
+		return CommandResult{Success: false, Error: err}, nil
+	}
+
+	return CommandResult{
+		Success: true,
+		Output:  fmt.Sprintf("Found unit: %s\n%s", desc.Name, describeUnit(desc)),
+		Data:    desc,
+	}, nil
+}
+
+// executionResult mirrors the reply of the code unit.
+type executionResult struct {
+	Output   string `json:"output"`
+	Error    string `json:"error,omitempty"`
+	ExitCode int    `json:"exit_code"`
+}
+
+func (ie *InstructionExecutor) handleExecute(ctx context.Context, args []string) (CommandResult, error) {
+	if len(args) < 2 {
 		return CommandResult{
 			Success: false,
-			Error:   fmt.Errorf("context not initialized"),
+			Error:   fmt.Errorf("execute requires a language and code"),
 		}, nil
 	}
 
-	units := ie.ctx.Units()
-	for _, unit := range units {
-		if unit.Name == target {
-			return CommandResult{
-				Success: true,
-				Output:  fmt.Sprintf("Found unit: %s", target),
-				Data:    unit,
-			}, nil
-		}
+	reply, err := ie.ask(ctx, CodeUnit, map[string]any{
+		"action":   "execute",
+		"language": args[0],
+		"code":     joinSource(args[1:]),
+	})
+	if err != nil {
+		return CommandResult{Success: false, Error: err}, nil
+	}
+	result, err := unit.Decode[executionResult](reply)
+	if err != nil {
+		return CommandResult{Success: false, Error: err}, nil
+	}
+
+	cmdResult := CommandResult{
+		Success: result.Error == "" && result.ExitCode == 0,
+		Output:  result.Output,
+		Data:    reply,
+	}
+	if result.Error != "" {
+		cmdResult.Error = errors.New(result.Error)
+	}
+	return cmdResult, nil
+}
+
+// handleSet stores a configuration value. Values are parsed as JSON where
+// they can be, so "set retries 3" stores a number; anything else is stored
+// as a string.
+func (ie *InstructionExecutor) handleSet(ctx context.Context, args []string) (CommandResult, error) {
+	if len(args) < 2 {
+		return CommandResult{
+			Success: false,
+			Error:   fmt.Errorf("set requires a key and value"),
+		}, nil
+	}
+	key := args[0]
+	if err := checkConfigKey(key); err != nil {
+		return CommandResult{Success: false, Error: err}, nil
+	}
+
+	raw := strings.Join(args[1:], " ")
+	var value any
+	if err := json.Unmarshal([]byte(raw), &value); err != nil {
+		value = raw
+	}
+
+	if _, err := ie.ask(ctx, ConfigUnit, map[string]any{
+		"action": "save",
+		"key":    configPrefix + key,
+		"value":  value,
+	}); err != nil {
+		return CommandResult{Success: false, Error: err}, nil
+	}
+	return CommandResult{
+		Success: true,
+		Output:  fmt.Sprintf("%s = %v", key, value),
+		Data:    map[string]any{"key": key, "value": value},
+	}, nil
+}
+
+func (ie *InstructionExecutor) handleGet(ctx context.Context, args []string) (CommandResult, error) {
+	if len(args) == 0 {
+		return CommandResult{
+			Success: false,
+			Error:   fmt.Errorf("get requires a key"),
+		}, nil
+	}
+	key := args[0]
+	if err := checkConfigKey(key); err != nil {
+		return CommandResult{Success: false, Error: err}, nil
 	}
 
+	value, err := ie.ask(ctx, ConfigUnit, map[string]any{
+		"action": "load",
+		"key":    configPrefix + key,
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		err = fmt.Errorf("%w: %s", ErrConfigNotSet, key)
+	}
+	if err != nil {
+		return CommandResult{Success: false, Error: err}, nil
+	}
 	return CommandResult{
-		Success: false,
-		Error:   fmt.Errorf("unit not found: %s", target),
+		Success: true,
+		Output:  fmt.Sprintf("%s = %v", key, value),
+		Data:    map[string]any{"key": key, "value": value},
 	}, nil
 }
+
+// joinSource joins arguments back into code or a script. A single
+// argument is taken as it is; more are quoted as a shell would need.
+func joinSource(args []string) string {
+	if len(args) == 1 {
+		return args[0]
+	}
+	return cmdline.Join(args)
+}
+
+// checkConfigKey keeps keys within the configuration namespace.
+func checkConfigKey(key string) error {
+	if key == "" || strings.ContainsAny(key, `/\`) || strings.Contains(key, "..") {
+		return fmt.Errorf("invalid config key: %q", key)
+	}
+	return nil
+}
+
+// ask sends msg to the named unit and waits for its reply, from the
+// handling unit where there is one.
+func (ie *InstructionExecutor) ask(ctx context.Context, name string, msg any) (any, error) {
+	desc, err := ie.findUnit(name)
+	if err != nil {
+		return nil, err
+	}
+	uctx := ie.unitCtx(ctx)
+	if name == uctx.Self().Name() {
+		return nil, ErrAskSelf
+	}
+	return uctx.Ask(desc.Ref, msg)
+}
+
+// unitCtx returns the handling unit's ctx, which command handlers are
+// given as a context.Context, or the executor's own.
+func (ie *InstructionExecutor) unitCtx(ctx context.Context) unit.Ctx {
+	if uctx, ok := ctx.(unit.Ctx); ok {
+		return uctx
+	}
+	return ie.executorCtx()
+}
+
+// executorCtx returns the ctx the executor was started with, or nil.
+func (ie *InstructionExecutor) executorCtx() unit.Ctx {
+	ie.mu.RLock()
+	defer ie.mu.RUnlock()
+	return ie.ctx
+}
+
+func (ie *InstructionExecutor) findUnit(name string) (unit.UnitDesc, error) {
+	uctx := ie.executorCtx()
+	if uctx == nil {
+		return unit.UnitDesc{}, fmt.Errorf("context not initialized")
+	}
+
+	for _, u := range uctx.Units() {
+		if u.Name == name {
+			return u, nil
+		}
+	}
+	return unit.UnitDesc{}, fmt.Errorf("unit not found: %s", name)
+}
+
+// describeUnit renders a unit's Description as help text, one line per
+// action with its arguments. Required arguments are marked with a *.
+func describeUnit(desc unit.UnitDesc) string {
+	if desc.Description == nil {
+		return fmt.Sprintf("%s does not describe its actions", desc.Name)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s - %s", desc.Name, desc.Description.Summary)
+	for _, action := range desc.Description.Actions {
+		fmt.Fprintf(&b, "\n  %s", action.Name)
+		if args := schemaArgs(action.Args); args != "" {
+			fmt.Fprintf(&b, " {%s}", args)
+		}
+		if action.Summary != "" {
+			fmt.Fprintf(&b, " - %s", action.Summary)
+		}
+	}
+	return b.String()
+}
+
+func schemaArgs(s unit.Schema) string {
+	props, _ := s["properties"].(map[string]any)
+	if len(props) == 0 {
+		return ""
+	}
+
+	required := make(map[string]bool)
+	if names, ok := s["required"].([]string); ok {
+		for _, name := range names {
+			required[name] = true
+		}
+	}
+
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	args := make([]string, len(names))
+	for i, name := range names {
+		arg := name
+		if required[name] {
+			arg += "*"
+		}
+		if p, ok := props[name].(unit.Schema); ok {
+			if typ, ok := p["type"].(string); ok {
+				arg += ": " + typ
+			}
+		}
+		args[i] = arg
+	}
+	return strings.Join(args, ", ")
+}
@@ -2,10 +2,17 @@ package control
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/eliothedeman/smol/cmdline"
+	"github.com/eliothedeman/smol/tools"
 	"github.com/eliothedeman/smol/unit"
+	"github.com/eliothedeman/smol/unit/unittest"
 )
 
 type testUnit struct{}
@@ -97,6 +104,32 @@ func TestParseCommand(t *testing.T) {
 	}
 }
 
+func TestParseCommandQuotingAndOptions(t *testing.T) {
+	ie := NewInstructionExecutor()
+
+	got, err := ie.parseCommand(`set greeting "hello world" --timeout=2s --trace -- --literal`)
+	if err != nil {
+		t.Fatalf("parseCommand failed: %v", err)
+	}
+	want := Instruction{
+		Type:    CmdSet,
+		Action:  "greeting",
+		Args:    []string{"hello world", "--literal"},
+		Context: map[string]any{"timeout": "2s", "trace": "true"},
+		Timeout: 2 * time.Second,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseCommand = %+v, want %+v", got, want)
+	}
+
+	if _, err := ie.parseCommand("query 'unterminated"); !errors.Is(err, cmdline.ErrSyntax) || !strings.Contains(err.Error(), "1:7") {
+		t.Errorf("Expected a syntax error at 1:7, got %v", err)
+	}
+	if _, err := ie.parseCommand("list --timeout=soon"); err == nil {
+		t.Error("Expected an invalid timeout to fail")
+	}
+}
+
 func TestHandleStringCommand(t *testing.T) {
 	ie := NewInstructionExecutor()
 	ctx := &mockCtx{
@@ -233,12 +266,17 @@ func TestConcurrentCommandHandling(t *testing.T) {
 
 	for i := 0; i < 10; i++ {
 		go func() {
-			err := ie.Handle(ctx, from, "help")
-			if err != nil {
-				t.Errorf("Concurrent command failed: %v", err)
+			for _, cmd := range []string{"help", "list", "query test1"} {
+				if err := ie.Handle(ctx, from, cmd); err != nil {
+					t.Errorf("Concurrent %s failed: %v", cmd, err)
+				}
 			}
 			done <- true
 		}()
+		// Registering commands or restarting meanwhile must not race
+		// with their lookup or the units they list.
+		ie.RegisterCommand(CommandType(fmt.Sprintf("extra%d", i)), ie.handleHelp)
+		go ie.Init(ctx)
 	}
 
 	for i := 0; i < 10; i++ {
@@ -249,3 +287,235 @@ func TestConcurrentCommandHandling(t *testing.T) {
 		}
 	}
 }
+
+type describedUnit struct{ testUnit }
+
+func (d *describedUnit) Describe() unit.Description {
+	return unit.Description{
+		Summary: "Adds things",
+		Actions: []unit.ActionDesc{{
+			Name:    "add",
+			Summary: "Add numbers",
+			Args: unit.Object(map[string]unit.Schema{
+				"numbers": unit.ArrayOf(unit.Prop("number", ""), ""),
+			}, "numbers"),
+		}},
+	}
+}
+
+func TestHelpAndQueryUseDescriptions(t *testing.T) {
+	u := &describedUnit{}
+	desc := u.Describe()
+	ie := NewInstructionExecutor()
+	ie.Init(&mockCtx{
+		Context: context.Background(),
+		units: []unit.UnitDesc{
+			{Name: "adder", Proxy: u, Description: &desc},
+			{Name: "plain", Proxy: &testUnit{}},
+		},
+	})
+
+	result, err := ie.handleHelp(context.Background(), nil)
+	if err != nil || !strings.Contains(result.Output, "adder - Adds things") {
+		t.Errorf("help = %q, %v; want the adder summary", result.Output, err)
+	}
+
+	result, err = ie.handleHelp(context.Background(), []string{"adder"})
+	if err != nil || !strings.Contains(result.Output, "add {numbers*: array} - Add numbers") {
+		t.Errorf("help adder = %q, %v; want the add action", result.Output, err)
+	}
+
+	result, err = ie.handleQuery(context.Background(), []string{"adder"})
+	if err != nil || !result.Success || !strings.Contains(result.Output, "add {numbers*: array}") {
+		t.Errorf("query adder = %+v, %v; want the add action", result, err)
+	}
+
+	result, _ = ie.handleQuery(context.Background(), []string{"plain"})
+	if !result.Success || !strings.Contains(result.Output, "does not describe") {
+		t.Errorf("query plain = %+v", result)
+	}
+
+	result, _ = ie.handleHelp(context.Background(), []string{"missing"})
+	if result.Success {
+		t.Error("help for a missing unit should fail")
+	}
+}
+
+func TestExecutorDescribesCommands(t *testing.T) {
+	desc := NewInstructionExecutor().Describe()
+	for _, cmd := range []CommandType{CmdHelp, CmdList, CmdQuery, CmdExecute, CmdSet, CmdGet, CmdRun, CmdPlan} {
+		if _, ok := desc.Action(string(cmd)); !ok {
+			t.Errorf("Describe() is missing %s", cmd)
+		}
+	}
+}
+
+func TestMessagesRoundTripThroughCodecs(t *testing.T) {
+	messages := []any{
+		Instruction{Type: CmdExecute, Action: "add", Args: []string{"1", "2"}, Context: map[string]any{"user": "ops"}},
+		Instruction{Type: CmdList},
+		CommandResult{Success: true, Output: "3", Data: map[string]any{"result": 3.0}},
+	}
+	for _, codec := range unit.Codecs() {
+		for _, msg := range messages {
+			data, err := codec.Marshal(msg)
+			if err != nil {
+				t.Fatalf("%s: Marshal(%+v) failed: %v", codec.Name(), msg, err)
+			}
+			got, err := codec.Unmarshal(data)
+			if err != nil {
+				t.Fatalf("%s: Unmarshal(%+v) failed: %v", codec.Name(), msg, err)
+			}
+			if !reflect.DeepEqual(got, msg) {
+				t.Errorf("%s: round trip of %+v gave %+v", codec.Name(), msg, got)
+			}
+		}
+
+		failed := CommandResult{Output: "partial", Error: errors.New("unit not found: math")}
+		data, err := codec.Marshal(failed)
+		if err != nil {
+			t.Fatalf("%s: Marshal failed: %v", codec.Name(), err)
+		}
+		got, err := codec.Unmarshal(data)
+		if err != nil {
+			t.Fatalf("%s: Unmarshal failed: %v", codec.Name(), err)
+		}
+		result := got.(CommandResult)
+		if result.Output != "partial" || result.Error == nil || result.Error.Error() != "unit not found: math" {
+			t.Errorf("%s: round trip of %+v gave %+v", codec.Name(), failed, result)
+		}
+	}
+}
+
+func TestExecutorReplies(t *testing.T) {
+	h := unittest.New(t)
+	h.Add("math", &testUnit{})
+	h.Add("executor", NewInstructionExecutor())
+	client := h.Probe("client")
+
+	client.Send("executor", "list")
+	reply := client.ExpectReply(unittest.OfType[CommandResult](), 2).(CommandResult)
+	if !reply.Success || !strings.Contains(reply.Output, "math") {
+		t.Errorf("Expected the unit list, got %+v", reply)
+	}
+
+	client.Send("executor", Instruction{Type: "bogus"})
+	client.ExpectNoReply(2)
+	if sent := h.Sent(); sent[len(sent)-1].Err == nil {
+		t.Error("Expected an unknown command to fail")
+	}
+}
+
+func TestExecuteSetAndGet(t *testing.T) {
+	h := unittest.New(t)
+	storage := tools.NewStorage(t.TempDir())
+	storage.CompactInterval = 0
+	h.Add(ConfigUnit, storage)
+	h.Add(CodeUnit, tools.NewCodeExecution(t.TempDir()))
+	h.Add("executor", NewInstructionExecutor())
+	client := h.Probe("client")
+
+	ask := func(msg any) CommandResult {
+		t.Helper()
+		client.Send("executor", msg)
+		return client.ExpectReply(unittest.OfType[CommandResult](), 100).(CommandResult)
+	}
+
+	result := ask("execute bash echo hello")
+	if !result.Success || result.Output != "hello\n" {
+		t.Errorf("execute = %+v; want hello", result)
+	}
+	if _, ok := result.Data.(tools.ExecutionResult); !ok {
+		t.Errorf("execute Data = %T; want tools.ExecutionResult", result.Data)
+	}
+	if result = ask("execute bash exit 3"); result.Success || result.Error == nil {
+		t.Errorf("execute of a failing script = %+v; want a failure", result)
+	}
+	if result = ask("execute cobol DISPLAY"); result.Success {
+		t.Errorf("execute of an unknown language = %+v; want a failure", result)
+	}
+
+	result = ask(Instruction{Type: CmdSet, Action: "retries", Args: []string{"3"}})
+	if !result.Success || !reflect.DeepEqual(result.Data, map[string]any{"key": "retries", "value": 3.0}) {
+		t.Errorf("set = %+v", result)
+	}
+	ask("set greeting hello world")
+
+	result = ask("get retries")
+	if !result.Success || !reflect.DeepEqual(result.Data, map[string]any{"key": "retries", "value": 3.0}) {
+		t.Errorf("get retries = %+v", result)
+	}
+	if result = ask("get greeting"); result.Output != "greeting = hello world" {
+		t.Errorf("get greeting = %+v", result)
+	}
+	if result = ask("get missing"); result.Success || !errors.Is(result.Error, ErrConfigNotSet) {
+		t.Errorf("get missing = %+v; want ErrConfigNotSet", result)
+	}
+	if result = ask("set ../escape 1"); result.Success {
+		t.Errorf("set outside the namespace = %+v; want a failure", result)
+	}
+}
+
+func TestRoutesInstructionsToUnits(t *testing.T) {
+	h := unittest.New(t)
+	h.Add("math", tools.NewMath())
+	h.Add("registers", tools.NewRegisters())
+	h.Add("executor", NewInstructionExecutor())
+	client := h.Probe("client")
+
+	ask := func(msg any) CommandResult {
+		t.Helper()
+		client.Send("executor", msg)
+		return client.ExpectReply(unittest.OfType[CommandResult](), 100).(CommandResult)
+	}
+
+	if result := ask("math add 1 2"); !result.Success || result.Output != "3.000000" {
+		t.Errorf("math add 1 2 = %+v", result)
+	}
+	result := ask(Instruction{Type: CmdExecute, Action: "registers", Args: []string{"set", "x", "5"}})
+	if !result.Success || result.Output != "x = 5" {
+		t.Errorf("execute registers set = %+v", result)
+	}
+	result = ask(`registers '{"action": "get", "name": "x"}'`)
+	if !result.Success || result.Data != int64(5) {
+		t.Errorf("registers get = %+v; want 5", result)
+	}
+	if result = ask("math divide 1 0"); result.Success || result.Error == nil || result.Output != "Error: "+result.Error.Error() {
+		t.Errorf("math divide 1 0 = %+v; want the unit's error in Error and Output", result)
+	}
+	if result = ask("math"); result.Success {
+		t.Errorf("math = %+v; want a failure with nothing to send", result)
+	}
+	for _, cmd := range []string{"executor help", "execute executor list"} {
+		if result = ask(cmd); result.Success || !errors.Is(result.Error, ErrAskSelf) {
+			t.Errorf("%s = %+v; want ErrAskSelf", cmd, result)
+		}
+	}
+}
+
+func TestRoutedInstructionTimeout(t *testing.T) {
+	registry := unit.NewRegistry()
+	registry.Register("silent", &testUnit{})
+	ie := NewInstructionExecutor()
+	ie.Timeout = time.Second
+	registry.Register("executor", ie)
+	if err := registry.Start(); err != nil {
+		t.Fatalf("Failed to start registry: %v", err)
+	}
+	defer registry.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	reply, err := registry.Ask(ctx, "executor", Instruction{Type: "silent", Action: "hello", Timeout: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Ask failed: %v", err)
+	}
+	result := reply.(CommandResult)
+	if result.Success || !errors.Is(result.Error, unit.ErrNoReply) {
+		t.Errorf("Expected the instruction to time out, got %+v", result)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected the instruction's own timeout to apply, took %v", elapsed)
+	}
+}
@@ -83,6 +83,10 @@ func (l *Lifecycle) Handle(ctx unit.Ctx, from unit.UnitRef, message any) error {
 	return nil
 }
 
+func (l *Lifecycle) Terminate(ctx unit.Ctx, reason error) {
+	l.Shutdown()
+}
+
 func (l *Lifecycle) State() LifecycleState {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
@@ -6,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/eliothedeman/smol/metrics"
 	"github.com/eliothedeman/smol/unit"
 )
 
@@ -18,7 +19,11 @@ func (m *mockCtx) Units() []unit.UnitDesc {
 	return m.units
 }
 
-func (m *mockCtx) Spawn(name string, f unit.UnitFactory) unit.UnitRef {
+func (m *mockCtx) Spawn(name string, f unit.UnitFactory, opts ...unit.Option) unit.UnitRef {
+	return &mockUnitRef{name: name}
+}
+
+func (m *mockCtx) SpawnPool(name string, f unit.UnitFactory, size int, opts ...unit.Option) unit.UnitRef {
 	return &mockUnitRef{name: name}
 }
 
@@ -26,8 +31,39 @@ func (m *mockCtx) Self() unit.UnitRef {
 	return &mockUnitRef{name: "test"}
 }
 
+func (m *mockCtx) Send(to unit.UnitRef, msg any) error {
+	return to.Send(msg)
+}
+
+func (m *mockCtx) Ask(to unit.UnitRef, msg any) (any, error) {
+	return m.AskAsync(to, msg).Result()
+}
+
+func (m *mockCtx) AskAsync(to unit.UnitRef, msg any) *unit.Future {
+	return unit.Resolved(nil, unit.ErrNoReply)
+}
+
 func (m *mockCtx) Subscribe(other unit.Unit)   {}
 func (m *mockCtx) Unsubscribe(other unit.Unit) {}
+func (m *mockCtx) Watch(other unit.UnitRef)    {}
+func (m *mockCtx) Unwatch(other unit.UnitRef)  {}
+
+func (m *mockCtx) Publish(topic string, msg any) error { return nil }
+func (m *mockCtx) SubscribeTopic(pattern string) error { return nil }
+func (m *mockCtx) UnsubscribeTopic(pattern string)     {}
+func (m *mockCtx) Metrics() *metrics.Registry          { return metrics.NewRegistry() }
+
+func (m *mockCtx) SendAfter(to unit.UnitRef, msg any, d time.Duration) *unit.Timer {
+	return &unit.Timer{}
+}
+
+func (m *mockCtx) SendEvery(to unit.UnitRef, msg any, interval time.Duration) *unit.Timer {
+	return &unit.Timer{}
+}
+
+func (m *mockCtx) SendOn(to unit.UnitRef, msg any, schedule unit.Schedule) *unit.Timer {
+	return &unit.Timer{}
+}
 
 type mockUnitRef struct {
 	name string
@@ -37,7 +73,7 @@ func (m *mockUnitRef) Name() string {
 	return m.name
 }
 
-func (m *mockUnitRef) Send(msg any) {}
+func (m *mockUnitRef) Send(msg any) error { return nil }
 
 func (m *mockUnitRef) Stop() {}
 
@@ -241,3 +277,15 @@ func TestLifecycleWaitForTasks(t *testing.T) {
 		t.Error("Expected task to complete before shutdown")
 	}
 }
+
+func TestLifecycleTerminate(t *testing.T) {
+	l := NewLifecycle()
+	ctx := &mockCtx{Context: context.Background()}
+	l.Init(ctx)
+
+	l.Terminate(ctx, nil)
+
+	if l.State() != StateStopped {
+		t.Errorf("Expected state after Terminate to be StateStopped, got %v", l.State())
+	}
+}
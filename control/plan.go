@@ -0,0 +1,300 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/eliothedeman/smol/unit"
+)
+
+// DefaultPlanConcurrency caps how many steps of a plan run at once.
+const DefaultPlanConcurrency = 8
+
+var ErrInvalidPlan = errors.New("invalid plan")
+
+// Plan is a DAG of instructions. Each step runs once the steps it depends
+// on have succeeded, and may use their outputs by writing ${id} in its
+// action or arguments.
+type Plan struct {
+	Steps []PlanStep `json:"steps"`
+	// Concurrency caps how many steps run at once; zero means
+	// DefaultPlanConcurrency.
+	Concurrency int `json:"concurrency,omitempty"`
+	// Timeout bounds the whole plan, as Instruction.Timeout does. Each
+	// step is bounded by its own instruction's timeout.
+	Timeout time.Duration `json:"timeout,omitempty"`
+}
+
+type PlanStep struct {
+	ID          string      `json:"id"`
+	Instruction Instruction `json:"instruction"`
+	DependsOn   []string    `json:"depends_on,omitempty"`
+}
+
+type StepStatus string
+
+const (
+	StepSucceeded StepStatus = "succeeded"
+	StepFailed    StepStatus = "failed"
+	// StepCancelled steps were stopped, or never started, because another
+	// step failed.
+	StepCancelled StepStatus = "cancelled"
+)
+
+// PlanResult is the Data of a plan's CommandResult, with a result for
+// each step in the order of the plan.
+type PlanResult struct {
+	Steps    []StepResult
+	Duration time.Duration
+}
+
+type StepResult struct {
+	ID       string
+	Status   StepStatus
+	Output   string
+	Error    string `json:",omitempty"`
+	Data     any
+	Started  time.Time
+	Duration time.Duration
+}
+
+// stepRef matches ${id} references to the outputs of other steps.
+var stepRef = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// handlePlan runs a plan given as JSON, as in plan '{"steps": [...]}'.
+func (ie *InstructionExecutor) handlePlan(ctx context.Context, args []string) (CommandResult, error) {
+	if len(args) == 0 {
+		return CommandResult{
+			Success: false,
+			Error:   fmt.Errorf("plan requires a plan as JSON"),
+		}, nil
+	}
+
+	var plan Plan
+	if err := json.Unmarshal([]byte(strings.Join(args, " ")), &plan); err != nil {
+		return CommandResult{Success: false, Error: fmt.Errorf("%w: %v", ErrInvalidPlan, err)}, nil
+	}
+	return ie.runPlan(ie.unitCtx(ctx), plan), nil
+}
+
+func (ie *InstructionExecutor) handlePlanMessage(ctx unit.Ctx, from unit.UnitRef, plan Plan) error {
+	ctx, cancel := ie.withTimeout(ctx, plan.Timeout)
+	defer cancel()
+	from.Send(ie.runPlan(ctx, plan))
+	return nil
+}
+
+// stepDone is a finished step, reported back to the planner.
+type stepDone struct {
+	index  int
+	result CommandResult
+	err    error
+}
+
+// runPlan runs the plan's steps, each as soon as its dependencies have
+// succeeded and fewer than Concurrency steps are running. The first
+// failure cancels the steps still running and those not yet started.
+//
+// Steps run on their own goroutines, so ctx must be safe for concurrent
+// use, as the registry's is. Test harnesses can run plans with a
+// Concurrency of 1.
+func (ie *InstructionExecutor) runPlan(ctx unit.Ctx, plan Plan) CommandResult {
+	dependents, err := checkPlan(plan)
+	if err != nil {
+		return CommandResult{Success: false, Error: err}
+	}
+
+	if plan.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = unit.WithTimeout(ctx, plan.Timeout)
+		defer cancel()
+	}
+	ctx, cancel := unit.WithCancel(ctx)
+	defer cancel()
+
+	limit := plan.Concurrency
+	if limit <= 0 {
+		limit = DefaultPlanConcurrency
+	}
+
+	start := time.Now()
+	results := make([]StepResult, len(plan.Steps))
+	waiting := make([]int, len(plan.Steps))
+	var ready []int
+	for i, step := range plan.Steps {
+		results[i] = StepResult{ID: step.ID, Status: StepCancelled}
+		waiting[i] = len(step.DependsOn)
+		if waiting[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	done := make(chan stepDone)
+	running := 0
+	var failure error
+	for {
+		for failure == nil && len(ready) > 0 && running < limit {
+			i := ready[0]
+			ready = ready[1:]
+			instruction := resolveStep(plan.Steps[i].Instruction, plan, results)
+			results[i].Started = time.Now()
+			running++
+			go func() {
+				ctx, cancel := ie.withTimeout(ctx, instruction.Timeout)
+				defer cancel()
+				result, err := ie.execute(ctx, instruction)
+				done <- stepDone{index: i, result: result, err: err}
+			}()
+		}
+		if running == 0 {
+			break
+		}
+
+		d := <-done
+		running--
+		r := &results[d.index]
+		r.Duration = time.Since(r.Started)
+		r.Output = d.result.Output
+		r.Data = d.result.Data
+
+		err := d.err
+		if err == nil && !d.result.Success {
+			err = d.result.Error
+			if err == nil {
+				err = errors.New(d.result.Output)
+			}
+		}
+		switch {
+		case err == nil:
+			r.Status = StepSucceeded
+			for _, j := range dependents[plan.Steps[d.index].ID] {
+				if waiting[j]--; waiting[j] == 0 {
+					ready = append(ready, j)
+				}
+			}
+		case failure != nil && errors.Is(err, context.Canceled):
+			r.Error = err.Error()
+		default:
+			r.Status = StepFailed
+			r.Error = err.Error()
+			if failure == nil {
+				failure = fmt.Errorf("step %s: %w", r.ID, err)
+				cancel()
+			}
+		}
+	}
+
+	result := CommandResult{
+		Success: failure == nil,
+		Error:   failure,
+		Data:    PlanResult{Steps: results, Duration: time.Since(start)},
+	}
+	if failure == nil {
+		result.Output = planOutput(plan, results, dependents)
+	}
+	return result
+}
+
+// planOutput joins the outputs of the steps no other step depends on.
+func planOutput(plan Plan, results []StepResult, dependents map[string][]int) string {
+	var outputs []string
+	for i, step := range plan.Steps {
+		if len(dependents[step.ID]) == 0 {
+			outputs = append(outputs, results[i].Output)
+		}
+	}
+	return strings.Join(outputs, "\n")
+}
+
+// checkPlan checks that the plan's steps form a DAG and refer only to
+// the steps they depend on. It returns the steps depending on each step.
+func checkPlan(plan Plan) (map[string][]int, error) {
+	if len(plan.Steps) == 0 {
+		return nil, fmt.Errorf("%w: no steps", ErrInvalidPlan)
+	}
+
+	index := make(map[string]int, len(plan.Steps))
+	for i, step := range plan.Steps {
+		if step.ID == "" {
+			return nil, fmt.Errorf("%w: step %d has no id", ErrInvalidPlan, i+1)
+		}
+		if _, dup := index[step.ID]; dup {
+			return nil, fmt.Errorf("%w: duplicate step %s", ErrInvalidPlan, step.ID)
+		}
+		index[step.ID] = i
+	}
+
+	dependents := make(map[string][]int)
+	for i, step := range plan.Steps {
+		declared := make(map[string]bool, len(step.DependsOn))
+		for _, dep := range step.DependsOn {
+			if _, ok := index[dep]; !ok {
+				return nil, fmt.Errorf("%w: step %s depends on unknown step %s", ErrInvalidPlan, step.ID, dep)
+			}
+			if declared[dep] {
+				return nil, fmt.Errorf("%w: step %s depends on %s twice", ErrInvalidPlan, step.ID, dep)
+			}
+			declared[dep] = true
+			dependents[dep] = append(dependents[dep], i)
+		}
+		for _, text := range append([]string{step.Instruction.Action}, step.Instruction.Args...) {
+			for _, m := range stepRef.FindAllStringSubmatch(text, -1) {
+				if !declared[m[1]] {
+					return nil, fmt.Errorf("%w: step %s uses ${%s} without depending on it", ErrInvalidPlan, step.ID, m[1])
+				}
+			}
+		}
+	}
+
+	// Kahn's algorithm: a cycle leaves steps that never become ready.
+	waiting := make([]int, len(plan.Steps))
+	var ready []int
+	for i, step := range plan.Steps {
+		waiting[i] = len(step.DependsOn)
+		if waiting[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+	visited := 0
+	for len(ready) > 0 {
+		i := ready[0]
+		ready = ready[1:]
+		visited++
+		for _, j := range dependents[plan.Steps[i].ID] {
+			if waiting[j]--; waiting[j] == 0 {
+				ready = append(ready, j)
+			}
+		}
+	}
+	if visited < len(plan.Steps) {
+		return nil, fmt.Errorf("%w: steps depend on each other in a cycle", ErrInvalidPlan)
+	}
+	return dependents, nil
+}
+
+// resolveStep replaces ${id} in the instruction with the output of step
+// id, which has finished.
+func resolveStep(instruction Instruction, plan Plan, results []StepResult) Instruction {
+	outputs := make(map[string]string, len(results))
+	for i, step := range plan.Steps {
+		outputs[step.ID] = results[i].Output
+	}
+	resolve := func(s string) string {
+		return stepRef.ReplaceAllStringFunc(s, func(ref string) string {
+			return outputs[ref[2:len(ref)-1]]
+		})
+	}
+
+	instruction.Action = resolve(instruction.Action)
+	args := make([]string, len(instruction.Args))
+	for i, arg := range instruction.Args {
+		args[i] = resolve(arg)
+	}
+	instruction.Args = args
+	return instruction
+}
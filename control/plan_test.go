@@ -0,0 +1,234 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eliothedeman/smol/tools"
+	"github.com/eliothedeman/smol/unit"
+)
+
+// busyUnit takes a while to reply, counting how many busy units are
+// working at once.
+type busyUnit struct {
+	active, peak *atomic.Int32
+	release      chan struct{}
+}
+
+func (b *busyUnit) Init(ctx unit.Ctx) {}
+
+func (b *busyUnit) Handle(ctx unit.Ctx, from unit.UnitRef, message any) error {
+	n := b.active.Add(1)
+	for p := b.peak.Load(); n > p && !b.peak.CompareAndSwap(p, n); p = b.peak.Load() {
+	}
+	if b.release != nil {
+		<-b.release
+	} else {
+		time.Sleep(20 * time.Millisecond)
+	}
+	b.active.Add(-1)
+	return from.Send(ctx.Self().Name())
+}
+
+func startPlanner(t *testing.T, units map[string]unit.Unit) *unit.Registry {
+	t.Helper()
+	registry := unit.NewRegistry()
+	registry.Register("math", tools.NewMath())
+	for name, u := range units {
+		registry.Register(name, u)
+	}
+	registry.Register("executor", NewInstructionExecutor())
+	if err := registry.Start(); err != nil {
+		t.Fatalf("Failed to start registry: %v", err)
+	}
+	t.Cleanup(registry.Stop)
+	return registry
+}
+
+func askPlan(t *testing.T, registry *unit.Registry, msg any) (CommandResult, PlanResult) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	reply, err := registry.Ask(ctx, "executor", msg)
+	if err != nil {
+		t.Fatalf("Ask failed: %v", err)
+	}
+	result := reply.(CommandResult)
+	planResult, _ := result.Data.(PlanResult)
+	return result, planResult
+}
+
+func step(id, command string, deps ...string) PlanStep {
+	ie := NewInstructionExecutor()
+	instruction, err := ie.parseCommand(command)
+	if err != nil {
+		panic(err)
+	}
+	return PlanStep{ID: id, Instruction: instruction, DependsOn: deps}
+}
+
+func TestPlanPassesOutputsAlongTheDAG(t *testing.T) {
+	registry := startPlanner(t, nil)
+
+	result, plan := askPlan(t, registry, Plan{Steps: []PlanStep{
+		step("sum", "math add ${a} ${b}", "a", "b"),
+		step("a", "math add 1 2"),
+		step("b", "math mul 2 2"),
+		step("double", "math mul ${sum} 2", "sum"),
+		step("other", "math add 0 1"),
+	}})
+	if !result.Success || result.Output != "14.000000\n1.000000" {
+		t.Fatalf("Expected the outputs of double and other, got %+v", result)
+	}
+
+	byID := map[string]StepResult{}
+	for _, s := range plan.Steps {
+		if s.Status != StepSucceeded || s.Started.IsZero() {
+			t.Errorf("Step %s: %+v", s.ID, s)
+		}
+		byID[s.ID] = s
+	}
+	if byID["sum"].Output != "7.000000" {
+		t.Errorf("sum = %q, want 7.000000", byID["sum"].Output)
+	}
+	for _, dep := range []string{"a", "b"} {
+		if finished := byID[dep].Started.Add(byID[dep].Duration); byID["sum"].Started.Before(finished) {
+			t.Errorf("sum started before %s finished", dep)
+		}
+	}
+}
+
+func TestPlanConcurrencyCap(t *testing.T) {
+	var active, peak atomic.Int32
+	units := map[string]unit.Unit{}
+	var steps []PlanStep
+	for i := 1; i <= 6; i++ {
+		name := fmt.Sprintf("busy%d", i)
+		units[name] = &busyUnit{active: &active, peak: &peak}
+		steps = append(steps, step(name, name+" work"))
+	}
+	registry := startPlanner(t, units)
+
+	result, _ := askPlan(t, registry, Plan{Steps: steps, Concurrency: 3})
+	if !result.Success {
+		t.Fatalf("Plan failed: %+v", result)
+	}
+	if got := peak.Load(); got != 3 {
+		t.Errorf("Expected 3 steps at once, got %d", got)
+	}
+}
+
+func TestPlanCancelsOnFailure(t *testing.T) {
+	var active, peak atomic.Int32
+	release := make(chan struct{})
+	registry := startPlanner(t, map[string]unit.Unit{
+		"stuck": &busyUnit{active: &active, peak: &peak, release: release},
+	})
+	// Let the stuck unit go before the registry stops.
+	t.Cleanup(func() { close(release) })
+
+	start := time.Now()
+	result, plan := askPlan(t, registry, Plan{Steps: []PlanStep{
+		step("stuck", "stuck work"),
+		step("bad", "math divide 1 0"),
+		step("after", "math add ${stuck} 1", "stuck"),
+	}})
+	if result.Success || result.Error == nil || time.Since(start) > time.Second {
+		t.Fatalf("Expected the plan to fail fast, got %+v after %v", result, time.Since(start))
+	}
+
+	want := map[string]StepStatus{"stuck": StepCancelled, "bad": StepFailed, "after": StepCancelled}
+	for _, s := range plan.Steps {
+		if s.Status != want[s.ID] {
+			t.Errorf("Step %s is %s, want %s", s.ID, s.Status, want[s.ID])
+		}
+	}
+	if !plan.Steps[2].Started.IsZero() {
+		t.Error("Expected the dependent step never to start")
+	}
+}
+
+func TestPlanForms(t *testing.T) {
+	registry := startPlanner(t, nil)
+
+	json := `{"steps": [
+		{"id": "a", "instruction": {"type": "math", "action": "add", "args": ["1", "1"]}},
+		{"id": "b", "instruction": {"type": "math", "action": "mul", "args": ["${a}", "3"]}, "depends_on": ["a"]}
+	], "concurrency": 1}`
+	if result, _ := askPlan(t, registry, "plan '"+json+"'"); !result.Success || result.Output != "6.000000" {
+		t.Errorf("String plan = %+v", result)
+	}
+
+	m := map[string]any{
+		"type": "plan",
+		"steps": []any{
+			map[string]any{"id": "only", "instruction": map[string]any{"type": "math", "action": "add", "args": []any{"2", "2"}}},
+		},
+	}
+	if result, _ := askPlan(t, registry, m); !result.Success || result.Output != "4.000000" {
+		t.Errorf("Map plan = %+v", result)
+	}
+}
+
+func TestPlanValidation(t *testing.T) {
+	plans := map[string]Plan{
+		"empty":      {},
+		"no id":      {Steps: []PlanStep{step("", "list")}},
+		"duplicate":  {Steps: []PlanStep{step("a", "list"), step("a", "list")}},
+		"unknown":    {Steps: []PlanStep{step("a", "list", "missing")}},
+		"cycle":      {Steps: []PlanStep{step("a", "list", "c"), step("b", "list", "a"), step("c", "list", "b")}},
+		"undeclared": {Steps: []PlanStep{step("a", "list"), step("b", "math add ${a} 1")}},
+	}
+	ie := NewInstructionExecutor()
+	for name, plan := range plans {
+		if result := ie.runPlan(nil, plan); result.Success || !errors.Is(result.Error, ErrInvalidPlan) {
+			t.Errorf("%s: expected ErrInvalidPlan, got %+v", name, result)
+		}
+	}
+}
+
+func TestPlanStepTimeout(t *testing.T) {
+	var active, peak atomic.Int32
+	release := make(chan struct{})
+	registry := startPlanner(t, map[string]unit.Unit{
+		"stuck": &busyUnit{active: &active, peak: &peak, release: release},
+	})
+	t.Cleanup(func() { close(release) })
+
+	stuck := step("stuck", "stuck work")
+	stuck.Instruction.Timeout = 50 * time.Millisecond
+	start := time.Now()
+	result, plan := askPlan(t, registry, Plan{Steps: []PlanStep{stuck}})
+	if result.Success || time.Since(start) > time.Second {
+		t.Fatalf("Expected the step to time out, got %+v after %v", result, time.Since(start))
+	}
+	if plan.Steps[0].Status != StepFailed {
+		t.Errorf("Step is %s, want %s", plan.Steps[0].Status, StepFailed)
+	}
+}
+
+func TestPlanTimeoutOutlastsExecutorTimeout(t *testing.T) {
+	var active, peak atomic.Int32
+	release := make(chan struct{})
+	registry := unit.NewRegistry()
+	registry.Register("slow", &busyUnit{active: &active, peak: &peak, release: release})
+	ie := NewInstructionExecutor()
+	ie.Timeout = 50 * time.Millisecond
+	registry.Register("executor", ie)
+	if err := registry.Start(); err != nil {
+		t.Fatalf("Failed to start registry: %v", err)
+	}
+	t.Cleanup(registry.Stop)
+	time.AfterFunc(200*time.Millisecond, func() { close(release) })
+
+	slow := step("slow", "slow work")
+	slow.Instruction.Timeout = 5 * time.Second
+	result, _ := askPlan(t, registry, Plan{Steps: []PlanStep{slow}, Timeout: 5 * time.Second})
+	if !result.Success || result.Output != "slow" {
+		t.Errorf("Expected the plan's own timeout to apply, got %+v", result)
+	}
+}
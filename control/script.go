@@ -0,0 +1,728 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/eliothedeman/smol/cmdline"
+	"github.com/eliothedeman/smol/unit"
+)
+
+// VarUnit holds script variables, as registers.
+const VarUnit = "registers"
+
+// maxScriptSteps bounds how many statements a script may run, so that a
+// runaway loop cannot hold the executor forever.
+const maxScriptSteps = 1000
+
+var (
+	ErrScriptSyntax  = errors.New("script syntax error")
+	ErrScriptTooLong = errors.New("script ran too many steps")
+	ErrScriptFailed  = errors.New("script failed")
+	ErrUndefinedVar  = errors.New("undefined variable")
+
+	// errScriptAborted marks errors that try cannot catch.
+	errScriptAborted = errors.New("script aborted")
+)
+
+var scriptComparisons = map[string]bool{"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true}
+
+// ScriptResult is the Data of a script's CommandResult: a trace of every
+// command it ran.
+type ScriptResult struct {
+	Steps []ScriptStep
+}
+
+type ScriptStep struct {
+	Line    int
+	Command string
+	Success bool
+	Output  string
+	Error   string `json:",omitempty"`
+}
+
+// handleRun runs a script. Scripts are made of instructions, one per line
+// or separated by ";", and these statements:
+//
+//	let x = storage load x | math sqrt   bind the output to register x
+//	if $x > 2 ... else ... end           compare with == != < <= > >=, or
+//	                                     test whether a command succeeds
+//	while <condition> ... end
+//	for item in a b c ... end
+//	try ... on error ... end             $error holds the failure
+//	fail <message>
+//
+// Words are quoted and escaped as at the prompt; see cmdline.Split. A
+// pipe passes a command's output as the last argument of the next.
+// Values are passed as text, as in a shell. Each run keeps its registers
+// under a prefix of its own, such as "run1.x", and clears them when it
+// ends. The script runs within one instruction's timeout and stops at the
+// first error outside a try.
+func (ie *InstructionExecutor) handleRun(ctx context.Context, args []string) (CommandResult, error) {
+	if len(args) == 0 {
+		return CommandResult{
+			Success: false,
+			Error:   fmt.Errorf("run requires a script"),
+		}, nil
+	}
+
+	body, err := parseScript(joinSource(args))
+	if err != nil {
+		return CommandResult{Success: false, Error: err}, nil
+	}
+
+	r := &scriptRun{
+		ie:     ie,
+		ctx:    ie.unitCtx(ctx),
+		prefix: fmt.Sprintf("run%d.", ie.runs.Add(1)),
+	}
+	err = r.block(body)
+	r.clearVars()
+	result := CommandResult{
+		Success: err == nil,
+		Output:  r.output,
+		Error:   err,
+		Data:    ScriptResult{Steps: r.steps},
+	}
+	return result, nil
+}
+
+// A script is parsed into statements made of words. Words are kept in
+// segments so that variables are expanded only when the statement runs.
+type (
+	segment struct {
+		text     string
+		variable bool
+	}
+
+	word struct {
+		segs []segment
+		// quoted words are never keywords, operators or pipes.
+		quoted bool
+		pipe   bool
+	}
+
+	scriptLine struct {
+		num   int
+		words []word
+	}
+
+	stmt interface{ line() int }
+
+	cmdStmt struct {
+		num      int
+		pipeline [][]word
+	}
+
+	letStmt struct {
+		num      int
+		name     string
+		pipeline [][]word
+	}
+
+	ifStmt struct {
+		num       int
+		cond      condition
+		then, els []stmt
+	}
+
+	whileStmt struct {
+		num  int
+		cond condition
+		body []stmt
+	}
+
+	forStmt struct {
+		num   int
+		name  string
+		items []word
+		body  []stmt
+	}
+
+	tryStmt struct {
+		num           int
+		body, handler []stmt
+	}
+
+	failStmt struct {
+		num     int
+		message []word
+	}
+
+	// condition is either a comparison of two words, or a pipeline that
+	// holds if it succeeds.
+	condition struct {
+		left, right word
+		op          string
+		pipeline    [][]word
+	}
+)
+
+func (s cmdStmt) line() int   { return s.num }
+func (s letStmt) line() int   { return s.num }
+func (s ifStmt) line() int    { return s.num }
+func (s whileStmt) line() int { return s.num }
+func (s forStmt) line() int   { return s.num }
+func (s tryStmt) line() int   { return s.num }
+func (s failStmt) line() int  { return s.num }
+
+// keyword returns the word's text if it could be a keyword or operator.
+func (w word) keyword() string {
+	if w.quoted || w.pipe || len(w.segs) != 1 || w.segs[0].variable {
+		return ""
+	}
+	return w.segs[0].text
+}
+
+func (w word) String() string {
+	if w.pipe {
+		return "|"
+	}
+	var b strings.Builder
+	for _, s := range w.segs {
+		if s.variable {
+			b.WriteString("$")
+		}
+		b.WriteString(s.text)
+	}
+	return b.String()
+}
+
+func syntaxError(line int, format string, args ...any) error {
+	return fmt.Errorf("%w: line %d: %s", ErrScriptSyntax, line, fmt.Sprintf(format, args...))
+}
+
+// scriptLexer splits scripts with the quoting and escapes of commands
+// given at the prompt.
+var scriptLexer = cmdline.Lexer{Operators: "\n;|", Comments: true}
+
+// tokenize splits a script into statements of words. Variables are
+// expanded in unquoted and double-quoted text, and # starts a comment.
+func tokenize(src string) ([]scriptLine, error) {
+	words, err := scriptLexer.Words(src)
+	if err != nil {
+		var se *cmdline.SyntaxError
+		if errors.As(err, &se) {
+			return nil, syntaxError(se.Pos.Line, "%s", se.Msg)
+		}
+		return nil, err
+	}
+
+	var lines []scriptLine
+	var cur scriptLine
+	for _, w := range words {
+		switch w.Op {
+		case '\n', ';':
+			if len(cur.words) > 0 {
+				lines = append(lines, cur)
+			}
+			cur = scriptLine{}
+			continue
+		}
+		if len(cur.words) == 0 {
+			cur.num = w.Pos.Line
+		}
+		if w.Op == '|' {
+			cur.words = append(cur.words, word{pipe: true})
+			continue
+		}
+		cur.words = append(cur.words, scriptWord(w))
+	}
+	if len(cur.words) > 0 {
+		lines = append(lines, cur)
+	}
+	return lines, nil
+}
+
+// scriptWord splits the unquoted and double-quoted text of w around the
+// variables it names.
+func scriptWord(w cmdline.Word) word {
+	out := word{quoted: w.Quoted()}
+	for _, p := range w.Parts {
+		text := p.Text
+		if p.Quote == 0 || p.Quote == '"' {
+			for {
+				i := strings.IndexByte(text, '$')
+				if i < 0 {
+					break
+				}
+				end := i + 1 + nameLen(text[i+1:])
+				if end == i+1 {
+					out.addText(text[:i+1])
+					text = text[i+1:]
+					continue
+				}
+				out.addText(text[:i])
+				out.segs = append(out.segs, segment{text: text[i+1 : end], variable: true})
+				text = text[end:]
+			}
+		}
+		out.addText(text)
+	}
+	return out
+}
+
+// addText appends literal text to the word.
+func (w *word) addText(text string) {
+	if text == "" {
+		return
+	}
+	if n := len(w.segs); n > 0 && !w.segs[n-1].variable {
+		w.segs[n-1].text += text
+		return
+	}
+	w.segs = append(w.segs, segment{text: text})
+}
+
+// nameLen is the length of the variable name at the start of s.
+func nameLen(s string) int {
+	for i, c := range s {
+		if c != '_' && !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') {
+			return i
+		}
+	}
+	return len(s)
+}
+
+func parseScript(src string) ([]stmt, error) {
+	lines, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &scriptParser{lines: lines}
+	body, end, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	if end != nil {
+		return nil, syntaxError(end.num, "unexpected %s", end.words[0])
+	}
+	return body, nil
+}
+
+type scriptParser struct {
+	lines []scriptLine
+	pos   int
+}
+
+// block parses statements up to a line starting with end, else or on,
+// which it returns. It returns a nil line at the end of the script.
+func (p *scriptParser) block() ([]stmt, *scriptLine, error) {
+	var body []stmt
+	for p.pos < len(p.lines) {
+		l := &p.lines[p.pos]
+		switch l.words[0].keyword() {
+		case "end", "else", "on":
+			p.pos++
+			return body, l, nil
+		}
+		p.pos++
+		s, err := p.statement(l)
+		if err != nil {
+			return nil, nil, err
+		}
+		body = append(body, s)
+	}
+	return body, nil, nil
+}
+
+// blockUntil parses a block that must be closed by one of the keywords.
+func (p *scriptParser) blockUntil(start int, keywords ...string) ([]stmt, *scriptLine, error) {
+	body, end, err := p.block()
+	if err != nil {
+		return nil, nil, err
+	}
+	if end == nil {
+		return nil, nil, syntaxError(start, "missing %s", keywords[len(keywords)-1])
+	}
+	for _, k := range keywords {
+		if end.words[0].keyword() == k {
+			return body, end, nil
+		}
+	}
+	return nil, nil, syntaxError(end.num, "unexpected %s", end.words[0])
+}
+
+func (p *scriptParser) statement(l *scriptLine) (stmt, error) {
+	words := l.words
+	switch words[0].keyword() {
+	case "let":
+		if len(words) < 4 || words[2].keyword() != "=" || !isName(words[1]) {
+			return nil, syntaxError(l.num, "want let <name> = <command>")
+		}
+		pipeline, err := parsePipeline(l.num, words[3:])
+		if err != nil {
+			return nil, err
+		}
+		return letStmt{num: l.num, name: words[1].keyword(), pipeline: pipeline}, nil
+
+	case "if":
+		cond, err := parseCondition(l.num, words[1:])
+		if err != nil {
+			return nil, err
+		}
+		s := ifStmt{num: l.num, cond: cond}
+		then, end, err := p.blockUntil(l.num, "else", "end")
+		if err != nil {
+			return nil, err
+		}
+		s.then = then
+		if end.words[0].keyword() == "else" {
+			if s.els, _, err = p.blockUntil(end.num, "end"); err != nil {
+				return nil, err
+			}
+		}
+		return s, nil
+
+	case "while":
+		cond, err := parseCondition(l.num, words[1:])
+		if err != nil {
+			return nil, err
+		}
+		body, _, err := p.blockUntil(l.num, "end")
+		if err != nil {
+			return nil, err
+		}
+		return whileStmt{num: l.num, cond: cond, body: body}, nil
+
+	case "for":
+		if len(words) < 3 || !isName(words[1]) || words[2].keyword() != "in" {
+			return nil, syntaxError(l.num, "want for <name> in <items...>")
+		}
+		body, _, err := p.blockUntil(l.num, "end")
+		if err != nil {
+			return nil, err
+		}
+		return forStmt{num: l.num, name: words[1].keyword(), items: words[3:], body: body}, nil
+
+	case "try":
+		if len(words) != 1 {
+			return nil, syntaxError(l.num, "try takes no arguments")
+		}
+		body, end, err := p.blockUntil(l.num, "on")
+		if err != nil {
+			return nil, err
+		}
+		if len(end.words) != 2 || end.words[1].keyword() != "error" {
+			return nil, syntaxError(end.num, "want on error")
+		}
+		handler, _, err := p.blockUntil(end.num, "end")
+		if err != nil {
+			return nil, err
+		}
+		return tryStmt{num: l.num, body: body, handler: handler}, nil
+
+	case "fail":
+		return failStmt{num: l.num, message: words[1:]}, nil
+	}
+
+	pipeline, err := parsePipeline(l.num, words)
+	if err != nil {
+		return nil, err
+	}
+	return cmdStmt{num: l.num, pipeline: pipeline}, nil
+}
+
+func isName(w word) bool {
+	name := w.keyword()
+	return name != "" && nameLen(name) == len(name)
+}
+
+func parsePipeline(line int, words []word) ([][]word, error) {
+	var pipeline [][]word
+	var cmd []word
+	for _, w := range words {
+		if w.pipe {
+			if len(cmd) == 0 {
+				return nil, syntaxError(line, "empty command in pipeline")
+			}
+			pipeline = append(pipeline, cmd)
+			cmd = nil
+			continue
+		}
+		cmd = append(cmd, w)
+	}
+	if len(cmd) == 0 {
+		return nil, syntaxError(line, "empty command in pipeline")
+	}
+	return append(pipeline, cmd), nil
+}
+
+func parseCondition(line int, words []word) (condition, error) {
+	if len(words) == 0 {
+		return condition{}, syntaxError(line, "missing condition")
+	}
+	if len(words) == 3 && scriptComparisons[words[1].keyword()] {
+		return condition{left: words[0], op: words[1].keyword(), right: words[2]}, nil
+	}
+	pipeline, err := parsePipeline(line, words)
+	return condition{pipeline: pipeline}, err
+}
+
+// scriptRun is one run of a script.
+type scriptRun struct {
+	ie     *InstructionExecutor
+	ctx    unit.Ctx
+	prefix string
+	// bound is set once the run has set a register.
+	bound  bool
+	steps  []ScriptStep
+	count  int
+	output string
+}
+
+func (r *scriptRun) block(body []stmt) error {
+	for _, s := range body {
+		if err := r.statement(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *scriptRun) statement(s stmt) error {
+	r.count++
+	if r.count > maxScriptSteps {
+		return fmt.Errorf("%w: line %d: %w", errScriptAborted, s.line(), ErrScriptTooLong)
+	}
+	if err := r.ctx.Err(); err != nil {
+		return fmt.Errorf("%w: line %d: %w", errScriptAborted, s.line(), err)
+	}
+
+	switch s := s.(type) {
+	case cmdStmt:
+		_, err := r.pipeline(s.num, s.pipeline)
+		return err
+
+	case letStmt:
+		out, err := r.pipeline(s.num, s.pipeline)
+		if err != nil {
+			return err
+		}
+		return r.setVar(s.num, s.name, out)
+
+	case ifStmt:
+		ok, err := r.condition(s.num, s.cond)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return r.block(s.then)
+		}
+		return r.block(s.els)
+
+	case whileStmt:
+		for {
+			ok, err := r.condition(s.num, s.cond)
+			if err != nil || !ok {
+				return err
+			}
+			if err := r.block(s.body); err != nil {
+				return err
+			}
+			r.count++
+			if r.count > maxScriptSteps {
+				return fmt.Errorf("%w: line %d: %w", errScriptAborted, s.num, ErrScriptTooLong)
+			}
+		}
+
+	case forStmt:
+		items, err := r.expand(s.num, s.items)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			if err := r.setVar(s.num, s.name, item); err != nil {
+				return err
+			}
+			if err := r.block(s.body); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case tryStmt:
+		err := r.block(s.body)
+		if err == nil || errors.Is(err, errScriptAborted) {
+			return err
+		}
+		if err := r.setVar(s.num, "error", err.Error()); err != nil {
+			return err
+		}
+		return r.block(s.handler)
+
+	case failStmt:
+		message, err := r.expand(s.num, s.message)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: line %d: %s", ErrScriptFailed, s.num, strings.Join(message, " "))
+	}
+	return nil
+}
+
+// pipeline runs each command, appending its output to the next one's
+// arguments, and returns the last output.
+func (r *scriptRun) pipeline(line int, pipeline [][]word) (string, error) {
+	var piped *string
+	for _, cmd := range pipeline {
+		args, err := r.expand(line, cmd)
+		if err != nil {
+			return "", err
+		}
+		if piped != nil {
+			args = append(args, *piped)
+		}
+		out, err := r.run(line, args)
+		if err != nil {
+			return "", err
+		}
+		piped = &out
+	}
+	return *piped, nil
+}
+
+// run executes one instruction and records it as a step.
+func (r *scriptRun) run(line int, args []string) (string, error) {
+	instruction := Instruction{Type: CommandType(strings.ToLower(args[0]))}
+	if len(args) > 1 {
+		instruction.Action = args[1]
+	}
+	if len(args) > 2 {
+		instruction.Args = args[2:]
+	}
+
+	result, err := r.ie.execute(r.ctx, instruction)
+	if err == nil && !result.Success {
+		err = result.Error
+		if err == nil {
+			err = errors.New(result.Output)
+		}
+	}
+
+	step := ScriptStep{
+		Line:    line,
+		Command: strings.Join(args, " "),
+		Success: err == nil,
+		Output:  result.Output,
+	}
+	if err != nil {
+		step.Error = err.Error()
+	}
+	r.steps = append(r.steps, step)
+	if err != nil {
+		return "", fmt.Errorf("line %d: %s: %w", line, args[0], err)
+	}
+	r.output = result.Output
+	return result.Output, nil
+}
+
+func (r *scriptRun) condition(line int, cond condition) (bool, error) {
+	if cond.pipeline != nil {
+		_, err := r.pipeline(line, cond.pipeline)
+		if errors.Is(err, errScriptAborted) {
+			return false, err
+		}
+		return err == nil, nil
+	}
+
+	operands, err := r.expand(line, []word{cond.left, cond.right})
+	if err != nil {
+		return false, err
+	}
+	return compare(operands[0], cond.op, operands[1]), nil
+}
+
+// compare compares numbers by value and anything else as text.
+func compare(left, op, right string) bool {
+	c := strings.Compare(left, right)
+	l, lerr := strconv.ParseFloat(left, 64)
+	r, rerr := strconv.ParseFloat(right, 64)
+	if lerr == nil && rerr == nil {
+		switch {
+		case l < r:
+			c = -1
+		case l > r:
+			c = 1
+		default:
+			c = 0
+		}
+	}
+
+	switch op {
+	case "==":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	default:
+		return c >= 0
+	}
+}
+
+// expand replaces variables in words with their values.
+func (r *scriptRun) expand(line int, words []word) ([]string, error) {
+	out := make([]string, len(words))
+	for i, w := range words {
+		var b strings.Builder
+		for _, s := range w.segs {
+			if !s.variable {
+				b.WriteString(s.text)
+				continue
+			}
+			value, err := r.getVar(line, s.text)
+			if err != nil {
+				return nil, err
+			}
+			b.WriteString(value)
+		}
+		out[i] = b.String()
+	}
+	return out, nil
+}
+
+func (r *scriptRun) setVar(line int, name, value string) error {
+	r.bound = true
+	if _, err := r.ie.ask(r.ctx, VarUnit, map[string]any{
+		"action": "set",
+		"name":   r.prefix + name,
+		"value":  value,
+	}); err != nil {
+		return fmt.Errorf("line %d: set %s: %w", line, name, err)
+	}
+	return nil
+}
+
+func (r *scriptRun) getVar(line int, name string) (string, error) {
+	value, err := r.ie.ask(r.ctx, VarUnit, map[string]any{
+		"action": "get",
+		"name":   r.prefix + name,
+	})
+	if err != nil {
+		return "", fmt.Errorf("line %d: %w: %s", line, ErrUndefinedVar, name)
+	}
+	return fmt.Sprint(value), nil
+}
+
+// clearVars removes the run's registers. It uses the executor's own ctx,
+// as the run's may have timed out.
+func (r *scriptRun) clearVars() {
+	if !r.bound {
+		return
+	}
+	if _, err := r.ie.ask(context.Background(), VarUnit, map[string]any{
+		"action": "clear",
+		"prefix": r.prefix,
+	}); err != nil {
+		log.Printf("script: clearing %s* registers: %v", r.prefix, err)
+	}
+}
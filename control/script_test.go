@@ -0,0 +1,157 @@
+package control
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/eliothedeman/smol/cmdline"
+	"github.com/eliothedeman/smol/tools"
+	"github.com/eliothedeman/smol/unit/unittest"
+)
+
+func runScript(t *testing.T, script string) CommandResult {
+	t.Helper()
+	h := unittest.New(t)
+	h.Add("math", tools.NewMath())
+	h.Add(VarUnit, tools.NewRegisters())
+	h.Add("executor", NewInstructionExecutor())
+	client := h.Probe("client")
+
+	client.Send("executor", Instruction{Type: CmdRun, Args: []string{script}})
+	return client.ExpectReply(unittest.OfType[CommandResult](), 100000).(CommandResult)
+}
+
+func TestScriptPipesAndVariables(t *testing.T) {
+	result := runScript(t, `
+		# square roots by pipe
+		let x = math add 1 3
+		math mul $x 4 | math sqrt
+	`)
+	if !result.Success || result.Output != "4.000000" {
+		t.Fatalf("Expected 4.000000, got %+v", result)
+	}
+
+	steps := result.Data.(ScriptResult).Steps
+	want := []ScriptStep{
+		{Line: 3, Command: "math add 1 3", Success: true, Output: "4.000000"},
+		{Line: 4, Command: "math mul 4.000000 4", Success: true, Output: "16.000000"},
+		{Line: 4, Command: "math sqrt 16.000000", Success: true, Output: "4.000000"},
+	}
+	if len(steps) != len(want) {
+		t.Fatalf("Expected %d steps, got %+v", len(want), steps)
+	}
+	for i := range want {
+		if steps[i] != want[i] {
+			t.Errorf("Step %d = %+v, want %+v", i, steps[i], want[i])
+		}
+	}
+}
+
+func TestScriptControlFlow(t *testing.T) {
+	result := runScript(t, `
+		let total = math add 0 0
+		for n in 1 2 3; let total = math add $total $n; end
+		let i = math add 0 0
+		while $i < 3
+			let i = math add $i 1
+		end
+		if $total == 6
+			math add $total $i
+		else
+			fail "wrong total: $total"
+		end
+	`)
+	if !result.Success || result.Output != "9.000000" {
+		t.Fatalf("Expected 9.000000, got %+v", result)
+	}
+
+	result = runScript(t, `if math divide 1 0; math add 1 1; else; math add 2 2; end`)
+	if !result.Success || result.Output != "4.000000" {
+		t.Errorf("Expected the failing condition to take the else branch, got %+v", result)
+	}
+}
+
+func TestScriptErrors(t *testing.T) {
+	result := runScript(t, `
+		try
+			math add 1 1
+			math divide 1 0
+			math add 5 5
+		on error
+			registers set caught 'divide: '$error
+		end
+	`)
+	if !result.Success || !strings.Contains(result.Output, "caught = divide: line 4: math: ") {
+		t.Errorf("Expected the error to be caught, got %+v", result)
+	}
+
+	result = runScript(t, "math add 1 1\nfail \"stopped  at\" $missing\nmath add 2 2")
+	if result.Success || !errors.Is(result.Error, ErrUndefinedVar) {
+		t.Errorf("Expected an undefined variable, got %+v", result)
+	}
+	if steps := result.Data.(ScriptResult).Steps; len(steps) != 1 {
+		t.Errorf("Expected the script to stop after one step, got %+v", steps)
+	}
+
+	result = runScript(t, `fail "stopped  at" 'line $2'`)
+	if !errors.Is(result.Error, ErrScriptFailed) || !strings.HasSuffix(result.Error.Error(), "stopped  at line $2") {
+		t.Errorf("Expected the quoted failure message, got %v", result.Error)
+	}
+
+	result = runScript(t, `try; while math add 1 1; end; on error; math add 1 1; end`)
+	if result.Success || !errors.Is(result.Error, ErrScriptTooLong) {
+		t.Errorf("Expected an endless loop to be stopped, got %v", result.Error)
+	}
+
+	for _, script := range []string{
+		`math add "1 2`,
+		"if $x == 1\nmath add 1 1",
+		"while $x < 1\nelse\nend",
+		"end",
+		"let x math add 1 1",
+		"try\nmath add 1 1\non failure\nend",
+		"math add 1 | | math sqrt",
+	} {
+		if result := runScript(t, script); !errors.Is(result.Error, ErrScriptSyntax) {
+			t.Errorf("%q: expected a syntax error, got %+v", script, result)
+		}
+	}
+}
+
+func TestScriptVariablesAreScopedToTheRun(t *testing.T) {
+	h := unittest.New(t)
+	h.Add("math", tools.NewMath())
+	registers := tools.NewRegisters()
+	h.Add(VarUnit, registers)
+	h.Add("executor", NewInstructionExecutor())
+	client := h.Probe("client")
+
+	run := func(script string) CommandResult {
+		client.Send("executor", Instruction{Type: CmdRun, Args: []string{script}})
+		return client.ExpectReply(unittest.OfType[CommandResult](), 100000).(CommandResult)
+	}
+
+	result := run("let x = math add 1 1\nregisters get run1.x")
+	if !result.Success || result.Output != "run1.x = 2.000000" {
+		t.Fatalf("Expected x bound to register run1.x, got %+v", result)
+	}
+	if result := run(`math add $x 1`); !errors.Is(result.Error, ErrUndefinedVar) {
+		t.Errorf("Expected x to be gone after its run, got %+v", result)
+	}
+	if regs := registers.List(); len(regs) != 0 {
+		t.Errorf("Expected the runs to clear their registers, got %v", regs)
+	}
+}
+
+func TestScriptQuotesLikeThePrompt(t *testing.T) {
+	line := `fail "say \"hi\" \n" 'it''s' back\ slash \$x`
+	args, err := cmdline.Split(line)
+	if err != nil {
+		t.Fatalf("Split failed: %v", err)
+	}
+	result := runScript(t, line)
+	if want := strings.Join(args[1:], " "); !strings.HasSuffix(result.Error.Error(), ": "+want) {
+		t.Errorf("Expected the message %q, got %v", want, result.Error)
+	}
+}
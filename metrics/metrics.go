@@ -0,0 +1,278 @@
+// Package metrics keeps counters, gauges and histograms and exposes them
+// in the Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Labels distinguish the series of one metric, such as the unit they
+// belong to.
+type Labels map[string]string
+
+type kind string
+
+const (
+	kindCounter   kind = "counter"
+	kindGauge     kind = "gauge"
+	kindHistogram kind = "histogram"
+)
+
+// DefaultBuckets suit handler latencies measured in seconds.
+var DefaultBuckets = []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1, 5}
+
+// Registry holds metrics. Metrics are created on first use and shared by
+// later calls with the same name and labels. Using one name for metrics
+// of different kinds is a programming error and panics.
+type Registry struct {
+	store  *store
+	labels Labels
+}
+
+type store struct {
+	mu       sync.RWMutex
+	families map[string]*family
+}
+
+type family struct {
+	name    string
+	help    string
+	kind    kind
+	buckets []float64
+	series  map[string]*series
+}
+
+type series struct {
+	labels string
+	values Labels
+	value  any
+}
+
+func NewRegistry() *Registry {
+	return &Registry{store: &store{families: make(map[string]*family)}}
+}
+
+// With returns a view of r that adds labels to every metric created
+// through it.
+func (r *Registry) With(labels Labels) *Registry {
+	return &Registry{store: r.store, labels: r.merge(labels)}
+}
+
+// Counter returns the counter with the given name and labels.
+func (r *Registry) Counter(name, help string, labels Labels) *Counter {
+	return r.get(name, help, kindCounter, nil, labels, func() any { return &Counter{} }).(*Counter)
+}
+
+// Gauge returns the gauge with the given name and labels.
+func (r *Registry) Gauge(name, help string, labels Labels) *Gauge {
+	return r.get(name, help, kindGauge, nil, labels, func() any { return &Gauge{} }).(*Gauge)
+}
+
+// GaugeFunc registers a gauge whose value is read from f when the metrics
+// are collected, replacing any earlier function for the same labels.
+func (r *Registry) GaugeFunc(name, help string, labels Labels, f func() float64) {
+	r.get(name, help, kindGauge, nil, labels, func() any { return gaugeFunc(f) })
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	r.store.families[name].series[formatLabels(r.merge(labels))].value = gaugeFunc(f)
+}
+
+// Histogram returns the histogram with the given name and labels. The
+// buckets are fixed by the first call for a name; nil means
+// DefaultBuckets.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels Labels) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	h := r.get(name, help, kindHistogram, buckets, labels, func() any { return nil })
+	return h.(*Histogram)
+}
+
+// Forget removes every series carrying the label key=value, such as the
+// series of a unit that has stopped.
+func (r *Registry) Forget(key, value string) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	for name, f := range r.store.families {
+		for k, s := range f.series {
+			if v, ok := s.values[key]; ok && v == value {
+				delete(f.series, k)
+			}
+		}
+		if len(f.series) == 0 {
+			delete(r.store.families, name)
+		}
+	}
+}
+
+// merge returns a copy of labels with the view's own labels added.
+func (r *Registry) merge(labels Labels) Labels {
+	merged := make(Labels, len(r.labels)+len(labels))
+	for k, v := range r.labels {
+		merged[k] = v
+	}
+	for k, v := range labels {
+		merged[k] = v
+	}
+	return merged
+}
+
+func (r *Registry) get(name, help string, k kind, buckets []float64, labels Labels, create func() any) any {
+	labels = r.merge(labels)
+	key := formatLabels(labels)
+
+	r.store.mu.RLock()
+	if f := r.store.families[name]; f != nil && f.kind == k {
+		if s := f.series[key]; s != nil {
+			r.store.mu.RUnlock()
+			return s.value
+		}
+	}
+	r.store.mu.RUnlock()
+
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	f := r.store.families[name]
+	if f == nil {
+		f = &family{
+			name:    name,
+			help:    help,
+			kind:    k,
+			buckets: append([]float64(nil), buckets...),
+			series:  make(map[string]*series),
+		}
+		sort.Float64s(f.buckets)
+		r.store.families[name] = f
+	}
+	if f.kind != k {
+		panic(fmt.Sprintf("metrics: %s is a %s, not a %s", name, f.kind, k))
+	}
+	s := f.series[key]
+	if s == nil {
+		value := create()
+		if k == kindHistogram {
+			value = newHistogram(f.buckets)
+		}
+		s = &series{labels: key, values: labels, value: value}
+		f.series[key] = s
+	}
+	return s.value
+}
+
+// Counter is a value that only goes up.
+type Counter struct {
+	bits atomic.Uint64
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add increases the counter by v, which must not be negative.
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	addFloat(&c.bits, v)
+}
+
+func (c *Counter) Value() float64 {
+	return math.Float64frombits(c.bits.Load())
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	bits atomic.Uint64
+}
+
+func (g *Gauge) Set(v float64) {
+	g.bits.Store(math.Float64bits(v))
+}
+
+func (g *Gauge) Add(v float64) {
+	addFloat(&g.bits, v)
+}
+
+func (g *Gauge) Inc() { g.Add(1) }
+func (g *Gauge) Dec() { g.Add(-1) }
+
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(g.bits.Load())
+}
+
+type gaugeFunc func() float64
+
+// Histogram counts observations into buckets.
+type Histogram struct {
+	upper  []float64
+	counts []atomic.Uint64
+	count  atomic.Uint64
+	sum    atomic.Uint64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{
+		upper:  buckets,
+		counts: make([]atomic.Uint64, len(buckets)),
+	}
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.upper, v)
+	if i < len(h.counts) {
+		h.counts[i].Add(1)
+	}
+	h.count.Add(1)
+	addFloat(&h.sum, v)
+}
+
+// Count returns the number of observations.
+func (h *Histogram) Count() uint64 {
+	return h.count.Load()
+}
+
+// Sum returns the total of all observations.
+func (h *Histogram) Sum() float64 {
+	return math.Float64frombits(h.sum.Load())
+}
+
+func addFloat(bits *atomic.Uint64, v float64) {
+	for {
+		old := bits.Load()
+		updated := math.Float64bits(math.Float64frombits(old) + v)
+		if bits.CompareAndSwap(old, updated) {
+			return
+		}
+	}
+}
+
+// formatLabels renders labels as they appear in the text format, sorted
+// by name, so that it can also serve as the series key.
+func formatLabels(labels Labels) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = formatLabel(k, labels[k])
+	}
+	return strings.Join(parts, ",")
+}
+
+func formatLabel(key, value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+	return key + `="` + value + `"`
+}
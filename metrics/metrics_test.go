@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("requests_total", "Requests served.", Labels{"unit": "math"}).Add(3)
+	r.Counter("requests_total", "Requests served.", Labels{"unit": "echo"}).Inc()
+	g := r.Gauge("queue_depth", "", nil)
+	g.Set(5)
+	g.Dec()
+	r.GaugeFunc("answer", "The answer.", Labels{"q": `say "hi"`}, func() float64 { return 42 })
+	h := r.Histogram("latency_seconds", "Latency.", []float64{0.1, 1}, Labels{"unit": "math"})
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(2)
+
+	var b strings.Builder
+	if err := r.WriteText(&b); err != nil {
+		t.Fatalf("WriteText failed: %v", err)
+	}
+
+	want := `# HELP answer The answer.
+# TYPE answer gauge
+answer{q="say \"hi\""} 42
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{unit="math",le="0.1"} 1
+latency_seconds_bucket{unit="math",le="1"} 2
+latency_seconds_bucket{unit="math",le="+Inf"} 3
+latency_seconds_sum{unit="math"} 2.55
+latency_seconds_count{unit="math"} 3
+# TYPE queue_depth gauge
+queue_depth 4
+# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{unit="echo"} 1
+requests_total{unit="math"} 3
+`
+	if b.String() != want {
+		t.Errorf("WriteText() =\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestWithAndForget(t *testing.T) {
+	r := NewRegistry()
+	scoped := r.With(Labels{"unit": "math"})
+	scoped.Counter("ops_total", "", Labels{"op": "add"}).Inc()
+	r.Counter("ops_total", "", Labels{"unit": "other"}).Inc()
+
+	if c := r.Counter("ops_total", "", Labels{"op": "add", "unit": "math"}); c.Value() != 1 {
+		t.Errorf("Expected the scoped counter to be shared, got %v", c.Value())
+	}
+
+	r.Forget("unit", "math")
+	var b strings.Builder
+	r.WriteText(&b)
+	if strings.Contains(b.String(), `unit="math"`) || !strings.Contains(b.String(), `unit="other"`) {
+		t.Errorf("Expected only math series to be forgotten, got:\n%s", b.String())
+	}
+}
+
+func TestKindConflictPanics(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("thing", "", nil)
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected a panic registering thing as a gauge")
+		}
+	}()
+	r.Gauge("thing", "", nil)
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("hits_total", "", nil).Inc()
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if !strings.Contains(rec.Body.String(), "hits_total 1\n") {
+		t.Errorf("Unexpected body:\n%s", rec.Body.String())
+	}
+}
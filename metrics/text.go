@@ -0,0 +1,106 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ContentType is the media type of the Prometheus text format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// WriteText writes every metric in the Prometheus text format, sorted by
+// name and labels.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	names := make([]string, 0, len(r.store.families))
+	for name := range r.store.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+	for _, name := range names {
+		f := r.store.families[name]
+		if f.help != "" {
+			bw.WriteString("# HELP " + name + " " + escapeHelp(f.help) + "\n")
+		}
+		bw.WriteString("# TYPE " + name + " " + string(f.kind) + "\n")
+
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			writeSeries(bw, f, f.series[key])
+		}
+	}
+	return bw.Flush()
+}
+
+func writeSeries(w *bufio.Writer, f *family, s *series) {
+	switch v := s.value.(type) {
+	case *Counter:
+		writeSample(w, f.name, s.labels, v.Value())
+	case *Gauge:
+		writeSample(w, f.name, s.labels, v.Value())
+	case gaugeFunc:
+		writeSample(w, f.name, s.labels, v())
+	case *Histogram:
+		var cumulative uint64
+		for i, upper := range v.upper {
+			cumulative += v.counts[i].Load()
+			writeSample(w, f.name+"_bucket", withLabel(s.labels, "le", formatFloat(upper)), float64(cumulative))
+		}
+		count := v.Count()
+		writeSample(w, f.name+"_bucket", withLabel(s.labels, "le", "+Inf"), float64(count))
+		writeSample(w, f.name+"_sum", s.labels, v.Sum())
+		writeSample(w, f.name+"_count", s.labels, float64(count))
+	}
+}
+
+func writeSample(w *bufio.Writer, name, labels string, value float64) {
+	w.WriteString(name)
+	if labels != "" {
+		w.WriteString("{" + labels + "}")
+	}
+	w.WriteString(" " + formatFloat(value) + "\n")
+}
+
+func withLabel(labels, key, value string) string {
+	if labels == "" {
+		return formatLabel(key, value)
+	}
+	return labels + "," + formatLabel(key, value)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		r.WriteText(w)
+	})
+}
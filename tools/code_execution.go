@@ -1,17 +1,32 @@
 package tools
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"os"
 	"os/exec"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/eliothedeman/smol/unit"
 )
 
 type CodeExecution struct {
 	workDir string
 	timeout time.Duration
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	mu      sync.Mutex
+	running map[int]*os.Process
+	// dir is the unit's own directory for source files, made within
+	// workDir on first use.
+	dir string
+
+	once    sync.Once
+	actions *unit.ActionSet
 }
 
 type ExecutionRequest struct {
@@ -28,10 +43,131 @@ type ExecutionResult struct {
 }
 
 func NewCodeExecution(workDir string) *CodeExecution {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &CodeExecution{
 		workDir: workDir,
 		timeout: 30 * time.Second,
+		ctx:     ctx,
+		cancel:  cancel,
+		running: make(map[int]*os.Process),
+	}
+}
+
+func (ce *CodeExecution) Init(ctx unit.Ctx) {}
+
+// Describe is generated from the typed map actions.
+func (ce *CodeExecution) Describe() unit.Description {
+	return ce.actionSet().Describe()
+}
+
+// Handle runs an ExecutionRequest, or a map command naming the "execute"
+// action, and replies with its ExecutionResult.
+func (ce *CodeExecution) Handle(ctx unit.Ctx, from unit.UnitRef, message any) error {
+	switch msg := message.(type) {
+	case ExecutionRequest:
+		result, err := ce.Execute(msg)
+		if err != nil {
+			return err
+		}
+		return from.Send(*result)
+	case map[string]any:
+		return ce.actionSet().Handle(ctx, from, msg)
+	default:
+		return fmt.Errorf("unsupported message type: %T", message)
+	}
+}
+
+// actionSet routes map commands to typed handlers.
+func (ce *CodeExecution) actionSet() *unit.ActionSet {
+	ce.once.Do(func() {
+		ce.actions = unit.Actions("Runs Go, Python and Bash code",
+			unit.Action("execute", "Run code and return its output", func(ctx unit.Ctx, req ExecutionRequest) (ExecutionResult, error) {
+				result, err := ce.Execute(req)
+				if err != nil {
+					return ExecutionResult{}, err
+				}
+				return *result, nil
+			}),
+		)
+	})
+	return ce.actions
+}
+
+// Processes lists the running code's process IDs, so that the registry
+// can count their memory.
+func (ce *CodeExecution) Processes() []int {
+	ce.mu.Lock()
+	defer ce.mu.Unlock()
+
+	pids := make([]int, 0, len(ce.running))
+	for pid := range ce.running {
+		pids = append(pids, pid)
+	}
+	return pids
+}
+
+// Evict kills any running code to free its memory.
+func (ce *CodeExecution) Evict(ctx unit.Ctx) {
+	ce.mu.Lock()
+	defer ce.mu.Unlock()
+
+	for _, p := range ce.running {
+		p.Kill()
+	}
+}
+
+// run is like cmd.CombinedOutput, but tracks the process while it runs.
+// Grandchildren that outlive a killed process do not hold it up for more
+// than a second.
+func (ce *CodeExecution) run(cmd *exec.Cmd) ([]byte, error) {
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	cmd.WaitDelay = time.Second
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	ce.mu.Lock()
+	ce.running[cmd.Process.Pid] = cmd.Process
+	ce.mu.Unlock()
+
+	err := cmd.Wait()
+
+	ce.mu.Lock()
+	delete(ce.running, cmd.Process.Pid)
+	ce.mu.Unlock()
+
+	return output.Bytes(), err
+}
+
+// Terminate kills any running code and removes the unit's directory of
+// source files, leaving the rest of the work directory alone.
+func (ce *CodeExecution) Terminate(ctx unit.Ctx, reason error) {
+	ce.cancel()
+
+	ce.mu.Lock()
+	defer ce.mu.Unlock()
+
+	if ce.dir != "" {
+		os.RemoveAll(ce.dir)
+		ce.dir = ""
+	}
+}
+
+// sourceFile creates a file for code within the unit's own directory.
+func (ce *CodeExecution) sourceFile(pattern string) (*os.File, error) {
+	ce.mu.Lock()
+	defer ce.mu.Unlock()
+
+	if ce.dir == "" {
+		dir, err := os.MkdirTemp(ce.workDir, "code_*")
+		if err != nil {
+			return nil, err
+		}
+		ce.dir = dir
 	}
+	return os.CreateTemp(ce.dir, pattern)
 }
 
 func (ce *CodeExecution) Execute(req ExecutionRequest) (*ExecutionResult, error) {
@@ -50,7 +186,7 @@ func (ce *CodeExecution) Execute(req ExecutionRequest) (*ExecutionResult, error)
 }
 
 func (ce *CodeExecution) executeGo(req ExecutionRequest, start time.Time) (*ExecutionResult, error) {
-	file, err := os.CreateTemp(ce.workDir, "code_*.go")
+	file, err := ce.sourceFile("code_*.go")
 	if err != nil {
 		return nil, err
 	}
@@ -61,13 +197,13 @@ func (ce *CodeExecution) executeGo(req ExecutionRequest, start time.Time) (*Exec
 	}
 	file.Close()
 
-	ctx, cancel := context.WithTimeout(context.Background(), ce.timeout)
+	ctx, cancel := context.WithTimeout(ce.ctx, ce.timeout)
 	defer cancel()
 
 	cmd := exec.CommandContext(ctx, "go", "run", file.Name())
 	cmd.Env = ce.buildEnv(req.Env)
 
-	output, err := cmd.CombinedOutput()
+	output, err := ce.run(cmd)
 	duration := time.Since(start)
 
 	result := &ExecutionResult{
@@ -86,7 +222,7 @@ func (ce *CodeExecution) executeGo(req ExecutionRequest, start time.Time) (*Exec
 }
 
 func (ce *CodeExecution) executePython(req ExecutionRequest, start time.Time) (*ExecutionResult, error) {
-	file, err := os.CreateTemp(ce.workDir, "code_*.py")
+	file, err := ce.sourceFile("code_*.py")
 	if err != nil {
 		return nil, err
 	}
@@ -97,13 +233,13 @@ func (ce *CodeExecution) executePython(req ExecutionRequest, start time.Time) (*
 	}
 	file.Close()
 
-	ctx, cancel := context.WithTimeout(context.Background(), ce.timeout)
+	ctx, cancel := context.WithTimeout(ce.ctx, ce.timeout)
 	defer cancel()
 
 	cmd := exec.CommandContext(ctx, "python3", file.Name())
 	cmd.Env = ce.buildEnv(req.Env)
 
-	output, err := cmd.CombinedOutput()
+	output, err := ce.run(cmd)
 	duration := time.Since(start)
 
 	result := &ExecutionResult{
@@ -122,7 +258,7 @@ func (ce *CodeExecution) executePython(req ExecutionRequest, start time.Time) (*
 }
 
 func (ce *CodeExecution) executeBash(req ExecutionRequest, start time.Time) (*ExecutionResult, error) {
-	file, err := os.CreateTemp(ce.workDir, "code_*.sh")
+	file, err := ce.sourceFile("code_*.sh")
 	if err != nil {
 		return nil, err
 	}
@@ -133,13 +269,13 @@ func (ce *CodeExecution) executeBash(req ExecutionRequest, start time.Time) (*Ex
 	}
 	file.Close()
 
-	ctx, cancel := context.WithTimeout(context.Background(), ce.timeout)
+	ctx, cancel := context.WithTimeout(ce.ctx, ce.timeout)
 	defer cancel()
 
 	cmd := exec.CommandContext(ctx, "bash", file.Name())
 	cmd.Env = ce.buildEnv(req.Env)
 
-	output, err := cmd.CombinedOutput()
+	output, err := ce.run(cmd)
 	duration := time.Since(start)
 
 	result := &ExecutionResult{
@@ -0,0 +1,61 @@
+package tools
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCodeExecutionTracksAndEvictsProcesses(t *testing.T) {
+	ce := NewCodeExecution(t.TempDir())
+
+	done := make(chan *ExecutionResult)
+	go func() {
+		result, _ := ce.Execute(ExecutionRequest{Code: "sleep 10", Language: "bash"})
+		done <- result
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(ce.Processes()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the running script to be tracked")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ce.Evict(nil)
+	select {
+	case result := <-done:
+		if result == nil || result.Error == "" {
+			t.Errorf("Expected the evicted script to fail, got %+v", result)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected Evict to kill the running script")
+	}
+	if pids := ce.Processes(); len(pids) != 0 {
+		t.Errorf("Expected no processes after the script ended, got %v", pids)
+	}
+}
+
+func TestCodeExecutionRemovesOnlyItsOwnFiles(t *testing.T) {
+	dir := t.TempDir()
+	other := filepath.Join(dir, "code_other.sh")
+	if err := os.WriteFile(other, []byte("echo hi"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ce := NewCodeExecution(dir)
+	if result, err := ce.Execute(ExecutionRequest{Code: "echo hi", Language: "bash"}); err != nil || result.Output != "hi\n" {
+		t.Fatalf("Execute failed: %+v, %v", result, err)
+	}
+	ce.Terminate(nil, nil)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "code_other.sh" {
+		t.Errorf("Expected only the other process's file to remain, got %v", entries)
+	}
+}
@@ -1,16 +1,21 @@
 package tools
 
 import (
+	"encoding/json"
 	"fmt"
 	"math"
 	"strconv"
 	"strings"
+	"sync"
 
+	"github.com/eliothedeman/smol/cmdline"
 	"github.com/eliothedeman/smol/unit"
 )
 
 type Math struct {
-	ctx unit.Ctx
+	ctx     unit.Ctx
+	once    sync.Once
+	actions *unit.ActionSet
 }
 
 func NewMath() *Math {
@@ -79,19 +84,27 @@ func (m *Math) Init(ctx unit.Ctx) {
 	m.ctx = ctx
 }
 
+// Describe is generated from the typed map actions.
+func (m *Math) Describe() unit.Description {
+	return m.actionSet().Describe()
+}
+
 func (m *Math) Handle(ctx unit.Ctx, from unit.UnitRef, message any) error {
 	switch msg := message.(type) {
 	case string:
 		return m.handleStringCommand(ctx, from, msg)
 	case map[string]interface{}:
-		return m.handleMapCommand(ctx, from, msg)
+		return m.actionSet().Handle(ctx, from, msg)
 	default:
 		return fmt.Errorf("unsupported message type: %T", message)
 	}
 }
 
 func (m *Math) handleStringCommand(ctx unit.Ctx, from unit.UnitRef, command string) error {
-	parts := strings.Fields(command)
+	parts, err := cmdline.Split(command)
+	if err != nil {
+		return err
+	}
 	if len(parts) == 0 {
 		return fmt.Errorf("empty command")
 	}
@@ -248,111 +261,98 @@ func (m *Math) handleStringCommand(ctx unit.Ctx, from unit.UnitRef, command stri
 	return nil
 }
 
-func (m *Math) handleMapCommand(ctx unit.Ctx, from unit.UnitRef, command map[string]interface{}) error {
-	action, ok := command["action"].(string)
-	if !ok {
-		return fmt.Errorf("missing action field")
-	}
-
-	switch action {
-	case "add":
-		numbers, err := m.extractNumbers(command["numbers"])
-		if err != nil {
-			return err
-		}
-		from.Send(m.sum(numbers))
+// Number is a float64 that may also be given as a numeric string.
+type Number float64
 
-	case "subtract":
-		a, b, err := m.extractTwoNumbers(command["a"], command["b"])
+func (n *Number) UnmarshalJSON(data []byte) error {
+	var s string
+	if json.Unmarshal(data, &s) == nil {
+		f, err := strconv.ParseFloat(s, 64)
 		if err != nil {
-			return err
+			return fmt.Errorf("invalid number: %s", s)
 		}
-		from.Send(m.Subtract(a, b))
-
-	case "multiply":
-		numbers, err := m.extractNumbers(command["numbers"])
-		if err != nil {
-			return err
-		}
-		from.Send(m.product(numbers))
-
-	case "divide":
-		a, b, err := m.extractTwoNumbers(command["a"], command["b"])
-		if err != nil {
-			return err
-		}
-		result, err := m.Divide(a, b)
-		if err != nil {
-			return err
-		}
-		from.Send(result)
+		*n = Number(f)
+		return nil
+	}
+	var f float64
+	if err := json.Unmarshal(data, &f); err != nil {
+		return err
+	}
+	*n = Number(f)
+	return nil
+}
 
-	case "power":
-		base, exp, err := m.extractTwoNumbers(command["base"], command["exponent"])
-		if err != nil {
-			return err
-		}
-		from.Send(m.Power(base, exp))
+type numbersArgs struct {
+	Numbers []Number `json:"numbers"`
+}
 
-	case "sqrt":
-		x, err := m.extractNumber(command["x"])
-		if err != nil {
-			return err
-		}
-		result, err := m.Sqrt(x)
-		if err != nil {
-			return err
-		}
-		from.Send(result)
+type pairArgs struct {
+	A Number `json:"a"`
+	B Number `json:"b"`
+}
 
-	case "sin":
-		x, err := m.extractNumber(command["x"])
-		if err != nil {
-			return err
-		}
-		from.Send(m.Sin(x))
+type powerArgs struct {
+	Base     Number `json:"base"`
+	Exponent Number `json:"exponent"`
+}
 
-	case "cos":
-		x, err := m.extractNumber(command["x"])
-		if err != nil {
-			return err
-		}
-		from.Send(m.Cos(x))
+type unaryArgs struct {
+	X Number `json:"x"`
+}
 
-	case "tan":
-		x, err := m.extractNumber(command["x"])
-		if err != nil {
-			return err
-		}
-		from.Send(m.Tan(x))
+type roundArgs struct {
+	X        Number `json:"x"`
+	Decimals int    `json:"decimals,omitempty" desc:"defaults to 0"`
+}
 
-	case "log":
-		x, err := m.extractNumber(command["x"])
-		if err != nil {
-			return err
-		}
-		result, err := m.Log(x)
-		if err != nil {
-			return err
+// actionSet routes map commands to typed handlers.
+func (m *Math) actionSet() *unit.ActionSet {
+	m.once.Do(func() {
+		unary := func(f func(float64) float64) func(unit.Ctx, unaryArgs) (float64, error) {
+			return func(ctx unit.Ctx, req unaryArgs) (float64, error) {
+				return f(float64(req.X)), nil
+			}
 		}
-		from.Send(result)
 
-	case "round":
-		x, err := m.extractNumber(command["x"])
-		if err != nil {
-			return err
-		}
-		decimals := 0
-		if d, ok := command["decimals"].(float64); ok {
-			decimals = int(d)
-		}
-		from.Send(m.Round(x, decimals))
+		m.actions = unit.Actions("Mathematical operations and calculations",
+			unit.Action("add", "Add numbers", func(ctx unit.Ctx, req numbersArgs) (float64, error) {
+				return m.sum(floats(req.Numbers)), nil
+			}),
+			unit.Action("subtract", "Subtract b from a", func(ctx unit.Ctx, req pairArgs) (float64, error) {
+				return m.Subtract(float64(req.A), float64(req.B)), nil
+			}),
+			unit.Action("multiply", "Multiply numbers", func(ctx unit.Ctx, req numbersArgs) (float64, error) {
+				return m.product(floats(req.Numbers)), nil
+			}),
+			unit.Action("divide", "Divide a by b", func(ctx unit.Ctx, req pairArgs) (float64, error) {
+				return m.Divide(float64(req.A), float64(req.B))
+			}),
+			unit.Action("power", "Raise base to exponent", func(ctx unit.Ctx, req powerArgs) (float64, error) {
+				return m.Power(float64(req.Base), float64(req.Exponent)), nil
+			}),
+			unit.Action("sqrt", "Square root", func(ctx unit.Ctx, req unaryArgs) (float64, error) {
+				return m.Sqrt(float64(req.X))
+			}),
+			unit.Action("sin", "Sine function", unary(m.Sin)),
+			unit.Action("cos", "Cosine function", unary(m.Cos)),
+			unit.Action("tan", "Tangent function", unary(m.Tan)),
+			unit.Action("log", "Natural logarithm", func(ctx unit.Ctx, req unaryArgs) (float64, error) {
+				return m.Log(float64(req.X))
+			}),
+			unit.Action("round", "Round to a number of decimals", func(ctx unit.Ctx, req roundArgs) (float64, error) {
+				return m.Round(float64(req.X), req.Decimals), nil
+			}),
+		)
+	})
+	return m.actions
+}
 
-	default:
-		return fmt.Errorf("unknown action: %s", action)
+func floats(numbers []Number) []float64 {
+	out := make([]float64, len(numbers))
+	for i, n := range numbers {
+		out[i] = float64(n)
 	}
-
-	return nil
+	return out
 }
 
 func (m *Math) parseNumber(s string) (float64, error) {
@@ -383,50 +383,6 @@ func (m *Math) parseTwoNumbers(a, b string) (float64, float64, error) {
 	return num1, num2, nil
 }
 
-func (m *Math) extractNumber(val interface{}) (float64, error) {
-	switch v := val.(type) {
-	case float64:
-		return v, nil
-	case int:
-		return float64(v), nil
-	case string:
-		return m.parseNumber(v)
-	default:
-		return 0, fmt.Errorf("invalid number type: %T", val)
-	}
-}
-
-func (m *Math) extractTwoNumbers(a, b interface{}) (float64, float64, error) {
-	num1, err := m.extractNumber(a)
-	if err != nil {
-		return 0, 0, err
-	}
-	num2, err := m.extractNumber(b)
-	if err != nil {
-		return 0, 0, err
-	}
-	return num1, num2, nil
-}
-
-func (m *Math) extractNumbers(val interface{}) ([]float64, error) {
-	switch v := val.(type) {
-	case []interface{}:
-		numbers := make([]float64, len(v))
-		for i, item := range v {
-			num, err := m.extractNumber(item)
-			if err != nil {
-				return nil, err
-			}
-			numbers[i] = num
-		}
-		return numbers, nil
-	case []float64:
-		return v, nil
-	default:
-		return nil, fmt.Errorf("invalid numbers format: %T", val)
-	}
-}
-
 func (m *Math) sum(numbers []float64) float64 {
 	sum := 0.0
 	for _, num := range numbers {
@@ -2,7 +2,11 @@ package tools
 
 import (
 	"context"
+	"errors"
 	"testing"
+
+	"github.com/eliothedeman/smol/unit"
+	"github.com/eliothedeman/smol/unit/unittest"
 )
 
 // Reuse mock types from registers_test.go
@@ -210,31 +214,32 @@ func TestMathSumAndProduct(t *testing.T) {
 	}
 }
 
-func TestMathExtractNumbers(t *testing.T) {
-	math := NewMath()
-
-	// Test extract single number
-	if num, err := math.extractNumber(42.0); err != nil || num != 42 {
-		t.Errorf("Expected 42, got %f, err: %v", num, err)
+func TestMathDecodeNumbers(t *testing.T) {
+	// Test decode single number
+	if req, err := unit.Decode[unaryArgs](map[string]interface{}{"x": 42.0}); err != nil || req.X != 42 {
+		t.Errorf("Expected 42, got %f, err: %v", req.X, err)
 	}
 
-	// Test extract from string
-	if num, err := math.extractNumber("3.14"); err != nil || num != 3.14 {
-		t.Errorf("Expected 3.14, got %f, err: %v", num, err)
+	// Test decode from string
+	if req, err := unit.Decode[unaryArgs](map[string]interface{}{"x": "3.14"}); err != nil || req.X != 3.14 {
+		t.Errorf("Expected 3.14, got %f, err: %v", req.X, err)
 	}
 
-	// Test extract numbers array
-	numbers, err := math.extractNumbers([]interface{}{1.0, 2.0, 3.0})
+	// Test decode numbers array
+	req, err := unit.Decode[numbersArgs](map[string]interface{}{"numbers": []interface{}{1.0, "2", 3}})
 	if err != nil {
-		t.Errorf("Extract numbers failed: %v", err)
+		t.Errorf("Decode numbers failed: %v", err)
 	}
-	if len(numbers) != 3 || numbers[0] != 1 || numbers[1] != 2 || numbers[2] != 3 {
+	if numbers := floats(req.Numbers); len(numbers) != 3 || numbers[0] != 1 || numbers[1] != 2 || numbers[2] != 3 {
 		t.Errorf("Unexpected numbers: %v", numbers)
 	}
 
-	// Test extract invalid type
-	if _, err := math.extractNumber("invalid"); err == nil {
-		t.Error("Expected extract error")
+	// Test decode invalid number and missing field
+	if _, err := unit.Decode[unaryArgs](map[string]interface{}{"x": "invalid"}); !errors.Is(err, unit.ErrInvalidMessage) {
+		t.Errorf("Expected invalid message error, got %v", err)
+	}
+	if _, err := unit.Decode[pairArgs](map[string]interface{}{"a": 1.0}); !errors.Is(err, unit.ErrInvalidMessage) {
+		t.Errorf("Expected missing field error, got %v", err)
 	}
 }
 
@@ -271,3 +276,19 @@ func TestMathErrorHandling(t *testing.T) {
 		t.Error("Expected missing action error")
 	}
 }
+
+func TestMathReplies(t *testing.T) {
+	h := unittest.New(t)
+	h.Add("math", NewMath())
+	client := h.Probe("client")
+
+	client.Send("math", "add 1 2 3")
+	client.ExpectReply(unittest.Equal("6.000000"), 2)
+
+	client.Send("math", map[string]any{"action": "multiply", "numbers": []any{2, "4"}})
+	client.ExpectReply(unittest.Equal(8.0), 2)
+
+	if _, err := h.Ask("math", map[string]any{"action": "divide", "a": 1, "b": 0}); err == nil {
+		t.Error("Expected dividing by zero to fail the Ask")
+	}
+}
@@ -1,11 +1,91 @@
 package tools
 
-import "github.com/eliothedeman/smol/unit"
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
 
-type OpenAIServer struct{}
+	"github.com/eliothedeman/smol/unit"
+)
 
-func (o *OpenAIServer) Init(ctx unit.Ctx) {}
+type OpenAIServer struct {
+	ctx unit.Ctx
+}
+
+// FunctionTool is a tool definition in the OpenAI function-calling format.
+type FunctionTool struct {
+	Type     string       `json:"type"`
+	Function FunctionSpec `json:"function"`
+}
+
+type FunctionSpec struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	Parameters  unit.Schema `json:"parameters"`
+}
+
+func (o *OpenAIServer) Init(ctx unit.Ctx) {
+	o.ctx = ctx
+}
 
 func (o *OpenAIServer) Handle(ctx unit.Ctx, from unit.UnitRef, message any) error {
 	return nil
 }
+
+// Tools exposes every described unit in the registry as function tools.
+func (o *OpenAIServer) Tools() []FunctionTool {
+	if o.ctx == nil {
+		return nil
+	}
+	return FunctionTools(o.ctx.Units())
+}
+
+// FunctionTools converts unit descriptions into function tools, one per
+// action, named <unit>_<action>. Units without a Description are skipped.
+func FunctionTools(units []unit.UnitDesc) []FunctionTool {
+	var tools []FunctionTool
+	for _, u := range units {
+		if u.Description == nil {
+			continue
+		}
+		for _, action := range u.Description.Actions {
+			params := action.Args
+			if params == nil {
+				params = unit.Object(nil)
+			}
+			tools = append(tools, FunctionTool{
+				Type: "function",
+				Function: FunctionSpec{
+					Name:        u.Name + "_" + action.Name,
+					Description: action.Summary,
+					Parameters:  params,
+				},
+			})
+		}
+	}
+	return tools
+}
+
+// ToolCall turns a function call made by a model back into the unit to
+// send to and the map message to send it.
+func ToolCall(units []unit.UnitDesc, name, arguments string) (string, map[string]any, error) {
+	for _, u := range units {
+		if u.Description == nil || !strings.HasPrefix(name, u.Name+"_") {
+			continue
+		}
+		action := strings.TrimPrefix(name, u.Name+"_")
+		if _, ok := u.Description.Action(action); !ok {
+			continue
+		}
+
+		msg := make(map[string]any)
+		if arguments != "" {
+			if err := json.Unmarshal([]byte(arguments), &msg); err != nil {
+				return "", nil, fmt.Errorf("invalid arguments for %s: %w", name, err)
+			}
+		}
+		msg["action"] = action
+		return u.Name, msg, nil
+	}
+	return "", nil, fmt.Errorf("unknown tool: %s", name)
+}
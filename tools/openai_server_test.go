@@ -0,0 +1,39 @@
+package tools
+
+import (
+	"testing"
+
+	"github.com/eliothedeman/smol/unit"
+)
+
+func TestFunctionTools(t *testing.T) {
+	math := NewMath()
+	desc := math.Describe()
+	units := []unit.UnitDesc{
+		{Name: "math", Proxy: math, Description: &desc},
+		{Name: "server", Proxy: &OpenAIServer{}},
+	}
+
+	tools := FunctionTools(units)
+	if len(tools) != len(desc.Actions) {
+		t.Fatalf("got %d tools, want %d", len(tools), len(desc.Actions))
+	}
+	if tools[0].Type != "function" || tools[0].Function.Name != "math_add" {
+		t.Errorf("first tool = %+v, want function math_add", tools[0])
+	}
+	if tools[0].Function.Parameters["type"] != "object" {
+		t.Errorf("parameters = %v, want an object schema", tools[0].Function.Parameters)
+	}
+
+	name, msg, err := ToolCall(units, "math_divide", `{"a": 6, "b": 3}`)
+	if err != nil {
+		t.Fatalf("ToolCall failed: %v", err)
+	}
+	if name != "math" || msg["action"] != "divide" || msg["a"] != 6.0 {
+		t.Errorf("ToolCall = %s, %v", name, msg)
+	}
+
+	if _, _, err := ToolCall(units, "math_nope", "{}"); err == nil {
+		t.Error("expected an error for an unknown action")
+	}
+}
@@ -5,12 +5,18 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+
+	"github.com/eliothedeman/smol/cmdline"
+	"github.com/eliothedeman/smol/unit"
 )
 
 // Registers manages named values
 type Registers struct {
 	mu        sync.RWMutex
 	registers map[string]interface{}
+	ctx       unit.Ctx
+	once      sync.Once
+	actions   *unit.ActionSet
 }
 
 // NewRegisters creates a new Registers instance
@@ -20,9 +26,38 @@ func NewRegisters() *Registers {
 	}
 }
 
+// Init stores the unit context
+func (r *Registers) Init(ctx unit.Ctx) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ctx = ctx
+	if r.registers == nil {
+		r.registers = make(map[string]interface{})
+	}
+}
+
+// Describe is generated from the typed map actions
+func (r *Registers) Describe() unit.Description {
+	return r.actionSet().Describe()
+}
+
 // Handle processes register commands
-func (r *Registers) Handle(cmd string, from MessageSender) error {
-	parts := strings.Fields(cmd)
+func (r *Registers) Handle(ctx unit.Ctx, from unit.UnitRef, message any) error {
+	switch msg := message.(type) {
+	case string:
+		return r.handleStringCommand(ctx, from, msg)
+	case map[string]interface{}:
+		return r.actionSet().Handle(ctx, from, msg)
+	default:
+		return fmt.Errorf("unsupported message type: %T", message)
+	}
+}
+
+func (r *Registers) handleStringCommand(ctx unit.Ctx, from unit.UnitRef, cmd string) error {
+	parts, err := cmdline.Split(cmd)
+	if err != nil {
+		return err
+	}
 	if len(parts) == 0 {
 		return fmt.Errorf("empty command")
 	}
@@ -67,6 +102,11 @@ func (r *Registers) Handle(cmd string, from MessageSender) error {
 		}
 
 	case "clear":
+		if len(parts) > 1 {
+			r.ClearPrefix(parts[1])
+			from.Send(fmt.Sprintf("Registers %s* cleared", parts[1]))
+			break
+		}
 		r.Clear()
 		from.Send("All registers cleared")
 
@@ -77,6 +117,50 @@ func (r *Registers) Handle(cmd string, from MessageSender) error {
 	return nil
 }
 
+type setArgs struct {
+	Name  string `json:"name"`
+	Value any    `json:"value"`
+}
+
+type nameArgs struct {
+	Name string `json:"name"`
+}
+
+type clearArgs struct {
+	Prefix string `json:"prefix,omitempty" desc:"only clear registers whose names start with this"`
+}
+
+// actionSet routes map commands to typed handlers
+func (r *Registers) actionSet() *unit.ActionSet {
+	r.once.Do(func() {
+		r.actions = unit.Actions("Temporary variable storage and management",
+			unit.Action("set", "Set a register", func(ctx unit.Ctx, req setArgs) (any, error) {
+				r.Set(req.Name, req.Value)
+				return req.Value, nil
+			}),
+			unit.Action("get", "Get a register", func(ctx unit.Ctx, req nameArgs) (any, error) {
+				val, exists := r.Get(req.Name)
+				if !exists {
+					return nil, fmt.Errorf("%s not found", req.Name)
+				}
+				return val, nil
+			}),
+			unit.Action("list", "List all registers", func(ctx unit.Ctx, req struct{}) (map[string]interface{}, error) {
+				return r.List(), nil
+			}),
+			unit.Action("clear", "Clear all registers, or those with a prefix", func(ctx unit.Ctx, req clearArgs) (string, error) {
+				if req.Prefix != "" {
+					r.ClearPrefix(req.Prefix)
+					return fmt.Sprintf("Registers %s* cleared", req.Prefix), nil
+				}
+				r.Clear()
+				return "All registers cleared", nil
+			}),
+		)
+	})
+	return r.actions
+}
+
 func (r *Registers) parseValue(s string) (interface{}, error) {
 	// Try int
 	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
@@ -112,11 +196,13 @@ func (r *Registers) Get(name string) (interface{}, bool) {
 
 func (r *Registers) GetInt(name string) (int64, bool) {
 	if val, exists := r.Get(name); exists {
-		if i, ok := val.(int64); ok {
-			return i, true
-		}
-		if f, ok := val.(float64); ok {
-			return int64(f), true
+		switch v := val.(type) {
+		case int:
+			return int64(v), true
+		case int64:
+			return v, true
+		case float64:
+			return int64(v), true
 		}
 	}
 	return 0, false
@@ -124,11 +210,13 @@ func (r *Registers) GetInt(name string) (int64, bool) {
 
 func (r *Registers) GetFloat(name string) (float64, bool) {
 	if val, exists := r.Get(name); exists {
-		if f, ok := val.(float64); ok {
-			return f, true
-		}
-		if i, ok := val.(int64); ok {
-			return float64(i), true
+		switch v := val.(type) {
+		case float64:
+			return v, true
+		case int:
+			return float64(v), true
+		case int64:
+			return float64(v), true
 		}
 	}
 	return 0, false
@@ -159,3 +247,14 @@ func (r *Registers) Clear() {
 	defer r.mu.Unlock()
 	r.registers = make(map[string]interface{})
 }
+
+// ClearPrefix removes the registers whose names start with prefix.
+func (r *Registers) ClearPrefix(prefix string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name := range r.registers {
+		if strings.HasPrefix(name, prefix) {
+			delete(r.registers, name)
+		}
+	}
+}
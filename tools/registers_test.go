@@ -3,7 +3,9 @@ package tools
 import (
 	"context"
 	"testing"
+	"time"
 
+	"github.com/eliothedeman/smol/metrics"
 	"github.com/eliothedeman/smol/unit"
 )
 
@@ -16,7 +18,11 @@ func (m *mockCtx) Units() []unit.UnitDesc {
 	return m.units
 }
 
-func (m *mockCtx) Spawn(name string, f unit.UnitFactory) unit.UnitRef {
+func (m *mockCtx) Spawn(name string, f unit.UnitFactory, opts ...unit.Option) unit.UnitRef {
+	return &mockUnitRef{name: name}
+}
+
+func (m *mockCtx) SpawnPool(name string, f unit.UnitFactory, size int, opts ...unit.Option) unit.UnitRef {
 	return &mockUnitRef{name: name}
 }
 
@@ -24,8 +30,39 @@ func (m *mockCtx) Self() unit.UnitRef {
 	return &mockUnitRef{name: "test"}
 }
 
+func (m *mockCtx) Send(to unit.UnitRef, msg any) error {
+	return to.Send(msg)
+}
+
+func (m *mockCtx) Ask(to unit.UnitRef, msg any) (any, error) {
+	return m.AskAsync(to, msg).Result()
+}
+
+func (m *mockCtx) AskAsync(to unit.UnitRef, msg any) *unit.Future {
+	return unit.Resolved(nil, unit.ErrNoReply)
+}
+
 func (m *mockCtx) Subscribe(other unit.Unit)   {}
 func (m *mockCtx) Unsubscribe(other unit.Unit) {}
+func (m *mockCtx) Watch(other unit.UnitRef)    {}
+func (m *mockCtx) Unwatch(other unit.UnitRef)  {}
+
+func (m *mockCtx) Publish(topic string, msg any) error { return nil }
+func (m *mockCtx) SubscribeTopic(pattern string) error { return nil }
+func (m *mockCtx) UnsubscribeTopic(pattern string)     {}
+func (m *mockCtx) Metrics() *metrics.Registry          { return metrics.NewRegistry() }
+
+func (m *mockCtx) SendAfter(to unit.UnitRef, msg any, d time.Duration) *unit.Timer {
+	return &unit.Timer{}
+}
+
+func (m *mockCtx) SendEvery(to unit.UnitRef, msg any, interval time.Duration) *unit.Timer {
+	return &unit.Timer{}
+}
+
+func (m *mockCtx) SendOn(to unit.UnitRef, msg any, schedule unit.Schedule) *unit.Timer {
+	return &unit.Timer{}
+}
 
 type mockUnitRef struct {
 	name string
@@ -35,8 +72,8 @@ func (m *mockUnitRef) Name() string {
 	return m.name
 }
 
-func (m *mockUnitRef) Send(msg any) {}
-func (m *mockUnitRef) Stop()        {}
+func (m *mockUnitRef) Send(msg any) error { return nil }
+func (m *mockUnitRef) Stop()              {}
 
 type testMessageHandler struct {
 	lastMessage any
@@ -47,8 +84,9 @@ func (t *testMessageHandler) Name() string {
 	return "test"
 }
 
-func (t *testMessageHandler) Send(msg any) {
+func (t *testMessageHandler) Send(msg any) error {
 	t.lastMessage = msg
+	return nil
 }
 
 func (t *testMessageHandler) Stop() {}
@@ -111,6 +149,20 @@ func TestRegistersClear(t *testing.T) {
 	}
 }
 
+func TestRegistersClearPrefix(t *testing.T) {
+	registers := NewRegisters()
+	registers.Set("run1.x", 1)
+	registers.Set("run2.x", 2)
+	registers.ClearPrefix("run1.")
+
+	if _, exists := registers.Get("run1.x"); exists {
+		t.Error("Expected run1.x to be cleared")
+	}
+	if _, exists := registers.Get("run2.x"); !exists {
+		t.Error("Expected run2.x to be kept")
+	}
+}
+
 func TestRegistersHandleStringCommands(t *testing.T) {
 	registers := NewRegisters()
 	ctx := &mockCtx{Context: context.Background()}
@@ -177,7 +229,7 @@ func TestRegistersParseValue(t *testing.T) {
 		input    string
 		expected interface{}
 	}{
-		{"42", 42},
+		{"42", int64(42)},
 		{"3.14", 3.14},
 		{"true", true},
 		{"false", false},
@@ -186,8 +238,8 @@ func TestRegistersParseValue(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		result := registers.parseValue(test.input)
-		if result != test.expected {
+		result, err := registers.parseValue(test.input)
+		if err != nil || result != test.expected {
 			t.Errorf("Expected %v (%T), got %v (%T)", test.expected, test.expected, result, result)
 		}
 	}
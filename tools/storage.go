@@ -2,67 +2,160 @@ package tools
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/eliothedeman/smol/cmdline"
 	"github.com/eliothedeman/smol/unit"
 )
 
+// tempDir holds values being written. Keys cannot name anything in it,
+// as no part of a key may start with a dot.
+const tempDir = ".tmp"
+
+var ErrInvalidKey = errors.New("invalid storage key")
+
+// DefaultCompactInterval is how often storage compacts itself.
+const DefaultCompactInterval = 10 * time.Minute
+
+// Compact asks storage to compact itself. Storage sends it to itself
+// every CompactInterval.
+type Compact struct{}
+
 type Storage struct {
 	basePath string
 	mu       sync.RWMutex
 	ctx      unit.Ctx
+	once     sync.Once
+	actions  *unit.ActionSet
+
+	// CompactInterval is how often storage compacts itself; zero turns
+	// periodic compaction off.
+	CompactInterval time.Duration
 }
 
 func NewStorage(basePath string) *Storage {
 	return &Storage{
-		basePath: basePath,
+		basePath:        basePath,
+		CompactInterval: DefaultCompactInterval,
 	}
 }
 
 func (s *Storage) Init(ctx unit.Ctx) {
 	s.ctx = ctx
+	if s.CompactInterval > 0 {
+		ctx.SendEvery(ctx.Self(), Compact{}, s.CompactInterval)
+	}
+}
+
+// Terminate waits for in-flight writes and removes temp files left behind
+// by writes that were interrupted.
+func (s *Storage) Terminate(ctx unit.Ctx, reason error) {
+	s.Compact()
+}
+
+// Compact removes temp files left behind by interrupted writes and
+// directories left empty by deletes, returning how many it removed.
+func (s *Storage) Compact() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var removed int
+	temps, _ := os.ReadDir(filepath.Join(s.basePath, tempDir))
+	for _, temp := range temps {
+		if os.Remove(filepath.Join(s.basePath, tempDir, temp.Name())) == nil {
+			removed++
+		}
+	}
+
+	var dirs []string
+	filepath.WalkDir(s.basePath, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() && path != s.basePath {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+
+	// Deepest first, so that parents emptied by their children go too.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if os.Remove(dirs[i]) == nil {
+			removed++
+		}
+	}
+	return removed
+}
+
+// Describe is generated from the typed map actions.
+func (s *Storage) Describe() unit.Description {
+	return s.actionSet().Describe()
 }
 
 func (s *Storage) Handle(ctx unit.Ctx, from unit.UnitRef, message any) error {
 	switch msg := message.(type) {
+	case Compact:
+		s.Compact()
+		return nil
 	case string:
 		return s.handleStringCommand(ctx, from, msg)
 	case map[string]interface{}:
-		return s.handleMapCommand(ctx, from, msg)
+		return s.actionSet().Handle(ctx, from, msg)
 	default:
 		return fmt.Errorf("unsupported message type: %T", message)
 	}
 }
 
 func (s *Storage) Save(key string, data interface{}) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	path := filepath.Join(s.basePath, key+".json")
 	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 		return err
 	}
+	temp := filepath.Join(s.basePath, tempDir)
+	if err := os.MkdirAll(temp, 0755); err != nil {
+		return err
+	}
 
-	file, err := os.Create(path)
+	// Write to a temp file and rename it into place so a crash mid-write
+	// never leaves a truncated value behind.
+	file, err := os.CreateTemp(temp, "value-*")
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	defer os.Remove(file.Name())
 
 	encoder := json.NewEncoder(file)
 	encoder.SetIndent("", "  ")
-	return encoder.Encode(data)
+	if err := encoder.Encode(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
 }
 
 func (s *Storage) Load(key string, result interface{}) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	path := filepath.Join(s.basePath, key+".json")
 	file, err := os.Open(path)
 	if err != nil {
 		return err
@@ -74,13 +167,28 @@ func (s *Storage) Load(key string, result interface{}) error {
 }
 
 func (s *Storage) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	path := filepath.Join(s.basePath, key+".json")
 	return os.Remove(path)
 }
 
+// path returns the file holding key's value. Keys are made of parts
+// separated by "/", none of which may be empty or start with a dot.
+func (s *Storage) path(key string) (string, error) {
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || strings.HasPrefix(part, ".") || strings.ContainsRune(part, filepath.Separator) {
+			return "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+		}
+	}
+	return filepath.Join(s.basePath, key+".json"), nil
+}
+
 func (s *Storage) List() ([]string, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -108,7 +216,10 @@ func (s *Storage) Info() string {
 }
 
 func (s *Storage) handleStringCommand(ctx unit.Ctx, from unit.UnitRef, command string) error {
-	parts := strings.Fields(command)
+	parts, err := cmdline.Split(command)
+	if err != nil {
+		return err
+	}
 	if len(parts) == 0 {
 		return fmt.Errorf("empty command")
 	}
@@ -190,58 +301,43 @@ func (s *Storage) handleStringCommand(ctx unit.Ctx, from unit.UnitRef, command s
 	return nil
 }
 
-func (s *Storage) handleMapCommand(ctx unit.Ctx, from unit.UnitRef, command map[string]interface{}) error {
-	action, ok := command["action"].(string)
-	if !ok {
-		return fmt.Errorf("missing action field")
-	}
-
-	switch action {
-	case "save":
-		key, ok1 := command["key"].(string)
-		value, ok2 := command["value"]
-		if !ok1 || !ok2 {
-			return fmt.Errorf("save requires key and value")
-		}
-		if err := s.Save(key, value); err != nil {
-			return err
-		}
-		from.Send(fmt.Sprintf("Saved %s", key))
-
-	case "load":
-		key, ok := command["key"].(string)
-		if !ok {
-			return fmt.Errorf("load requires key")
-		}
-		var data interface{}
-		if err := s.Load(key, &data); err != nil {
-			return err
-		}
-		from.Send(data)
-
-	case "delete":
-		key, ok := command["key"].(string)
-		if !ok {
-			return fmt.Errorf("delete requires key")
-		}
-		if err := s.Delete(key); err != nil {
-			return err
-		}
-		from.Send(fmt.Sprintf("Deleted %s", key))
-
-	case "list":
-		keys, err := s.List()
-		if err != nil {
-			return err
-		}
-		from.Send(keys)
-
-	case "info":
-		from.Send(s.Info())
+type saveArgs struct {
+	Key   string `json:"key"`
+	Value any    `json:"value" desc:"any JSON value"`
+}
 
-	default:
-		return fmt.Errorf("unknown action: %s", action)
-	}
+type keyArgs struct {
+	Key string `json:"key"`
+}
 
-	return nil
+// actionSet routes map commands to typed handlers.
+func (s *Storage) actionSet() *unit.ActionSet {
+	s.once.Do(func() {
+		s.actions = unit.Actions("Persistent data storage and retrieval",
+			unit.Action("save", "Save a value under a key", func(ctx unit.Ctx, req saveArgs) (string, error) {
+				if err := s.Save(req.Key, req.Value); err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("Saved %s", req.Key), nil
+			}),
+			unit.Action("load", "Load the value stored under a key", func(ctx unit.Ctx, req keyArgs) (any, error) {
+				var data interface{}
+				err := s.Load(req.Key, &data)
+				return data, err
+			}),
+			unit.Action("delete", "Delete a key", func(ctx unit.Ctx, req keyArgs) (string, error) {
+				if err := s.Delete(req.Key); err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("Deleted %s", req.Key), nil
+			}),
+			unit.Action("list", "List all keys", func(ctx unit.Ctx, req struct{}) ([]string, error) {
+				return s.List()
+			}),
+			unit.Action("info", "Storage info", func(ctx unit.Ctx, req struct{}) (string, error) {
+				return s.Info(), nil
+			}),
+		)
+	})
+	return s.actions
 }
@@ -2,10 +2,18 @@ package tools
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/eliothedeman/smol/cmdline"
+	"github.com/eliothedeman/smol/unit"
+	"github.com/eliothedeman/smol/unit/unittest"
 )
 
 func TestStorageInit(t *testing.T) {
@@ -141,7 +149,7 @@ func TestStorageHandleStringCommands(t *testing.T) {
 	from := &testMessageHandler{}
 
 	// Test save command
-	err := storage.Handle(ctx, from, "save test_key {\"name\":\"test\",\"value\":42}")
+	err := storage.Handle(ctx, from, `save test_key '{"name":"test","value":42}'`)
 	if err != nil {
 		t.Errorf("Handle save command failed: %v", err)
 	}
@@ -260,6 +268,37 @@ func TestStorageSaveStringCommand(t *testing.T) {
 	}
 }
 
+func TestStorageStringCommandQuoting(t *testing.T) {
+	storage := NewStorage(t.TempDir())
+	ctx := &mockCtx{Context: context.Background()}
+	storage.Init(ctx)
+	from := &testMessageHandler{}
+
+	commands := map[string]any{
+		`save spaced "hello   world"`:                       "hello   world",
+		`save json '{"name": "a b", "n": 1}'`:               map[string]any{"name": "a b", "n": 1.0},
+		"save doc <<EOF\n{\n  \"lines\": [\"x y\"]\n}\nEOF": map[string]any{"lines": []any{"x y"}},
+	}
+	for command, want := range commands {
+		if err := storage.Handle(ctx, from, command); err != nil {
+			t.Fatalf("Handle(%q) failed: %v", command, err)
+		}
+		key := strings.Fields(command)[1]
+		var got any
+		if err := storage.Load(key, &got); err != nil {
+			t.Fatalf("Load(%s) failed: %v", key, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%q stored %#v, want %#v", command, got, want)
+		}
+	}
+
+	var syntax *cmdline.SyntaxError
+	if err := storage.Handle(ctx, from, `save k "unterminated`); !errors.As(err, &syntax) || syntax.Pos.Column != 8 {
+		t.Errorf("Expected a syntax error at column 8, got %v", err)
+	}
+}
+
 func TestStorageDirectoryCreation(t *testing.T) {
 	tempDir := t.TempDir()
 	storage := NewStorage(filepath.Join(tempDir, "subdir", "storage"))
@@ -275,3 +314,102 @@ func TestStorageDirectoryCreation(t *testing.T) {
 		t.Errorf("File %s should exist", path)
 	}
 }
+
+func TestStorageTerminateRemovesTempFiles(t *testing.T) {
+	tempDir := t.TempDir()
+	storage := NewStorage(tempDir)
+	ctx := &mockCtx{Context: context.Background()}
+	storage.Init(ctx)
+
+	if err := storage.Save("kept", "data"); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	// Simulate a write interrupted before its rename.
+	if err := storage.Save("report.tmp-v2", "kept"); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	stray := filepath.Join(tempDir, ".tmp", "value-123")
+	if err := os.WriteFile(stray, []byte(`{"partial"`), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	storage.Terminate(ctx, nil)
+
+	if _, err := os.Stat(stray); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be removed", stray)
+	}
+	var result string
+	if err := storage.Load("kept", &result); err != nil || result != "data" {
+		t.Errorf("Expected saved data to survive, got %q (err=%v)", result, err)
+	}
+}
+
+func TestStorageCommandRoundTripsThroughCodecs(t *testing.T) {
+	messages := []any{
+		StorageCommand{Action: "save", Key: "config", Data: map[string]any{"depth": 3.0, "tags": []any{"a", "b"}}},
+		StorageCommand{Action: "load", Path: "configs", Key: "config"},
+		StorageResult{Success: true, Data: "saved", Path: "configs/config.json"},
+	}
+	for _, codec := range unit.Codecs() {
+		for _, msg := range messages {
+			data, err := codec.Marshal(msg)
+			if err != nil {
+				t.Fatalf("%s: Marshal(%+v) failed: %v", codec.Name(), msg, err)
+			}
+			got, err := codec.Unmarshal(data)
+			if err != nil {
+				t.Fatalf("%s: Unmarshal(%+v) failed: %v", codec.Name(), msg, err)
+			}
+			if !reflect.DeepEqual(got, msg) {
+				t.Errorf("%s: round trip of %+v gave %+v", codec.Name(), msg, got)
+			}
+		}
+	}
+}
+
+func TestStorageCompactsPeriodically(t *testing.T) {
+	tempDir := t.TempDir()
+	storage := NewStorage(tempDir)
+	storage.CompactInterval = time.Minute
+	h := unittest.New(t)
+	ref := h.Add("storage", storage)
+
+	if err := storage.Save("nested/key", "data"); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if err := storage.Delete("nested/key"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := storage.Save("report.tmp-v2", "kept"); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	stray := filepath.Join(tempDir, ".tmp", "value-123")
+	if err := os.WriteFile(stray, []byte(`{"partial"`), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	h.Advance(59 * time.Second)
+	if n := h.Run(); n != 0 {
+		t.Fatalf("Expected no compaction before the interval, got %d messages", n)
+	}
+	h.Advance(time.Second)
+	h.ExpectSent("storage", unittest.Equal(Compact{}), 1)
+	h.Run()
+
+	for _, path := range []string{stray, filepath.Join(tempDir, "nested")} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed", path)
+		}
+	}
+	var kept string
+	if err := storage.Load("report.tmp-v2", &kept); err != nil || kept != "kept" {
+		t.Errorf("Expected a key that looks like a temp file to be kept, got %q, %v", kept, err)
+	}
+
+	ref.Stop()
+	h.Advance(time.Hour)
+	if pending := h.Pending(); len(pending) != 0 {
+		t.Errorf("Expected no compaction after stopping, got %v", pending)
+	}
+}
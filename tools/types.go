@@ -1,5 +1,20 @@
 package tools
 
+import "github.com/eliothedeman/smol/unit"
+
+func init() {
+	unit.RegisterMessage[Command]("tools.Command")
+	unit.RegisterMessage[RegistersCommand]("tools.RegistersCommand")
+	unit.RegisterMessage[MathCommand]("tools.MathCommand")
+	unit.RegisterMessage[MathResult]("tools.MathResult")
+	unit.RegisterMessage[StorageCommand]("tools.StorageCommand")
+	unit.RegisterMessage[StorageResult]("tools.StorageResult")
+	unit.RegisterMessage[Compact]("tools.Compact")
+	unit.RegisterMessage[MemoryCommand]("tools.MemoryCommand")
+	unit.RegisterMessage[ExecutionRequest]("tools.ExecutionRequest")
+	unit.RegisterMessage[ExecutionResult]("tools.ExecutionResult")
+}
+
 // Command represents a generic command structure for all tools
 type Command struct {
 	Action string      `json:"action"`
@@ -0,0 +1,90 @@
+// Package transport connects registries in different processes, so that
+// a unit can be reached through a local proxy as if it lived in-process.
+package transport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Version is the version of the wire format written in every frame.
+const Version = 2
+
+// MaxFrameSize bounds the frames a peer will read.
+const MaxFrameSize = 16 << 20
+
+var (
+	ErrFrameTooLarge = errors.New("frame too large")
+	ErrVersion       = errors.New("unsupported frame version")
+)
+
+type frameKind uint8
+
+const (
+	// kindSend carries a message for a unit. ID is non-zero when the
+	// sender can take replies.
+	kindSend frameKind = iota + 1
+	// kindReply carries a reply to the message with ID.
+	kindReply
+	// kindReject reports that the message with ID could not be handled.
+	kindReject
+)
+
+// frame is the unit of the wire format. On the wire it is a big-endian
+// uint32 length, a version byte and the gob-encoded frame. Msg holds the
+// message encoded with unit.Binary, so message types must be registered
+// with unit.RegisterMessage in both processes.
+type frame struct {
+	Kind frameKind
+	ID   uint64
+	To   string
+	From string
+	Msg  []byte
+	Err  string
+}
+
+func writeFrame(w io.Writer, f frame) error {
+	var buf bytes.Buffer
+	buf.Write([]byte{0, 0, 0, 0, Version})
+	if err := gob.NewEncoder(&buf).Encode(&f); err != nil {
+		return fmt.Errorf("encode frame: %w", err)
+	}
+
+	b := buf.Bytes()
+	if len(b)-4 > MaxFrameSize {
+		return ErrFrameTooLarge
+	}
+	binary.BigEndian.PutUint32(b, uint32(len(b)-4))
+	_, err := w.Write(b)
+	return err
+}
+
+func readFrame(r io.Reader) (frame, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return frame{}, err
+	}
+
+	size := binary.BigEndian.Uint32(header[:4])
+	if size > MaxFrameSize {
+		return frame{}, ErrFrameTooLarge
+	}
+	if size == 0 || header[4] != Version {
+		return frame{}, fmt.Errorf("%w: %d", ErrVersion, header[4])
+	}
+
+	body := make([]byte, size-1)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return frame{}, err
+	}
+
+	var f frame
+	if err := gob.NewDecoder(bytes.NewReader(body)).Decode(&f); err != nil {
+		return frame{}, fmt.Errorf("decode frame: %w", err)
+	}
+	return f, nil
+}
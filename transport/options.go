@@ -0,0 +1,35 @@
+package transport
+
+// Option configures a Peer, or every peer a Server accepts.
+type Option func(*config)
+
+type config struct {
+	exports     map[string]bool
+	allowRemote bool
+}
+
+func newConfig(opts []Option) config {
+	c := config{exports: make(map[string]bool)}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// Export lets the remote process send to the named local units. Messages
+// for any other unit are rejected as if it did not exist.
+func Export(names ...string) Option {
+	return func(c *config) {
+		for _, name := range names {
+			c.exports[name] = true
+		}
+	}
+}
+
+// AllowRemote lets a Server accept connections from other hosts. Without
+// it, only loopback TCP and unix socket connections are accepted.
+func AllowRemote() Option {
+	return func(c *config) {
+		c.allowRemote = true
+	}
+}
@@ -0,0 +1,401 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/eliothedeman/smol/unit"
+)
+
+// MaxPendingReplies bounds how many senders a peer remembers so that
+// replies can reach them. The oldest are forgotten first.
+const MaxPendingReplies = 4096
+
+// MaxQueuedMessages bounds how many messages from the remote process wait
+// to be delivered. Further messages are rejected with ErrPeerBusy.
+const MaxQueuedMessages = 1024
+
+var (
+	ErrPeerClosed = errors.New("peer closed")
+	ErrPeerBusy   = errors.New("peer busy")
+)
+
+// RemoteError is the error an Ask fails with when the remote unit could
+// not handle the message.
+type RemoteError struct {
+	Unit string
+	Err  string
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("remote %s: %s", e.Unit, e.Err)
+}
+
+// Peer is one end of a connection between two processes. Messages for
+// the remote process's units are sent through proxies made with Proxy;
+// messages from the remote process are delivered to the local registry's
+// exported units.
+//
+// Frames are delivered off the connection's read goroutine, messages and
+// replies through separate queues, so that a unit slow to take messages
+// holds up neither reading nor the replies it may be waiting for.
+type Peer struct {
+	conn net.Conn
+	reg  *unit.Registry
+	cfg  config
+
+	wmu sync.Mutex
+
+	messages frameQueue
+	replies  frameQueue
+
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[uint64]pendingReply
+	order   []uint64
+	err     error
+	done    chan struct{}
+}
+
+type pendingReply struct {
+	to   string
+	from unit.UnitRef
+}
+
+// NewPeer starts serving conn. Messages arriving on it are delivered to
+// the units of reg exported with Export; reg may be nil for a peer that
+// only sends.
+func NewPeer(conn net.Conn, reg *unit.Registry, opts ...Option) *Peer {
+	p := &Peer{
+		conn:     conn,
+		reg:      reg,
+		cfg:      newConfig(opts),
+		messages: newFrameQueue(),
+		replies:  newFrameQueue(),
+		pending:  make(map[uint64]pendingReply),
+		done:     make(chan struct{}),
+	}
+	go p.run()
+	go p.drain(&p.messages)
+	go p.drain(&p.replies)
+	return p
+}
+
+// Dial connects to a process listening on network and address, such as
+// "tcp" and "host:7070", or "unix" and a socket path.
+func Dial(network, address string, reg *unit.Registry, opts ...Option) (*Peer, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewPeer(conn, reg, opts...), nil
+}
+
+// Proxy returns a unit that forwards every message to the remote unit
+// called name. Register it locally, under any name, to make the remote
+// unit reachable like a local one.
+func (p *Peer) Proxy(name string) unit.Unit {
+	return &proxy{peer: p, name: name}
+}
+
+// Done is closed once the connection has failed or been closed.
+func (p *Peer) Done() <-chan struct{} {
+	return p.done
+}
+
+// Err returns why the peer stopped, once Done is closed.
+func (p *Peer) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+func (p *Peer) Close() error {
+	err := p.conn.Close()
+	<-p.done
+	return err
+}
+
+func (p *Peer) run() {
+	var err error
+	for {
+		var f frame
+		if f, err = readFrame(p.conn); err != nil {
+			break
+		}
+		p.queue(f)
+	}
+	p.conn.Close()
+	// Replies already read still reach their senders.
+	for f, ok := p.replies.pop(); ok; f, ok = p.replies.pop() {
+		p.receive(f)
+	}
+
+	p.mu.Lock()
+	if errors.Is(err, net.ErrClosed) {
+		err = ErrPeerClosed
+	}
+	p.err = err
+	pending := p.pending
+	p.pending = nil
+	p.order = nil
+	p.mu.Unlock()
+
+	for _, reply := range pending {
+		if r, ok := reply.from.(unit.Rejecter); ok {
+			r.Reject(fmt.Errorf("%w: %v", ErrPeerClosed, err))
+		}
+	}
+	close(p.done)
+}
+
+// queue hands a frame read from the connection to the goroutine that
+// delivers it.
+func (p *Peer) queue(f frame) {
+	if f.Kind != kindSend {
+		p.replies.push(f)
+		return
+	}
+	if !p.messages.pushBelow(f, MaxQueuedMessages) {
+		from := &remoteRef{peer: p, id: f.ID, name: f.From, unit: f.To}
+		from.Reject(ErrPeerBusy)
+	}
+}
+
+// drain delivers the frames in q until the peer stops.
+func (p *Peer) drain(q *frameQueue) {
+	for {
+		select {
+		case <-q.ready:
+		case <-p.done:
+			return
+		}
+		for f, ok := q.pop(); ok; f, ok = q.pop() {
+			p.receive(f)
+		}
+	}
+}
+
+func (p *Peer) receive(f frame) {
+	switch f.Kind {
+	case kindSend:
+		from := &remoteRef{peer: p, id: f.ID, name: f.From, unit: f.To}
+		msg, err := unit.Binary.Unmarshal(f.Msg)
+		if err == nil {
+			err = unit.ErrUnknownUnit
+			if p.reg != nil && p.cfg.exports[f.To] {
+				err = p.reg.Deliver(f.To, from, msg)
+			}
+		}
+		if err != nil {
+			from.Reject(err)
+		}
+	case kindReply:
+		reply, ok := p.take(f.ID, false)
+		if !ok {
+			return
+		}
+		msg, err := unit.Binary.Unmarshal(f.Msg)
+		if err != nil {
+			err = &RemoteError{Unit: reply.to, Err: err.Error()}
+			if r, ok := reply.from.(unit.Rejecter); ok {
+				r.Reject(err)
+			} else {
+				log.Printf("transport: reply: %v", err)
+			}
+			return
+		}
+		reply.from.Send(msg)
+	case kindReject:
+		if reply, ok := p.take(f.ID, true); ok {
+			err := &RemoteError{Unit: reply.to, Err: f.Err}
+			if r, ok := reply.from.(unit.Rejecter); ok {
+				r.Reject(err)
+			} else {
+				log.Printf("transport: %v", err)
+			}
+		}
+	default:
+		log.Printf("transport: ignoring frame of unknown kind %d", f.Kind)
+	}
+}
+
+// expect remembers from so that replies to the message with the returned
+// ID reach it. Senders that cannot take replies get ID 0.
+func (p *Peer) expect(to string, from unit.UnitRef) uint64 {
+	if from == nil || from == unit.NoSender {
+		return 0
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pending == nil {
+		return 0
+	}
+	p.nextID++
+	p.pending[p.nextID] = pendingReply{to: to, from: from}
+	p.order = append(p.order, p.nextID)
+
+	for len(p.pending) > MaxPendingReplies && len(p.order) > 0 {
+		delete(p.pending, p.order[0])
+		p.order = p.order[1:]
+	}
+	if len(p.order) > 2*MaxPendingReplies {
+		live := p.order[:0]
+		for _, id := range p.order {
+			if _, ok := p.pending[id]; ok {
+				live = append(live, id)
+			}
+		}
+		p.order = live
+	}
+	return p.nextID
+}
+
+// take looks up the sender waiting on id. Asks are answered once, so they
+// are forgotten after their first reply; other senders may get several.
+func (p *Peer) take(id uint64, forget bool) (pendingReply, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	reply, ok := p.pending[id]
+	if !ok {
+		return reply, false
+	}
+	if _, isAsk := unit.CorrelationID(reply.from); forget || isAsk {
+		delete(p.pending, id)
+	}
+	return reply, true
+}
+
+func (p *Peer) forget(id uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.pending, id)
+}
+
+func (p *Peer) write(f frame) error {
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+
+	select {
+	case <-p.done:
+		return ErrPeerClosed
+	default:
+	}
+	return writeFrame(p.conn, f)
+}
+
+// proxy stands in for a unit in the remote process.
+type proxy struct {
+	peer *Peer
+	name string
+}
+
+func (x *proxy) Init(ctx unit.Ctx) {}
+
+func (x *proxy) Handle(ctx unit.Ctx, from unit.UnitRef, message any) error {
+	data, err := unit.Binary.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("send to remote %s: %w", x.name, err)
+	}
+
+	id := x.peer.expect(x.name, from)
+	err = x.peer.write(frame{
+		Kind: kindSend,
+		ID:   id,
+		To:   x.name,
+		From: from.Name(),
+		Msg:  data,
+	})
+	if err != nil {
+		x.peer.forget(id)
+		return fmt.Errorf("send to remote %s: %w", x.name, err)
+	}
+	return nil
+}
+
+// remoteRef is the sender a local unit sees for a message from the remote
+// process. Replies on it travel back to the original sender.
+type remoteRef struct {
+	peer *Peer
+	id   uint64
+	name string
+	unit string
+}
+
+func (r *remoteRef) Name() string {
+	return r.name
+}
+
+func (r *remoteRef) Send(msg any) error {
+	if r.id == 0 {
+		return unit.ErrNoSender
+	}
+	data, err := unit.Binary.Marshal(msg)
+	if err != nil {
+		r.Reject(err)
+		return err
+	}
+	return r.peer.write(frame{Kind: kindReply, ID: r.id, Msg: data})
+}
+
+func (r *remoteRef) Stop() {}
+
+func (r *remoteRef) Reject(err error) {
+	if r.id == 0 {
+		log.Printf("transport: %s: %v", r.unit, err)
+		return
+	}
+	r.peer.write(frame{Kind: kindReject, ID: r.id, Err: err.Error()})
+}
+
+// frameQueue holds frames waiting to be delivered. ready is signalled
+// whenever frames are pushed.
+type frameQueue struct {
+	mu     sync.Mutex
+	frames []frame
+	ready  chan struct{}
+}
+
+func newFrameQueue() frameQueue {
+	return frameQueue{ready: make(chan struct{}, 1)}
+}
+
+func (q *frameQueue) push(f frame) {
+	q.pushBelow(f, -1)
+}
+
+// pushBelow queues f unless limit or more frames are already waiting. A
+// negative limit means no limit.
+func (q *frameQueue) pushBelow(f frame, limit int) bool {
+	q.mu.Lock()
+	if limit >= 0 && len(q.frames) >= limit {
+		q.mu.Unlock()
+		return false
+	}
+	q.frames = append(q.frames, f)
+	q.mu.Unlock()
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+func (q *frameQueue) pop() (frame, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.frames) == 0 {
+		return frame{}, false
+	}
+	f := q.frames[0]
+	q.frames[0] = frame{}
+	q.frames = q.frames[1:]
+	return f, true
+}
@@ -0,0 +1,143 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/eliothedeman/smol/unit"
+)
+
+var ErrNotLocal = errors.New("address is not local")
+
+// Server accepts connections from other processes and delivers their
+// messages to a registry's exported units. Unless AllowRemote is given it
+// only listens on, and accepts connections from, loopback addresses and
+// unix sockets.
+type Server struct {
+	reg  *unit.Registry
+	opts []Option
+	cfg  config
+
+	mu    sync.Mutex
+	ln    net.Listener
+	peers map[*Peer]struct{}
+}
+
+func NewServer(reg *unit.Registry, opts ...Option) *Server {
+	return &Server{
+		reg:   reg,
+		opts:  opts,
+		cfg:   newConfig(opts),
+		peers: make(map[*Peer]struct{}),
+	}
+}
+
+// Listen starts a server for reg on network and address.
+func Listen(reg *unit.Registry, network, address string, opts ...Option) (*Server, error) {
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	s := NewServer(reg, opts...)
+	if !s.cfg.allowRemote && !isLocal(ln.Addr()) {
+		ln.Close()
+		return nil, fmt.Errorf("listen on %s: %w", ln.Addr(), ErrNotLocal)
+	}
+	s.ln = ln
+	go s.Serve(ln)
+	return s, nil
+}
+
+// Addr returns the address the server is listening on.
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ln == nil {
+		return nil
+	}
+	return s.ln.Addr()
+}
+
+// Serve accepts connections on ln until it is closed.
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	s.ln = ln
+	s.mu.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		if !s.cfg.allowRemote && !isLocal(conn.RemoteAddr()) {
+			conn.Close()
+			continue
+		}
+
+		peer := NewPeer(conn, s.reg, s.opts...)
+		s.mu.Lock()
+		s.peers[peer] = struct{}{}
+		s.mu.Unlock()
+
+		go func() {
+			<-peer.Done()
+			s.mu.Lock()
+			delete(s.peers, peer)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// Close stops accepting connections and closes those already accepted.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	ln := s.ln
+	peers := make([]*Peer, 0, len(s.peers))
+	for peer := range s.peers {
+		peers = append(peers, peer)
+	}
+	s.mu.Unlock()
+
+	var err error
+	if ln != nil {
+		err = ln.Close()
+	}
+	for _, peer := range peers {
+		peer.Close()
+	}
+	return err
+}
+
+// isLocal reports whether addr is a loopback TCP address or a unix socket.
+func isLocal(addr net.Addr) bool {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP.IsLoopback()
+	case *net.UnixAddr:
+		return true
+	default:
+		return false
+	}
+}
+
+// ParseAddr splits an address such as "tcp:host:7070" or
+// "unix:/run/smol.sock" into its network and address.
+func ParseAddr(s string) (network, address string, err error) {
+	network, address, ok := strings.Cut(s, ":")
+	if !ok || address == "" {
+		return "", "", fmt.Errorf("invalid address %q, want network:address", s)
+	}
+	switch network {
+	case "tcp", "tcp4", "tcp6", "unix":
+		return network, address, nil
+	default:
+		return "", "", fmt.Errorf("unsupported network %q", network)
+	}
+}
@@ -0,0 +1,284 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/eliothedeman/smol/unit"
+)
+
+type echoUnit struct{}
+
+func (e *echoUnit) Init(ctx unit.Ctx) {}
+
+func (e *echoUnit) Handle(ctx unit.Ctx, from unit.UnitRef, message any) error {
+	if message == "fail" {
+		return errors.New("cannot handle fail")
+	}
+	if message == "silent" {
+		return nil
+	}
+	return from.Send(map[string]any{"echo": message, "from": from.Name()})
+}
+
+// relayUnit sends every string it gets to the unit named to and hands
+// the replies to a channel.
+type relayUnit struct {
+	to      string
+	replies chan any
+}
+
+func (r *relayUnit) Init(ctx unit.Ctx) {}
+
+func (r *relayUnit) Handle(ctx unit.Ctx, from unit.UnitRef, message any) error {
+	if s, ok := message.(string); ok {
+		for _, u := range ctx.Units() {
+			if u.Name == r.to {
+				return ctx.Send(u.Ref, s)
+			}
+		}
+		return fmt.Errorf("no unit %s", r.to)
+	}
+	r.replies <- message
+	return nil
+}
+
+func startRegistry(t *testing.T, units map[string]unit.Unit) *unit.Registry {
+	t.Helper()
+	reg := unit.NewRegistry()
+	for name, u := range units {
+		reg.Register(name, u)
+	}
+	if err := reg.Start(); err != nil {
+		t.Fatalf("Failed to start registry: %v", err)
+	}
+	t.Cleanup(reg.Stop)
+	return reg
+}
+
+func connect(t *testing.T, network, address string) (*Server, *unit.Registry, chan any) {
+	t.Helper()
+	remote := startRegistry(t, map[string]unit.Unit{"echo": &echoUnit{}, "private": &echoUnit{}})
+	server, err := Listen(remote, network, address, Export("echo"))
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+
+	peer, err := Dial(network, server.Addr().String(), nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { peer.Close() })
+
+	replies := make(chan any, 4)
+	local := startRegistry(t, map[string]unit.Unit{
+		"remote-echo": peer.Proxy("echo"),
+		"missing":     peer.Proxy("missing"),
+		"private":     peer.Proxy("private"),
+		"relay":       &relayUnit{to: "remote-echo", replies: replies},
+	})
+	return server, local, replies
+}
+
+func TestAskRemoteUnit(t *testing.T) {
+	for _, network := range []string{"tcp", "unix"} {
+		t.Run(network, func(t *testing.T) {
+			address := "127.0.0.1:0"
+			if network == "unix" {
+				address = filepath.Join(t.TempDir(), "smol.sock")
+			}
+			_, local, _ := connect(t, network, address)
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			reply, err := local.Ask(ctx, "remote-echo", map[string]any{"action": "ping", "n": 1.0})
+			if err != nil {
+				t.Fatalf("Ask failed: %v", err)
+			}
+			got := reply.(map[string]any)["echo"].(map[string]any)
+			if got["action"] != "ping" || got["n"] != 1.0 {
+				t.Errorf("Unexpected reply: %v", reply)
+			}
+		})
+	}
+}
+
+func TestRepliesReachLocalSender(t *testing.T) {
+	_, local, replies := connect(t, "tcp", "127.0.0.1:0")
+
+	local.Deliver("relay", unit.NoSender, "hello")
+	select {
+	case reply := <-replies:
+		got := reply.(map[string]any)
+		if got["echo"] != "hello" || got["from"] != "relay" {
+			t.Errorf("Unexpected reply: %v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the remote reply")
+	}
+}
+
+func TestRemoteFailures(t *testing.T) {
+	server, local, _ := connect(t, "tcp", "127.0.0.1:0")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var remoteErr *RemoteError
+	if _, err := local.Ask(ctx, "remote-echo", "fail"); !errors.As(err, &remoteErr) || remoteErr.Unit != "echo" {
+		t.Errorf("Expected a RemoteError from echo, got %v", err)
+	}
+	if _, err := local.Ask(ctx, "missing", "hello"); !errors.As(err, &remoteErr) {
+		t.Errorf("Expected a RemoteError for an unknown unit, got %v", err)
+	}
+	if _, err := local.Ask(ctx, "private", "hello"); !errors.As(err, &remoteErr) || remoteErr.Err != unit.ErrUnknownUnit.Error() {
+		t.Errorf("Expected an unexported unit to look unknown, got %v", err)
+	}
+
+	pending := make(chan error, 1)
+	go func() {
+		_, err := local.Ask(ctx, "remote-echo", "silent")
+		pending <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	server.Close()
+
+	select {
+	case err := <-pending:
+		if !errors.Is(err, ErrPeerClosed) {
+			t.Errorf("Expected ErrPeerClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the pending Ask to fail when the peer closed")
+	}
+}
+
+func TestListenOnlyLocally(t *testing.T) {
+	reg := startRegistry(t, nil)
+	if _, err := Listen(reg, "tcp", ":0"); !errors.Is(err, ErrNotLocal) {
+		t.Errorf("Expected ErrNotLocal listening on every interface, got %v", err)
+	}
+	server, err := Listen(reg, "tcp", ":0", AllowRemote())
+	if err != nil {
+		t.Fatalf("Listen with AllowRemote failed: %v", err)
+	}
+	server.Close()
+}
+
+// askerUnit asks the unit named to with every message it gets, handing
+// the replies to a channel.
+type askerUnit struct {
+	to      string
+	replies chan any
+}
+
+func (a *askerUnit) Init(ctx unit.Ctx) {}
+
+func (a *askerUnit) Handle(ctx unit.Ctx, from unit.UnitRef, message any) error {
+	for _, u := range ctx.Units() {
+		if u.Name == a.to {
+			reply, err := ctx.Ask(u.Ref, message)
+			if err != nil {
+				return err
+			}
+			a.replies <- reply
+			return nil
+		}
+	}
+	return fmt.Errorf("no unit %s", a.to)
+}
+
+func TestRepliesPassBlockedDeliveries(t *testing.T) {
+	// The asker's mailbox fills up, blocking delivery of further messages
+	// from the peer while the asker waits for a reply on the same
+	// connection.
+	left, right := net.Pipe()
+	replies := make(chan any, 8)
+	localReg := unit.NewRegistry()
+	localReg.Register("asker", &askerUnit{to: "remote-echo", replies: replies}, unit.WithMailboxSize(1))
+	local := NewPeer(left, localReg, Export("asker"))
+	localReg.Register("remote-echo", local.Proxy("echo"))
+	if err := localReg.Start(); err != nil {
+		t.Fatalf("Failed to start registry: %v", err)
+	}
+	t.Cleanup(localReg.Stop)
+	t.Cleanup(func() { local.Close() })
+
+	remote := NewPeer(right, startRegistry(t, map[string]unit.Unit{"echo": &echoUnit{}}), Export("echo"))
+	t.Cleanup(func() { remote.Close() })
+	toAsker := startRegistry(t, map[string]unit.Unit{"asker": remote.Proxy("asker")})
+
+	const n = 5
+	for i := 0; i < n; i++ {
+		toAsker.Deliver("asker", unit.NoSender, fmt.Sprint(i))
+	}
+	for i := 0; i < n; i++ {
+		select {
+		case <-replies:
+		case <-time.After(time.Second):
+			t.Fatalf("Got %d of %d replies", i, n)
+		}
+	}
+}
+
+func TestAskRemoteUnregisteredType(t *testing.T) {
+	_, local, _ := connect(t, "tcp", "127.0.0.1:0")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	type private struct{ N int }
+	if _, err := local.Ask(ctx, "remote-echo", private{N: 1}); !errors.Is(err, unit.ErrUnknownType) {
+		t.Errorf("Expected ErrUnknownType, got %v", err)
+	}
+}
+
+func TestFrameCodec(t *testing.T) {
+	var buf bytes.Buffer
+	in := frame{Kind: kindSend, ID: 7, To: "math", From: "executor", Msg: []byte("add")}
+	if err := writeFrame(&buf, in); err != nil {
+		t.Fatalf("writeFrame failed: %v", err)
+	}
+	if buf.Bytes()[4] != Version {
+		t.Errorf("Expected version byte %d, got %d", Version, buf.Bytes()[4])
+	}
+
+	out, err := readFrame(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("readFrame failed: %v", err)
+	}
+	if out.ID != 7 || out.To != "math" || string(out.Msg) != "add" {
+		t.Errorf("Unexpected frame: %+v", out)
+	}
+
+	bad := append([]byte(nil), buf.Bytes()...)
+	bad[4] = Version + 1
+	if _, err := readFrame(bytes.NewReader(bad)); !errors.Is(err, ErrVersion) {
+		t.Errorf("Expected ErrVersion, got %v", err)
+	}
+
+	huge := []byte{0xff, 0xff, 0xff, 0xff, Version}
+	if _, err := readFrame(bytes.NewReader(huge)); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("Expected ErrFrameTooLarge, got %v", err)
+	}
+}
+
+func TestParseAddr(t *testing.T) {
+	if n, a, err := ParseAddr("unix:/run/smol.sock"); err != nil || n != "unix" || a != "/run/smol.sock" {
+		t.Errorf("ParseAddr(unix) = %s, %s, %v", n, a, err)
+	}
+	if n, a, err := ParseAddr("tcp:localhost:7070"); err != nil || n != "tcp" || a != "localhost:7070" {
+		t.Errorf("ParseAddr(tcp) = %s, %s, %v", n, a, err)
+	}
+	for _, bad := range []string{"localhost", "udp:host:1", "tcp:"} {
+		if _, _, err := ParseAddr(bad); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+}
@@ -0,0 +1,649 @@
+package unit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"unicode/utf8"
+)
+
+// This file implements the subset of CBOR (RFC 8949) that messages need:
+// integers, floats, strings, byte strings, arrays, maps, booleans and
+// null. Indefinite lengths are not supported and tags are ignored.
+
+const (
+	cborUint   byte = 0 << 5
+	cborNegInt byte = 1 << 5
+	cborBytes  byte = 2 << 5
+	cborText   byte = 3 << 5
+	cborArray  byte = 4 << 5
+	cborMap    byte = 5 << 5
+	cborTag    byte = 6 << 5
+
+	cborFalse     byte = 0xf4
+	cborTrue      byte = 0xf5
+	cborNull      byte = 0xf6
+	cborUndefined byte = 0xf7
+	cborFloat64   byte = 0xfb
+)
+
+// maxCBORDepth bounds nesting so that hostile input cannot exhaust the
+// stack.
+const maxCBORDepth = 256
+
+var (
+	errCBORTruncated = errors.New("cbor: unexpected end of data")
+	errCBORDepth     = errors.New("cbor: value nested too deeply")
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// cborEncoder writes values as CBOR. Structs are maps keyed by their JSON
+// field names, or arrays in field order when positional is set. Errors
+// are written as their message.
+type cborEncoder struct {
+	buf        []byte
+	positional bool
+}
+
+func (e *cborEncoder) head(major byte, n uint64) {
+	switch {
+	case n < 24:
+		e.buf = append(e.buf, major|byte(n))
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, major|24, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, major|25), uint16(n))
+	case n <= math.MaxUint32:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, major|26), uint32(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint64(append(e.buf, major|27), n)
+	}
+}
+
+func (e *cborEncoder) text(s string) {
+	e.head(cborText, uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *cborEncoder) encode(v reflect.Value, depth int) error {
+	if depth > maxCBORDepth {
+		return errCBORDepth
+	}
+	if !v.IsValid() {
+		e.buf = append(e.buf, cborNull)
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			e.buf = append(e.buf, cborNull)
+			return nil
+		}
+		if err, ok := v.Interface().(error); ok {
+			e.text(err.Error())
+			return nil
+		}
+		return e.encode(v.Elem(), depth+1)
+	case reflect.Pointer:
+		if v.IsNil() {
+			e.buf = append(e.buf, cborNull)
+			return nil
+		}
+		return e.encode(v.Elem(), depth+1)
+	case reflect.Bool:
+		if v.Bool() {
+			e.buf = append(e.buf, cborTrue)
+		} else {
+			e.buf = append(e.buf, cborFalse)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n := v.Int(); n < 0 {
+			e.head(cborNegInt, uint64(-1-n))
+		} else {
+			e.head(cborUint, uint64(n))
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.head(cborUint, v.Uint())
+	case reflect.Float32, reflect.Float64:
+		e.buf = binary.BigEndian.AppendUint64(append(e.buf, cborFloat64), math.Float64bits(v.Float()))
+	case reflect.String:
+		e.text(v.String())
+	case reflect.Slice:
+		if v.IsNil() {
+			e.buf = append(e.buf, cborNull)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.head(cborBytes, uint64(v.Len()))
+			e.buf = append(e.buf, v.Bytes()...)
+			return nil
+		}
+		return e.array(v, depth)
+	case reflect.Array:
+		return e.array(v, depth)
+	case reflect.Map:
+		if v.IsNil() {
+			e.buf = append(e.buf, cborNull)
+			return nil
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		e.head(cborMap, uint64(len(keys)))
+		for _, key := range keys {
+			if err := e.encode(key, depth+1); err != nil {
+				return err
+			}
+			if err := e.encode(v.MapIndex(key), depth+1); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		fields := structFields(v.Type())
+		if e.positional {
+			e.head(cborArray, uint64(len(fields)))
+		} else {
+			e.head(cborMap, uint64(len(fields)))
+		}
+		for _, f := range fields {
+			if !e.positional {
+				e.text(f.name)
+			}
+			if err := e.encode(v.Field(f.index), depth+1); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("cbor: cannot encode %s", v.Type())
+	}
+	return nil
+}
+
+func (e *cborEncoder) array(v reflect.Value, depth int) error {
+	e.head(cborArray, uint64(v.Len()))
+	for i := 0; i < v.Len(); i++ {
+		if err := e.encode(v.Index(i), depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cborDecoder reads CBOR into Go values. Structs may be given either as
+// maps keyed by JSON field name or as arrays in field order.
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+// message decodes the rest of the data as a value of mt's type.
+func (d *cborDecoder) message(mt *messageType) (any, error) {
+	if mt.typ == nil {
+		if _, err := d.decodeAny(0); err != nil {
+			return nil, err
+		}
+		return nil, d.end()
+	}
+
+	v := reflect.New(mt.typ).Elem()
+	if err := d.decode(v, 0); err != nil {
+		return nil, fmt.Errorf("%s: %w", mt.name, err)
+	}
+	if err := d.end(); err != nil {
+		return nil, err
+	}
+	return v.Interface(), nil
+}
+
+func (d *cborDecoder) end() error {
+	if d.pos != len(d.data) {
+		return fmt.Errorf("cbor: %d bytes of trailing data", len(d.data)-d.pos)
+	}
+	return nil
+}
+
+func (d *cborDecoder) peek() (byte, error) {
+	if d.pos >= len(d.data) {
+		return 0, errCBORTruncated
+	}
+	return d.data[d.pos], nil
+}
+
+// head reads an item's initial byte and argument. For floats the argument
+// holds the bits of the value.
+func (d *cborDecoder) head() (major, info byte, arg uint64, err error) {
+	b, err := d.peek()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	d.pos++
+	major, info = b&0xe0, b&0x1f
+
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info <= 27:
+		n := 1 << (info - 24)
+		if len(d.data)-d.pos < n {
+			return 0, 0, 0, errCBORTruncated
+		}
+		for _, c := range d.data[d.pos : d.pos+n] {
+			arg = arg<<8 | uint64(c)
+		}
+		d.pos += n
+	case info == 31:
+		return 0, 0, 0, errors.New("cbor: indefinite lengths are not supported")
+	default:
+		return 0, 0, 0, fmt.Errorf("cbor: reserved additional information %d", info)
+	}
+	return major, info, arg, nil
+}
+
+func (d *cborDecoder) bytes(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, errCBORTruncated
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+// count checks that n items could fit in the remaining data before
+// anything is allocated for them.
+func (d *cborDecoder) count(n uint64) (int, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return 0, errCBORTruncated
+	}
+	return int(n), nil
+}
+
+func (d *cborDecoder) expect(want byte) (uint64, error) {
+	major, _, arg, err := d.head()
+	if err != nil {
+		return 0, err
+	}
+	if major != want {
+		return 0, fmt.Errorf("cbor: found major type %d, want %d", major>>5, want>>5)
+	}
+	return arg, nil
+}
+
+// decodeAny reads the next item as the Go value encoding/json would
+// produce, except that integers are int64 (or uint64 when too large) and
+// byte strings are []byte.
+func (d *cborDecoder) decodeAny(depth int) (any, error) {
+	if depth > maxCBORDepth {
+		return nil, errCBORDepth
+	}
+	major, info, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case cborUint:
+		if arg > math.MaxInt64 {
+			return arg, nil
+		}
+		return int64(arg), nil
+	case cborNegInt:
+		if arg > math.MaxInt64 {
+			return nil, errors.New("cbor: negative integer overflows int64")
+		}
+		return -1 - int64(arg), nil
+	case cborBytes:
+		b, err := d.bytes(arg)
+		return bytes.Clone(b), err
+	case cborText:
+		b, err := d.bytes(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !utf8.Valid(b) {
+			return nil, errors.New("cbor: text is not valid UTF-8")
+		}
+		return string(b), nil
+	case cborArray:
+		n, err := d.count(arg)
+		if err != nil {
+			return nil, err
+		}
+		list := make([]any, n)
+		for i := range list {
+			if list[i], err = d.decodeAny(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	case cborMap:
+		n, err := d.count(arg)
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[string]any, n)
+		for i := 0; i < n; i++ {
+			key, err := d.decodeAny(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			s, ok := key.(string)
+			if !ok {
+				s = fmt.Sprint(key)
+			}
+			if m[s], err = d.decodeAny(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case cborTag:
+		return d.decodeAny(depth + 1)
+	default:
+		return simpleValue(info, arg)
+	}
+}
+
+func simpleValue(info byte, arg uint64) (any, error) {
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		return float16(uint16(arg)), nil
+	case 26:
+		return float64(math.Float32frombits(uint32(arg))), nil
+	case 27:
+		return math.Float64frombits(arg), nil
+	default:
+		return nil, fmt.Errorf("cbor: unsupported simple value %d", arg)
+	}
+}
+
+func float16(bits uint16) float64 {
+	exp := int(bits>>10) & 0x1f
+	mant := float64(bits & 0x3ff)
+
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 0x1f:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if bits&0x8000 != 0 {
+		f = -f
+	}
+	return f
+}
+
+// decode reads the next item into v, which must be settable.
+func (d *cborDecoder) decode(v reflect.Value, depth int) error {
+	if depth > maxCBORDepth {
+		return errCBORDepth
+	}
+	b, err := d.peek()
+	if err != nil {
+		return err
+	}
+	if b == cborNull || b == cborUndefined {
+		d.pos++
+		v.SetZero()
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.Type() == errorType {
+			var msg string
+			if err := d.decode(reflect.ValueOf(&msg).Elem(), depth+1); err != nil {
+				return err
+			}
+			v.Set(reflect.ValueOf(errors.New(msg)))
+			return nil
+		}
+		if v.NumMethod() > 0 {
+			return fmt.Errorf("cbor: cannot decode into %s", v.Type())
+		}
+		x, err := d.decodeAny(depth + 1)
+		if err != nil {
+			return err
+		}
+		if x != nil {
+			v.Set(reflect.ValueOf(x))
+		}
+		return nil
+	case reflect.Pointer:
+		p := reflect.New(v.Type().Elem())
+		if err := d.decode(p.Elem(), depth+1); err != nil {
+			return err
+		}
+		v.Set(p)
+		return nil
+	case reflect.Struct:
+		return d.decodeStruct(v, depth)
+	case reflect.Map:
+		arg, err := d.expect(cborMap)
+		if err != nil {
+			return err
+		}
+		n, err := d.count(arg)
+		if err != nil {
+			return err
+		}
+		m := reflect.MakeMapWithSize(v.Type(), n)
+		for i := 0; i < n; i++ {
+			key := reflect.New(v.Type().Key()).Elem()
+			if err := d.decode(key, depth+1); err != nil {
+				return err
+			}
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := d.decode(elem, depth+1); err != nil {
+				return err
+			}
+			m.SetMapIndex(key, elem)
+		}
+		v.Set(m)
+		return nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 && b&0xe0 == cborBytes {
+			arg, err := d.expect(cborBytes)
+			if err != nil {
+				return err
+			}
+			data, err := d.bytes(arg)
+			if err != nil {
+				return err
+			}
+			v.SetBytes(bytes.Clone(data))
+			return nil
+		}
+		arg, err := d.expect(cborArray)
+		if err != nil {
+			return err
+		}
+		n, err := d.count(arg)
+		if err != nil {
+			return err
+		}
+		s := reflect.MakeSlice(v.Type(), n, n)
+		for i := 0; i < n; i++ {
+			if err := d.decode(s.Index(i), depth+1); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+		return nil
+	case reflect.Array:
+		arg, err := d.expect(cborArray)
+		if err != nil {
+			return err
+		}
+		n, err := d.count(arg)
+		if err != nil {
+			return err
+		}
+		v.SetZero()
+		for i := 0; i < n; i++ {
+			if i >= v.Len() {
+				if _, err := d.decodeAny(depth + 1); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := d.decode(v.Index(i), depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	x, err := d.decodeAny(depth + 1)
+	if err != nil {
+		return err
+	}
+	return setScalar(v, x)
+}
+
+func (d *cborDecoder) decodeStruct(v reflect.Value, depth int) error {
+	fields := structFields(v.Type())
+	major, _, arg, err := d.head()
+	if err != nil {
+		return err
+	}
+	n, err := d.count(arg)
+	if err != nil {
+		return err
+	}
+	v.SetZero()
+
+	switch major {
+	case cborArray:
+		for i := 0; i < n; i++ {
+			if i >= len(fields) {
+				if _, err := d.decodeAny(depth + 1); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := d.decode(v.Field(fields[i].index), depth+1); err != nil {
+				return fmt.Errorf("%s: %w", fields[i].name, err)
+			}
+		}
+	case cborMap:
+		byName := make(map[string]field, len(fields))
+		for _, f := range fields {
+			byName[f.name] = f
+		}
+		for i := 0; i < n; i++ {
+			key, err := d.decodeAny(depth + 1)
+			if err != nil {
+				return err
+			}
+			name, _ := key.(string)
+			f, ok := byName[name]
+			if !ok {
+				if _, err := d.decodeAny(depth + 1); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := d.decode(v.Field(f.index), depth+1); err != nil {
+				return fmt.Errorf("%s: %w", f.name, err)
+			}
+		}
+	default:
+		return fmt.Errorf("cbor: cannot decode major type %d into %s", major>>5, v.Type())
+	}
+	return nil
+}
+
+// setScalar stores a decoded number, string or boolean in v, converting
+// between numeric types when no precision is lost.
+func setScalar(v reflect.Value, x any) error {
+	mismatch := func() error {
+		return fmt.Errorf("cbor: cannot decode %T into %s", x, v.Type())
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		b, ok := x.(bool)
+		if !ok {
+			return mismatch()
+		}
+		v.SetBool(b)
+	case reflect.String:
+		s, ok := x.(string)
+		if !ok {
+			return mismatch()
+		}
+		v.SetString(s)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		switch x := x.(type) {
+		case int64:
+			n = x
+		case float64:
+			if x != math.Trunc(x) || x < math.MinInt64 || x >= math.MaxInt64 {
+				return mismatch()
+			}
+			n = int64(x)
+		default:
+			return mismatch()
+		}
+		if v.OverflowInt(n) {
+			return fmt.Errorf("cbor: %d overflows %s", n, v.Type())
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var n uint64
+		switch x := x.(type) {
+		case int64:
+			if x < 0 {
+				return mismatch()
+			}
+			n = uint64(x)
+		case uint64:
+			n = x
+		case float64:
+			if x != math.Trunc(x) || x < 0 || x >= math.MaxUint64 {
+				return mismatch()
+			}
+			n = uint64(x)
+		default:
+			return mismatch()
+		}
+		if v.OverflowUint(n) {
+			return fmt.Errorf("cbor: %d overflows %s", n, v.Type())
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		switch x := x.(type) {
+		case float64:
+			v.SetFloat(x)
+		case int64:
+			v.SetFloat(float64(x))
+		case uint64:
+			v.SetFloat(float64(x))
+		default:
+			return mismatch()
+		}
+	default:
+		return fmt.Errorf("cbor: cannot decode into %s", v.Type())
+	}
+	return nil
+}
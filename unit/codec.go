@@ -0,0 +1,320 @@
+package unit
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrUnknownType  = errors.New("unregistered message type")
+	ErrUnknownCodec = errors.New("unknown codec")
+)
+
+// Codec turns messages into bytes and back, so that they can be stored
+// or sent to another process. Only messages of registered types can be
+// encoded; see RegisterMessage.
+type Codec interface {
+	Name() string
+	Marshal(msg any) ([]byte, error)
+	Unmarshal(data []byte) (any, error)
+}
+
+var (
+	// JSON encodes messages as {"type": name, "value": ...} objects.
+	JSON Codec = jsonCodec{}
+	// CBOR encodes messages as a two element CBOR array of the type name
+	// and the value, with structs as maps keyed by their JSON names.
+	CBOR Codec = cborCodec{}
+	// Binary is a compact encoding for processes sharing the same
+	// message types: a 4 byte type ID followed by the value in CBOR, with
+	// struct fields in declaration order instead of by name.
+	Binary Codec = binaryCodec{}
+)
+
+var codecs = struct {
+	sync.RWMutex
+	byName map[string]Codec
+}{byName: map[string]Codec{}}
+
+// RegisterCodec makes c available through CodecFor.
+func RegisterCodec(c Codec) {
+	codecs.Lock()
+	defer codecs.Unlock()
+	codecs.byName[c.Name()] = c
+}
+
+// CodecFor returns the codec registered under name.
+func CodecFor(name string) (Codec, error) {
+	codecs.RLock()
+	defer codecs.RUnlock()
+
+	c, ok := codecs.byName[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownCodec, name)
+	}
+	return c, nil
+}
+
+// Codecs lists the registered codecs by name.
+func Codecs() []Codec {
+	codecs.RLock()
+	defer codecs.RUnlock()
+
+	list := make([]Codec, 0, len(codecs.byName))
+	for _, c := range codecs.byName {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
+	return list
+}
+
+type messageType struct {
+	name string
+	id   uint32
+	// typ is nil for the nil message.
+	typ reflect.Type
+}
+
+var messageTypes = struct {
+	sync.RWMutex
+	byName map[string]*messageType
+	byType map[reflect.Type]*messageType
+	byID   map[uint32]*messageType
+}{
+	byName: map[string]*messageType{},
+	byType: map[reflect.Type]*messageType{},
+	byID:   map[uint32]*messageType{},
+}
+
+var nilMessage = &messageType{name: "nil", id: typeID("nil")}
+
+func init() {
+	RegisterCodec(JSON)
+	RegisterCodec(CBOR)
+	RegisterCodec(Binary)
+
+	messageTypes.byName[nilMessage.name] = nilMessage
+	messageTypes.byID[nilMessage.id] = nilMessage
+
+	RegisterMessage[string]("string")
+	RegisterMessage[bool]("bool")
+	RegisterMessage[int]("int")
+	RegisterMessage[int64]("int64")
+	RegisterMessage[uint64]("uint64")
+	RegisterMessage[float64]("float64")
+	RegisterMessage[[]byte]("bytes")
+	RegisterMessage[[]any]("list")
+	RegisterMessage[[]string]("strings")
+	RegisterMessage[map[string]any]("map")
+	RegisterMessage[map[string]string]("map.strings")
+	RegisterMessage[Publication]("unit.Publication")
+	RegisterMessage[Terminated]("unit.Terminated")
+	RegisterMessage[MemoryBreach]("unit.MemoryBreach")
+}
+
+// RegisterMessage lets messages of type T be encoded by codecs under
+// name, which identifies the type to the decoding side. Names are
+// conventionally the package-qualified type name, such as
+// "control.Instruction". Registering a name twice for different types
+// panics.
+func RegisterMessage[T any](name string) {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	mt := &messageType{name: name, id: typeID(name), typ: typ}
+
+	messageTypes.Lock()
+	defer messageTypes.Unlock()
+
+	if existing, ok := messageTypes.byName[name]; ok {
+		if existing.typ != typ {
+			panic(fmt.Sprintf("unit: message name %q registered for both %v and %v", name, existing.typ, typ))
+		}
+		return
+	}
+	if existing, ok := messageTypes.byID[mt.id]; ok {
+		panic(fmt.Sprintf("unit: message names %q and %q have the same ID", existing.name, name))
+	}
+	messageTypes.byName[name] = mt
+	messageTypes.byID[mt.id] = mt
+	if _, ok := messageTypes.byType[typ]; !ok {
+		messageTypes.byType[typ] = mt
+	}
+}
+
+// MessageName returns the name msg's type was registered under.
+func MessageName(msg any) (string, bool) {
+	mt, err := typeOf(msg)
+	if err != nil {
+		return "", false
+	}
+	return mt.name, true
+}
+
+// typeOf finds the registered type of msg. Pointers to registered types
+// are encoded as the value they point to.
+func typeOf(msg any) (*messageType, error) {
+	if msg == nil {
+		return nilMessage, nil
+	}
+
+	messageTypes.RLock()
+	defer messageTypes.RUnlock()
+
+	typ := reflect.TypeOf(msg)
+	if mt, ok := messageTypes.byType[typ]; ok {
+		return mt, nil
+	}
+	if typ.Kind() == reflect.Pointer {
+		if mt, ok := messageTypes.byType[typ.Elem()]; ok {
+			return mt, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %T", ErrUnknownType, msg)
+}
+
+func typeNamed(name string) (*messageType, error) {
+	messageTypes.RLock()
+	defer messageTypes.RUnlock()
+
+	mt, ok := messageTypes.byName[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownType, name)
+	}
+	return mt, nil
+}
+
+func typeWithID(id uint32) (*messageType, error) {
+	messageTypes.RLock()
+	defer messageTypes.RUnlock()
+
+	mt, ok := messageTypes.byID[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: id %08x", ErrUnknownType, id)
+	}
+	return mt, nil
+}
+
+func typeID(name string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return h.Sum32()
+}
+
+type jsonCodec struct{}
+
+type jsonMessage struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+func (jsonCodec) Name() string { return "json" }
+
+func (jsonCodec) Marshal(msg any) ([]byte, error) {
+	mt, err := typeOf(msg)
+	if err != nil {
+		return nil, err
+	}
+	value, err := json.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("json: %s: %w", mt.name, err)
+	}
+	return json.Marshal(jsonMessage{Type: mt.name, Value: value})
+}
+
+func (jsonCodec) Unmarshal(data []byte) (any, error) {
+	var m jsonMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("json: %w", err)
+	}
+	mt, err := typeNamed(m.Type)
+	if err != nil {
+		return nil, err
+	}
+	if mt.typ == nil {
+		return nil, nil
+	}
+
+	v := reflect.New(mt.typ)
+	if err := json.Unmarshal(m.Value, v.Interface()); err != nil {
+		return nil, fmt.Errorf("json: %s: %w", mt.name, err)
+	}
+	return v.Elem().Interface(), nil
+}
+
+type cborCodec struct{}
+
+func (cborCodec) Name() string { return "cbor" }
+
+func (cborCodec) Marshal(msg any) ([]byte, error) {
+	mt, err := typeOf(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	var e cborEncoder
+	e.head(cborArray, 2)
+	e.text(mt.name)
+	if err := e.encode(reflect.ValueOf(msg), 0); err != nil {
+		return nil, fmt.Errorf("%s: %w", mt.name, err)
+	}
+	return e.buf, nil
+}
+
+func (cborCodec) Unmarshal(data []byte) (any, error) {
+	d := cborDecoder{data: data}
+	major, _, n, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	if major != cborArray || n != 2 {
+		return nil, errors.New("cbor: message is not a [type, value] array")
+	}
+	name, err := d.decodeAny(0)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := name.(string)
+	if !ok {
+		return nil, fmt.Errorf("cbor: message type is %T, not a string", name)
+	}
+	mt, err := typeNamed(s)
+	if err != nil {
+		return nil, err
+	}
+	return d.message(mt)
+}
+
+type binaryCodec struct{}
+
+func (binaryCodec) Name() string { return "binary" }
+
+func (binaryCodec) Marshal(msg any) ([]byte, error) {
+	mt, err := typeOf(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	e := cborEncoder{positional: true}
+	e.buf = binary.BigEndian.AppendUint32(e.buf, mt.id)
+	if err := e.encode(reflect.ValueOf(msg), 0); err != nil {
+		return nil, fmt.Errorf("%s: %w", mt.name, err)
+	}
+	return e.buf, nil
+}
+
+func (binaryCodec) Unmarshal(data []byte) (any, error) {
+	if len(data) < 4 {
+		return nil, errCBORTruncated
+	}
+	mt, err := typeWithID(binary.BigEndian.Uint32(data))
+	if err != nil {
+		return nil, err
+	}
+	d := cborDecoder{data: data, pos: 4}
+	return d.message(mt)
+}
@@ -0,0 +1,183 @@
+package unit
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type codecPoint struct {
+	X     int64   `json:"x"`
+	Y     float64 `json:"y"`
+	Label string  `json:"label,omitempty"`
+	Tags  []string
+	Err   error
+	Next  *codecPoint
+}
+
+func init() {
+	RegisterMessage[codecPoint]("unit.codecPoint")
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	messages := []any{
+		nil,
+		"hello",
+		true,
+		42,
+		int64(-7),
+		uint64(1 << 40),
+		3.5,
+		[]byte{0, 1, 2},
+		[]any{"a", 1.5, false, nil},
+		[]string{"x", "y"},
+		map[string]any{"action": "add", "args": []any{1.5, 2.5}, "nested": map[string]any{"ok": true}},
+		map[string]string{"k": "v"},
+		Publication{Topic: "a.b", Payload: map[string]any{"n": 1.0}},
+		MemoryBreach{Unit: "cache", Usage: 10, Limit: 5, Action: MemoryEvict, Target: "cache"},
+		codecPoint{X: -3, Y: 0.25, Tags: []string{"t"}, Next: &codecPoint{X: 1}},
+	}
+
+	for _, codec := range Codecs() {
+		for _, msg := range messages {
+			data, err := codec.Marshal(msg)
+			if err != nil {
+				t.Errorf("%s: Marshal(%#v) failed: %v", codec.Name(), msg, err)
+				continue
+			}
+			got, err := codec.Unmarshal(data)
+			if err != nil {
+				t.Errorf("%s: Unmarshal(%#v) failed: %v", codec.Name(), msg, err)
+				continue
+			}
+			if !reflect.DeepEqual(got, msg) {
+				t.Errorf("%s: round trip of %#v gave %#v", codec.Name(), msg, got)
+			}
+		}
+	}
+}
+
+func TestCodecErrors(t *testing.T) {
+	for _, codec := range Codecs() {
+		in := Terminated{Unit: "worker", Reason: ErrUnitStopped}
+		data, err := codec.Marshal(in)
+		if err != nil {
+			t.Fatalf("%s: Marshal failed: %v", codec.Name(), err)
+		}
+		got, err := codec.Unmarshal(data)
+		if err != nil {
+			t.Fatalf("%s: Unmarshal failed: %v", codec.Name(), err)
+		}
+		out := got.(Terminated)
+		if out.Unit != "worker" || out.Reason == nil || out.Reason.Error() != ErrUnitStopped.Error() {
+			t.Errorf("%s: round trip of %v gave %v", codec.Name(), in, out)
+		}
+
+		type unregistered struct{}
+		if _, err := codec.Marshal(unregistered{}); !errors.Is(err, ErrUnknownType) {
+			t.Errorf("%s: expected ErrUnknownType, got %v", codec.Name(), err)
+		}
+		if len(data) > 1 {
+			if _, err := codec.Unmarshal(data[:len(data)-1]); err == nil {
+				t.Errorf("%s: expected truncated data to fail", codec.Name())
+			}
+		}
+	}
+
+	if _, err := CodecFor("xml"); !errors.Is(err, ErrUnknownCodec) {
+		t.Errorf("Expected ErrUnknownCodec, got %v", err)
+	}
+}
+
+func TestCBOREncoding(t *testing.T) {
+	// Examples from RFC 8949, appendix A.
+	cases := []struct {
+		value any
+		hex   string
+	}{
+		{0, "00"},
+		{23, "17"},
+		{24, "1818"},
+		{1000, "1903e8"},
+		{1000000, "1a000f4240"},
+		{uint64(1000000000000), "1b000000e8d4a51000"},
+		{-1, "20"},
+		{-1000, "3903e7"},
+		{1.1, "fb3ff199999999999a"},
+		{false, "f4"},
+		{nil, "f6"},
+		{"IETF", "6449455446"},
+		{[]byte{1, 2, 3, 4}, "4401020304"},
+		{[]int{1, 2, 3}, "83010203"},
+		{map[string]int{"a": 1, "b": 2}, "a2616101616202"},
+	}
+	for _, c := range cases {
+		var e cborEncoder
+		if err := e.encode(reflect.ValueOf(c.value), 0); err != nil {
+			t.Errorf("encode(%v) failed: %v", c.value, err)
+			continue
+		}
+		if got := hex.EncodeToString(e.buf); got != c.hex {
+			t.Errorf("encode(%v) = %s, want %s", c.value, got, c.hex)
+		}
+	}
+}
+
+func TestCBORDecoding(t *testing.T) {
+	cases := []struct {
+		hex   string
+		value any
+	}{
+		{"f93c00", 1.0},
+		{"f9c400", -4.0},
+		{"f97bff", 65504.0},
+		{"fa47c35000", 100000.0},
+		{"c074323031332d30332d32315432303a30343a30305a", "2013-03-21T20:04:00Z"},
+		{"a201020304", map[string]any{"1": int64(2), "3": int64(4)}},
+		{"3bffffffffffffffff", nil},
+		{"9f01ff", nil},
+	}
+	for _, c := range cases {
+		data, _ := hex.DecodeString(c.hex)
+		d := cborDecoder{data: data}
+		got, err := d.decodeAny(0)
+		if c.value == nil {
+			if err == nil {
+				t.Errorf("decodeAny(%s) = %v, want an error", c.hex, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, c.value) {
+			t.Errorf("decodeAny(%s) = %#v, %v; want %#v", c.hex, got, err, c.value)
+		}
+	}
+}
+
+func TestBinaryIsPositional(t *testing.T) {
+	msg := codecPoint{X: 1, Y: 2, Label: "origin"}
+	compact, err := Binary.Marshal(msg)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	named, err := CBOR.Marshal(msg)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if bytes.Contains(compact, []byte("label")) || !bytes.Contains(named, []byte("label")) {
+		t.Errorf("Expected field names only in the CBOR encoding")
+	}
+	if len(compact) >= len(named) {
+		t.Errorf("Expected binary (%d bytes) to be smaller than CBOR (%d bytes)", len(compact), len(named))
+	}
+}
+
+func TestCBORRejectsHugeLengths(t *testing.T) {
+	// An array claiming 2^32 items must fail before allocating them.
+	data, _ := hex.DecodeString("9b0000000100000000")
+	d := cborDecoder{data: data}
+	if _, err := d.decodeAny(0); !errors.Is(err, errCBORTruncated) {
+		t.Errorf("Expected errCBORTruncated, got %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package unit
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrMissingDependency = errors.New("missing dependency")
+
+// Dependent is implemented by units that must not be initialized before
+// the named units.
+type Dependent interface {
+	Dependencies() []string
+}
+
+// DependsOn declares that the unit must be initialized after the named
+// units, in addition to any it declares through Dependent.
+func DependsOn(names ...string) Option {
+	return func(c *unitConfig) {
+		c.dependsOn = append(c.dependsOn, names...)
+	}
+}
+
+// CycleError is returned by Start when unit dependencies form a cycle.
+type CycleError struct {
+	Cycle []string
+}
+
+func (e *CycleError) Error() string {
+	return "dependency cycle: " + strings.Join(e.Cycle, " -> ")
+}
+
+func dependenciesOf(unit Unit, ref *unitRef) []string {
+	deps := append([]string(nil), ref.dependsOn...)
+	if d, ok := unit.(Dependent); ok {
+		deps = append(deps, d.Dependencies()...)
+	}
+	return deps
+}
+
+// startOrder sorts names so every unit comes after its dependencies. Units
+// with no ordering constraint between them keep their registration order.
+func startOrder(names []string, deps map[string][]string) ([]string, error) {
+	known := make(map[string]bool, len(names))
+	for _, name := range names {
+		known[name] = true
+	}
+	for _, name := range names {
+		for _, dep := range deps[name] {
+			if !known[dep] {
+				return nil, fmt.Errorf("%w: %s depends on %s", ErrMissingDependency, name, dep)
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(names))
+	order := make([]string, 0, len(names))
+	var path []string
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			for i, n := range path {
+				if n == name {
+					cycle := append(append([]string(nil), path[i:]...), name)
+					return &CycleError{Cycle: cycle}
+				}
+			}
+		}
+
+		state[name] = visiting
+		path = append(path, name)
+		for _, dep := range deps[name] {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+		order = append(order, name)
+		return nil
+	}
+
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
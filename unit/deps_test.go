@@ -0,0 +1,239 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+type initRecorder struct {
+	name  string
+	deps  []string
+	mu    *sync.Mutex
+	order *[]string
+}
+
+func (i *initRecorder) Init(ctx Ctx) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	*i.order = append(*i.order, i.name)
+}
+
+func (i *initRecorder) Handle(ctx Ctx, from UnitRef, message any) error {
+	return nil
+}
+
+func (i *initRecorder) Terminate(ctx Ctx, reason error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	*i.order = append(*i.order, i.name)
+}
+
+func (i *initRecorder) Dependencies() []string {
+	return i.deps
+}
+
+func TestStartOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		names   []string
+		deps    map[string][]string
+		want    []string
+		wantErr error
+	}{
+		{
+			name:  "no dependencies keeps registration order",
+			names: []string{"a", "b", "c"},
+			want:  []string{"a", "b", "c"},
+		},
+		{
+			name:  "dependencies first",
+			names: []string{"executor", "storage", "registers"},
+			deps:  map[string][]string{"executor": {"storage", "registers"}},
+			want:  []string{"storage", "registers", "executor"},
+		},
+		{
+			name:  "transitive",
+			names: []string{"a", "b", "c"},
+			deps:  map[string][]string{"a": {"b"}, "b": {"c"}},
+			want:  []string{"c", "b", "a"},
+		},
+		{
+			name:    "missing",
+			names:   []string{"a"},
+			deps:    map[string][]string{"a": {"b"}},
+			wantErr: ErrMissingDependency,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := startOrder(tt.names, tt.deps)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("startOrder() error = %v, want %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("startOrder() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("startOrder() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestStartOrderCycle(t *testing.T) {
+	_, err := startOrder([]string{"a", "b", "c"}, map[string][]string{
+		"a": {"b"},
+		"b": {"c"},
+		"c": {"a"},
+	})
+
+	var cycleErr *CycleError
+	if !errors.As(err, &cycleErr) {
+		t.Fatalf("Expected CycleError, got %v", err)
+	}
+	if err.Error() != "dependency cycle: a -> b -> c -> a" {
+		t.Errorf("Unexpected cycle message: %v", err)
+	}
+}
+
+func TestStartInitializesDependenciesFirst(t *testing.T) {
+	registry := NewRegistry()
+
+	var mu sync.Mutex
+	var order []string
+	registry.Register("executor", &initRecorder{name: "executor", mu: &mu, order: &order}, DependsOn("storage"))
+	registry.Register("storage", &initRecorder{name: "storage", mu: &mu, order: &order})
+	registry.Register("planner", &initRecorder{name: "planner", deps: []string{"executor"}, mu: &mu, order: &order})
+
+	if err := registry.Start(); err != nil {
+		t.Fatalf("Failed to start registry: %v", err)
+	}
+
+	want := []string{"storage", "executor", "planner"}
+	for i := range want {
+		if i >= len(order) || order[i] != want[i] {
+			t.Fatalf("Expected init order %v, got %v", want, order)
+		}
+	}
+
+	mu.Lock()
+	order = nil
+	mu.Unlock()
+	if err := registry.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	wantStop := []string{"planner", "executor", "storage"}
+	for i := range wantStop {
+		if i >= len(order) || order[i] != wantStop[i] {
+			t.Fatalf("Expected stop order %v, got %v", wantStop, order)
+		}
+	}
+}
+
+// brokenUnit panics in Init.
+type brokenUnit struct{ silentUnit }
+
+func (b *brokenUnit) Init(ctx Ctx) {
+	panic("cannot initialize")
+}
+
+func TestStartStopsStartedUnitsWhenInitFails(t *testing.T) {
+	registry := NewRegistry()
+
+	var mu sync.Mutex
+	var order []string
+	registry.Register("storage", &initRecorder{name: "storage", mu: &mu, order: &order})
+	registry.Register("executor", &initRecorder{name: "executor", mu: &mu, order: &order}, DependsOn("storage"))
+	registry.Register("broken", &brokenUnit{}, DependsOn("executor"))
+
+	var panicErr *PanicError
+	if err := registry.Start(); !errors.As(err, &panicErr) {
+		t.Fatalf("Expected the Init panic, got %v", err)
+	}
+
+	want := []string{"storage", "executor", "executor", "storage"}
+	if len(order) != len(want) {
+		t.Fatalf("Expected init and stop order %v, got %v", want, order)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("Expected init and stop order %v, got %v", want, order)
+		}
+	}
+	if registry.getRef("storage") != nil || registry.getRef("executor") != nil {
+		t.Error("Expected the started units to be removed")
+	}
+}
+
+func TestStartRemovesUnitsNotStartedWhenInitFails(t *testing.T) {
+	registry := NewRegistry()
+
+	var mu sync.Mutex
+	var order []string
+	registry.Register("broken", &brokenUnit{})
+	registry.Register("after", &initRecorder{name: "after", mu: &mu, order: &order}, DependsOn("broken"))
+	after := registry.getRef("after")
+	asked := askVia("", after, "hello", SpanContext{})
+
+	if err := registry.Start(); err == nil {
+		t.Fatal("Expected Start to fail")
+	}
+	if len(order) != 0 {
+		t.Errorf("Expected the later unit never to start, got %v", order)
+	}
+	for _, name := range []string{"broken", "after"} {
+		if registry.getRef(name) != nil {
+			t.Errorf("Expected %s to be removed", name)
+		}
+		if _, err := registry.Ask(context.Background(), name, "hello"); !errors.Is(err, ErrUnknownUnit) {
+			t.Errorf("Expected %s to be unknown, got %v", name, err)
+		}
+	}
+	if err := after.Send("hello"); !errors.Is(err, ErrUnitStopped) {
+		t.Errorf("Expected the removed unit to refuse messages, got %v", err)
+	}
+	if _, err := await(context.Background(), asked); !errors.Is(err, ErrUnitStopped) {
+		t.Errorf("Expected the queued ask to fail, got %v", err)
+	}
+}
+
+func TestSpawnDoesNotRunFailedUnit(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register("spawner", &silentUnit{})
+	if err := registry.Start(); err != nil {
+		t.Fatalf("Failed to start registry: %v", err)
+	}
+	defer registry.Stop()
+
+	ctx := &registryCtx{Context: context.Background(), reg: registry, self: registry.getRef("spawner")}
+	ref := ctx.Spawn("broken", func() Unit { return &brokenUnit{} })
+	if err := ref.Send("hello"); !errors.Is(err, ErrUnitStopped) {
+		t.Errorf("Expected the failed unit to refuse messages, got %v", err)
+	}
+	if registry.getRef("broken") != nil {
+		t.Error("Expected the failed unit to be removed")
+	}
+}
+
+func TestStartRefusesCycle(t *testing.T) {
+	registry := NewRegistry()
+
+	var mu sync.Mutex
+	var order []string
+	registry.Register("a", &initRecorder{name: "a", mu: &mu, order: &order}, DependsOn("b"))
+	registry.Register("b", &initRecorder{name: "b", mu: &mu, order: &order}, DependsOn("a"))
+
+	var cycleErr *CycleError
+	if err := registry.Start(); !errors.As(err, &cycleErr) {
+		t.Fatalf("Expected CycleError, got %v", err)
+	}
+	if len(order) != 0 {
+		t.Errorf("Expected no unit to be initialized, got %v", order)
+	}
+}
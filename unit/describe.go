@@ -0,0 +1,192 @@
+package unit
+
+import (
+	"maps"
+	"reflect"
+	"strings"
+)
+
+// Schema is a JSON Schema document describing a message or reply.
+type Schema map[string]any
+
+// Describer is implemented by units that publish the messages they accept.
+type Describer interface {
+	Describe() Description
+}
+
+// Description documents what a unit does and the actions it accepts.
+type Description struct {
+	Summary string
+	Actions []ActionDesc
+}
+
+// ActionDesc documents one action: the schema of its arguments when sent
+// as a map with an "action" field, and the schema of its reply.
+type ActionDesc struct {
+	Name    string
+	Summary string
+	Args    Schema
+	Reply   Schema
+}
+
+// Action looks up an action by name.
+func (d Description) Action(name string) (ActionDesc, bool) {
+	for _, a := range d.Actions {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return ActionDesc{}, false
+}
+
+// Prop describes a scalar value of the given JSON type.
+func Prop(typ, description string) Schema {
+	s := Schema{"type": typ}
+	if description != "" {
+		s["description"] = description
+	}
+	return s
+}
+
+// ArrayOf describes a list of items.
+func ArrayOf(items Schema, description string) Schema {
+	s := Schema{"type": "array", "items": items}
+	if description != "" {
+		s["description"] = description
+	}
+	return s
+}
+
+// Object describes an object with the given properties.
+func Object(props map[string]Schema, required ...string) Schema {
+	properties := make(map[string]any, len(props))
+	for name, p := range props {
+		properties[name] = p
+	}
+	s := Schema{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+// Any describes a value of any type.
+func Any(description string) Schema {
+	s := Schema{}
+	if description != "" {
+		s["description"] = description
+	}
+	return s
+}
+
+// SchemaOf derives a schema from T. Struct fields are named by their json
+// tag and are required unless tagged omitempty; a desc tag documents the
+// field. Structs that contain themselves refer back to a definition under
+// "$defs".
+func SchemaOf[T any]() Schema {
+	b := schemaBuilder{visiting: make(map[reflect.Type]bool)}
+	s := b.schemaOf(reflect.TypeOf((*T)(nil)).Elem())
+	if len(b.defs) > 0 {
+		s["$defs"] = b.defs
+	}
+	return s
+}
+
+// schemaBuilder derives schemas, keeping definitions of the struct types
+// that recur within themselves.
+type schemaBuilder struct {
+	// visiting holds the struct types being derived, and whether they
+	// turned out to recur.
+	visiting map[reflect.Type]bool
+	defs     map[string]any
+}
+
+func (b *schemaBuilder) schemaOf(t reflect.Type) Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return Prop("boolean", "")
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Prop("integer", "")
+	case reflect.Float32, reflect.Float64:
+		return Prop("number", "")
+	case reflect.String:
+		return Prop("string", "")
+	case reflect.Slice, reflect.Array:
+		return ArrayOf(b.schemaOf(t.Elem()), "")
+	case reflect.Map:
+		return Prop("object", "")
+	case reflect.Struct:
+		if _, ok := b.visiting[t]; ok {
+			b.visiting[t] = true
+			return Schema{"$ref": "#/$defs/" + defName(t)}
+		}
+		b.visiting[t] = false
+		props := make(map[string]Schema)
+		var required []string
+		for _, f := range structFields(t) {
+			s := b.schemaOf(f.typ)
+			if f.desc != "" {
+				s["description"] = f.desc
+			}
+			props[f.name] = s
+			if f.required {
+				required = append(required, f.name)
+			}
+		}
+		s := Object(props, required...)
+		if b.visiting[t] {
+			if b.defs == nil {
+				b.defs = make(map[string]any)
+			}
+			b.defs[defName(t)] = maps.Clone(s)
+		}
+		delete(b.visiting, t)
+		return s
+	default:
+		return Any("")
+	}
+}
+
+// defName names t's definition, escaped for use in a JSON pointer.
+func defName(t reflect.Type) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(t.String())
+}
+
+type field struct {
+	index    int
+	name     string
+	desc     string
+	typ      reflect.Type
+	required bool
+}
+
+// structFields lists the exported fields of t as encoding/json sees them.
+func structFields(t reflect.Type) []field {
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, field{
+			index:    i,
+			name:     name,
+			desc:     f.Tag.Get("desc"),
+			typ:      f.Type,
+			required: !strings.Contains(opts, "omitempty"),
+		})
+	}
+	return fields
+}
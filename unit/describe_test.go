@@ -0,0 +1,86 @@
+package unit
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+type describedUnit struct {
+	silentUnit
+}
+
+func (d *describedUnit) Describe() Description {
+	return Description{
+		Summary: "Adds numbers",
+		Actions: []ActionDesc{
+			{
+				Name:    "add",
+				Summary: "Add two numbers",
+				Args: Object(map[string]Schema{
+					"a": Prop("number", "first operand"),
+					"b": Prop("number", "second operand"),
+				}, "a", "b"),
+				Reply: Prop("number", "the sum"),
+			},
+		},
+	}
+}
+
+func TestUnitsIncludeDescriptions(t *testing.T) {
+	registry := startRegistry(t, nil)
+	registry.Register("plain", &silentUnit{})
+	registry.Register("adder", &describedUnit{})
+
+	ctx := &registryCtx{reg: registry, self: registry.getRef("plain")}
+	units := ctx.Units()
+	if len(units) != 2 {
+		t.Fatalf("Expected 2 units, got %d", len(units))
+	}
+	if units[0].Name != "plain" || units[0].Description != nil {
+		t.Errorf("Expected plain unit without description first, got %+v", units[0])
+	}
+
+	desc := units[1].Description
+	if desc == nil || desc.Summary != "Adds numbers" {
+		t.Fatalf("Expected adder description, got %+v", desc)
+	}
+	action, ok := desc.Action("add")
+	if !ok {
+		t.Fatal("Expected to find the add action")
+	}
+	if _, ok := desc.Action("sub"); ok {
+		t.Error("Expected unknown action lookup to fail")
+	}
+
+	schema, err := json.Marshal(action.Args)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	want := `{"properties":{"a":{"description":"first operand","type":"number"},"b":{"description":"second operand","type":"number"}},"required":["a","b"],"type":"object"}`
+	if string(schema) != want {
+		t.Errorf("Unexpected schema:\n got %s\nwant %s", schema, want)
+	}
+}
+
+type treeNode struct {
+	Value    int         `json:"value"`
+	Children []*treeNode `json:"children,omitempty"`
+	Parent   *treeNode   `json:"parent,omitempty"`
+}
+
+type tree struct {
+	Root treeNode `json:"root"`
+	Size int      `json:"size"`
+}
+
+func TestSchemaOfRecursiveStruct(t *testing.T) {
+	schema, err := json.Marshal(SchemaOf[tree]())
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	node := `{"properties":{"children":{"items":{"$ref":"#/$defs/unit.treeNode"},"type":"array"},"parent":{"$ref":"#/$defs/unit.treeNode"},"value":{"type":"integer"}},"required":["value"],"type":"object"}`
+	want := `{"$defs":{"unit.treeNode":` + node + `},"properties":{"root":` + node + `,"size":{"type":"integer"}},"required":["root","size"],"type":"object"}`
+	if string(schema) != want {
+		t.Errorf("Expected schema\n%s\ngot\n%s", want, schema)
+	}
+}
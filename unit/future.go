@@ -0,0 +1,165 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultAskTimeout bounds Ctx.Ask when the context carries no deadline.
+const DefaultAskTimeout = 30 * time.Second
+
+var ErrNoReply = errors.New("no reply")
+
+var nextCorrelationID atomic.Uint64
+
+// Future holds the eventual reply to a request made with Ctx.AskAsync.
+type Future struct {
+	id    uint64
+	done  chan struct{}
+	once  sync.Once
+	value any
+	err   error
+}
+
+func newFuture() *Future {
+	return &Future{
+		id:   nextCorrelationID.Add(1),
+		done: make(chan struct{}),
+	}
+}
+
+// Resolved returns a future that is already complete.
+func Resolved(value any, err error) *Future {
+	f := newFuture()
+	f.complete(value, err)
+	return f
+}
+
+// ID is the correlation ID tying the request to its reply.
+func (f *Future) ID() uint64 {
+	return f.id
+}
+
+// Done is closed once the reply, or an error, is available.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Result blocks until the future completes.
+func (f *Future) Result() (any, error) {
+	<-f.done
+	return f.value, f.err
+}
+
+// Await waits for the reply until ctx is done.
+func (f *Future) Await(ctx context.Context) (any, error) {
+	select {
+	case <-f.done:
+		return f.value, f.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%w: request %d: %w", ErrNoReply, f.id, ctx.Err())
+	}
+}
+
+func (f *Future) complete(value any, err error) bool {
+	completed := false
+	f.once.Do(func() {
+		f.value = value
+		f.err = err
+		completed = true
+		close(f.done)
+	})
+	return completed
+}
+
+// promiseRef is the sender seen by a unit handling an Ask. The first
+// message sent on it completes the future; later ones are dropped.
+type promiseRef struct {
+	name   string
+	future *Future
+}
+
+func (p *promiseRef) Name() string {
+	return p.name
+}
+
+func (p *promiseRef) Send(msg any) error {
+	if !p.future.complete(msg, nil) {
+		return fmt.Errorf("request %d already answered", p.future.id)
+	}
+	return nil
+}
+
+func (p *promiseRef) Stop() {
+	p.future.complete(nil, ErrNoReply)
+}
+
+// Reject fails the request with err, unless it was already answered.
+func (p *promiseRef) Reject(err error) {
+	p.future.complete(nil, err)
+}
+
+// CorrelationID returns the ID of the request a sender ref answers, if
+// the message being handled was sent with Ask.
+func CorrelationID(from UnitRef) (uint64, bool) {
+	if p, ok := from.(*promiseRef); ok {
+		return p.future.id, true
+	}
+	return 0, false
+}
+
+// NewPromise returns a future together with the sender ref that completes
+// it, for Ctx implementations outside the registry such as test
+// harnesses. The first message sent on the ref is the reply, and
+// rejecting it fails the future.
+func NewPromise(asker string) (*Future, UnitRef) {
+	f := newFuture()
+	return f, &promiseRef{name: asker, future: f}
+}
+
+func askVia(asker string, target *unitRef, msg any, span SpanContext) *Future {
+	f := newFuture()
+	if err := target.deliverTraced(&promiseRef{name: asker, future: f}, msg, span); err != nil {
+		f.complete(nil, err)
+	}
+	return f
+}
+
+// WithTimeout returns a copy of ctx whose Ask gives up with ErrNoReply
+// after d. Ctx implementations outside the registry, such as test
+// harnesses, are returned as they are.
+func WithTimeout(ctx Ctx, d time.Duration) (Ctx, context.CancelFunc) {
+	return withContext(ctx, func(parent context.Context) (context.Context, context.CancelFunc) {
+		return context.WithTimeout(parent, d)
+	})
+}
+
+// WithCancel returns a copy of ctx whose Ask gives up with ErrNoReply once
+// cancel is called, as WithTimeout does after its timeout.
+func WithCancel(ctx Ctx) (Ctx, context.CancelFunc) {
+	return withContext(ctx, context.WithCancel)
+}
+
+func withContext(ctx Ctx, derive func(context.Context) (context.Context, context.CancelFunc)) (Ctx, context.CancelFunc) {
+	c, ok := ctx.(*registryCtx)
+	if !ok {
+		return ctx, func() {}
+	}
+	derived := *c
+	var cancel context.CancelFunc
+	derived.Context, cancel = derive(c.Context)
+	return &derived, cancel
+}
+
+func await(ctx context.Context, f *Future) (any, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultAskTimeout)
+		defer cancel()
+	}
+	return f.Await(ctx)
+}
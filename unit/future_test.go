@@ -0,0 +1,236 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type failingUnit struct{}
+
+func (f *failingUnit) Init(ctx Ctx) {}
+
+func (f *failingUnit) Handle(ctx Ctx, from UnitRef, message any) error {
+	return fmt.Errorf("cannot handle %v", message)
+}
+
+type silentUnit struct{}
+
+func (s *silentUnit) Init(ctx Ctx) {}
+
+func (s *silentUnit) Handle(ctx Ctx, from UnitRef, message any) error {
+	return nil
+}
+
+// chainUnit answers every message by asking the echo unit and replying
+// with what it returned.
+type chainUnit struct{}
+
+func (c *chainUnit) Init(ctx Ctx) {}
+
+func (c *chainUnit) Handle(ctx Ctx, from UnitRef, message any) error {
+	for _, desc := range ctx.Units() {
+		if desc.Name != "echo" {
+			continue
+		}
+		reply, err := ctx.Ask(desc.Ref, message)
+		if err != nil {
+			return err
+		}
+		return from.Send(fmt.Sprintf("chained %v", reply))
+	}
+	return fmt.Errorf("echo not found")
+}
+
+// ctxEchoUnit replies through its Ctx rather than on the sender ref.
+type ctxEchoUnit struct{}
+
+func (e *ctxEchoUnit) Init(ctx Ctx) {}
+
+func (e *ctxEchoUnit) Handle(ctx Ctx, from UnitRef, message any) error {
+	return ctx.Send(from, message)
+}
+
+func startRegistry(t *testing.T, units map[string]Unit) *Registry {
+	t.Helper()
+	registry := NewRegistry()
+	for name, unit := range units {
+		registry.Register(name, unit)
+	}
+	if err := registry.Start(); err != nil {
+		t.Fatalf("Failed to start registry: %v", err)
+	}
+	t.Cleanup(registry.Stop)
+	return registry
+}
+
+func TestRegistryAsk(t *testing.T) {
+	registry := startRegistry(t, map[string]Unit{"echo": &echoUnit{}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	reply, err := registry.Ask(ctx, "echo", "hello")
+	if err != nil {
+		t.Fatalf("Ask failed: %v", err)
+	}
+	if reply != "hello" {
+		t.Errorf("Expected 'hello', got %v", reply)
+	}
+}
+
+func TestAskBetweenUnits(t *testing.T) {
+	registry := startRegistry(t, map[string]Unit{
+		"echo":  &echoUnit{},
+		"chain": &chainUnit{},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	reply, err := registry.Ask(ctx, "chain", "hello")
+	if err != nil {
+		t.Fatalf("Ask failed: %v", err)
+	}
+	if reply != "chained hello" {
+		t.Errorf("Expected 'chained hello', got %v", reply)
+	}
+}
+
+func TestAskAnsweredThroughCtx(t *testing.T) {
+	registry := startRegistry(t, map[string]Unit{
+		"echo":  &ctxEchoUnit{},
+		"chain": &chainUnit{},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if reply, err := registry.Ask(ctx, "echo", "hello"); err != nil || reply != "hello" {
+		t.Errorf("Expected 'hello', got %v, %v", reply, err)
+	}
+	if reply, err := registry.Ask(ctx, "chain", "hello"); err != nil || reply != "chained hello" {
+		t.Errorf("Expected 'chained hello', got %v, %v", reply, err)
+	}
+}
+
+func TestAskUnknownUnit(t *testing.T) {
+	registry := startRegistry(t, nil)
+
+	_, err := registry.Ask(context.Background(), "missing", "hello")
+	if !errors.Is(err, ErrUnknownUnit) {
+		t.Errorf("Expected ErrUnknownUnit, got %v", err)
+	}
+}
+
+func TestAskHandlerError(t *testing.T) {
+	registry := startRegistry(t, map[string]Unit{"failing": &failingUnit{}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_, err := registry.Ask(ctx, "failing", "hello")
+	if err == nil || err.Error() != "cannot handle hello" {
+		t.Errorf("Expected handler error, got %v", err)
+	}
+}
+
+func TestAskTimeout(t *testing.T) {
+	registry := startRegistry(t, map[string]Unit{"silent": &silentUnit{}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := registry.Ask(ctx, "silent", "hello")
+	if !errors.Is(err, ErrNoReply) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected ErrNoReply wrapping DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestAskWithTimeout(t *testing.T) {
+	registry := startRegistry(t, map[string]Unit{"silent": &silentUnit{}, "echo": &echoUnit{}})
+	ctx := &registryCtx{Context: registry.ctx, reg: registry, self: registry.getRef("echo")}
+
+	timed, cancel := WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := timed.Ask(registry.getRef("silent"), "hello")
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > time.Second {
+		t.Errorf("Expected the ask to time out, got %v after %v", err, time.Since(start))
+	}
+	if ctx.Err() != nil {
+		t.Error("Expected the original ctx to be unaffected")
+	}
+
+	cancelled, cancel := WithCancel(ctx)
+	cancel()
+	if _, err := cancelled.Ask(registry.getRef("silent"), "hello"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the ask to be cancelled, got %v", err)
+	}
+}
+
+func TestAskAsyncCorrelation(t *testing.T) {
+	registry := startRegistry(t, map[string]Unit{"echo": &echoUnit{}})
+	echo := registry.getRef("echo")
+
+	futures := make([]*Future, 20)
+	for i := range futures {
+		futures[i] = askVia("", echo, i, SpanContext{})
+	}
+
+	seen := make(map[uint64]bool)
+	for i, f := range futures {
+		if seen[f.ID()] {
+			t.Errorf("Duplicate correlation ID %d", f.ID())
+		}
+		seen[f.ID()] = true
+
+		select {
+		case <-f.Done():
+		case <-time.After(time.Second):
+			t.Fatalf("Timeout waiting for future %d", i)
+		}
+		if reply, err := f.Result(); err != nil || reply != i {
+			t.Errorf("Expected reply %d, got %v (err=%v)", i, reply, err)
+		}
+	}
+}
+
+func TestAskFailsWhenDroppedFromMailbox(t *testing.T) {
+	registry := NewRegistry()
+	u := &journalUnit{received: make(chan any, 4), release: make(chan struct{})}
+	registry.Register("slow", u, WithMailboxSize(1), WithOverflowPolicy(OverflowDropOldest))
+	if err := registry.Start(); err != nil {
+		t.Fatalf("Failed to start registry: %v", err)
+	}
+	t.Cleanup(registry.Stop)
+	defer close(u.release)
+
+	// The first message is in hand and the ask waits in the mailbox until
+	// a third message pushes it out.
+	ref := registry.getRef("slow")
+	ref.Send("first")
+	for !ref.handling.Load() {
+		time.Sleep(time.Millisecond)
+	}
+	asked := make(chan error, 1)
+	go func() {
+		_, err := registry.Ask(context.Background(), "slow", "ask")
+		asked <- err
+	}()
+	for ref.mailbox.len() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	ref.Send("third")
+
+	select {
+	case err := <-asked:
+		if !errors.Is(err, ErrMailboxFull) {
+			t.Errorf("Expected ErrMailboxFull, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the dropped ask to fail at once")
+	}
+}
@@ -0,0 +1,481 @@
+package unit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrReplaying = errors.New("registry is replaying a journal")
+
+// SyncPolicy decides which journal entries are synced to stable storage
+// as soon as they are written, for writers with a Sync method such as
+// *os.File.
+type SyncPolicy int
+
+const (
+	// SyncDurable syncs messages for durable units, so that they survive
+	// the machine crashing. Acknowledgements lost that way only lead to
+	// redelivery. This is the default.
+	SyncDurable SyncPolicy = iota
+	// SyncAll syncs every entry.
+	SyncAll
+	// SyncNone leaves writing entries out to the operating system.
+	SyncNone
+)
+
+func (p SyncPolicy) String() string {
+	switch p {
+	case SyncDurable:
+		return "durable"
+	case SyncAll:
+		return "all"
+	case SyncNone:
+		return "none"
+	default:
+		return "unknown"
+	}
+}
+
+// JournalOption configures a Journal.
+type JournalOption func(*Journal)
+
+// WithSyncPolicy sets which entries the journal syncs. The default is
+// SyncDurable.
+func WithSyncPolicy(p SyncPolicy) JournalOption {
+	return func(j *Journal) {
+		j.policy = p
+	}
+}
+
+// WithCompaction compacts a journal opened with OpenJournal each time n
+// more messages have been acknowledged. See Journal.Compact.
+func WithCompaction(n int) JournalOption {
+	return func(j *Journal) {
+		j.compactAfter = n
+	}
+}
+
+// JournalEntry is one line of a journal: either a message delivered to a
+// unit, or the acknowledgement that a durable unit has handled the
+// message with the same Seq.
+type JournalEntry struct {
+	Seq     uint64          `json:"seq"`
+	Ack     bool            `json:"ack,omitempty"`
+	Time    time.Time       `json:"time"`
+	To      string          `json:"to,omitempty"`
+	From    string          `json:"from,omitempty"`
+	Durable bool            `json:"durable,omitempty"`
+	Msg     json.RawMessage `json:"msg,omitempty"`
+}
+
+// Journal is a write-ahead log of the messages delivered through a
+// registry, one JSON line per entry with messages in the JSON codec.
+// Entries are written before a message is queued, so they survive the
+// process crashing. Messages of types that are not registered with
+// RegisterMessage are left out, except that delivering them to a durable
+// unit fails.
+type Journal struct {
+	mu      sync.Mutex
+	w       io.Writer
+	seq     uint64
+	pending map[uint64]JournalEntry
+	skipped map[string]bool
+	closed  bool
+
+	policy SyncPolicy
+	// path is the journal's file, when it was opened with OpenJournal.
+	path         string
+	compactAfter int
+	// acked counts acknowledgements since the journal was last compacted.
+	acked int
+}
+
+// NewJournal starts an empty journal written to w.
+func NewJournal(w io.Writer, opts ...JournalOption) *Journal {
+	j := &Journal{
+		w:       w,
+		pending: make(map[uint64]JournalEntry),
+		skipped: make(map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(j)
+	}
+	return j
+}
+
+// OpenJournal opens the journal at path, creating it if needed. Messages
+// it records as delivered to durable units but never handled are
+// redelivered when a registry using it starts.
+func OpenJournal(path string, opts ...JournalOption) (*Journal, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	// A crash may leave a partly written last line; drop it.
+	complete := bytes.LastIndexByte(data, '\n') + 1
+	if complete < len(data) {
+		if err := f.Truncate(int64(complete)); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if _, err := f.Seek(int64(complete), io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	entries, err := ReadJournal(bytes.NewReader(data[:complete]))
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("journal %s: %w", path, err)
+	}
+
+	j := NewJournal(f, opts...)
+	j.path = path
+	for _, e := range entries {
+		j.seq = max(j.seq, e.Seq)
+		switch {
+		case e.Ack:
+			delete(j.pending, e.Seq)
+		case e.Durable:
+			j.pending[e.Seq] = e
+		}
+	}
+	return j, nil
+}
+
+// ReadJournal reads every entry of a journal.
+func ReadJournal(r io.Reader) ([]JournalEntry, error) {
+	var entries []JournalEntry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var e JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return entries, fmt.Errorf("line %d: %w", line, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// Pending lists the messages delivered to durable units that have not
+// been handled, oldest first.
+func (j *Journal) Pending() []JournalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	pending := make([]JournalEntry, 0, len(j.pending))
+	for _, e := range j.pending {
+		pending = append(pending, e)
+	}
+	sort.Slice(pending, func(a, b int) bool { return pending[a].Seq < pending[b].Seq })
+	return pending
+}
+
+// Close closes the underlying writer if it is an io.Closer. Entries
+// written afterwards are dropped.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.closed = true
+	if c, ok := j.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// record journals msg on its way to the unit to. It returns the entry's
+// sequence number, or 0 when msg was left out.
+func (j *Journal) record(to string, from UnitRef, msg any, durable bool) (uint64, error) {
+	data, err := JSON.Marshal(msg)
+	if err != nil {
+		if durable {
+			return 0, fmt.Errorf("journal message for %s: %w", to, err)
+		}
+		j.skip(msg, err)
+		return 0, nil
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.seq++
+	e := JournalEntry{
+		Seq:     j.seq,
+		Time:    time.Now(),
+		To:      to,
+		From:    from.Name(),
+		Durable: durable,
+		Msg:     data,
+	}
+	if err := j.write(e); err != nil {
+		return 0, fmt.Errorf("journal message for %s: %w", to, err)
+	}
+	if durable {
+		j.pending[e.Seq] = e
+	}
+	return e.Seq, nil
+}
+
+// ack records that the message with seq has been handled.
+func (j *Journal) ack(seq uint64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	delete(j.pending, seq)
+	if err := j.write(JournalEntry{Seq: seq, Ack: true, Time: time.Now()}); err != nil {
+		log.Printf("journal: ack %d: %v", seq, err)
+		return
+	}
+	j.acked++
+	if j.compactAfter > 0 && j.acked >= j.compactAfter && j.path != "" {
+		if err := j.compact(); err != nil {
+			log.Printf("journal: %v", err)
+		}
+	}
+}
+
+// Compact rewrites the journal's file with only the messages still
+// pending, so that it stops growing with every message handled. The rest
+// of its history is lost, so a compacted journal cannot be replayed in
+// full. Only journals opened with OpenJournal can be compacted.
+func (j *Journal) Compact() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.path == "" {
+		return fmt.Errorf("compact journal: %w", errors.ErrUnsupported)
+	}
+	return j.compact()
+}
+
+// compact writes the pending entries to a new file and moves it over the
+// journal's. The caller must hold j.mu.
+func (j *Journal) compact() error {
+	if j.closed {
+		return os.ErrClosed
+	}
+	pending := make([]JournalEntry, 0, len(j.pending)+1)
+	for _, e := range j.pending {
+		pending = append(pending, e)
+	}
+	sort.Slice(pending, func(a, b int) bool { return pending[a].Seq < pending[b].Seq })
+	// Keep the last sequence number, so that numbering carries on when
+	// the journal is opened again.
+	if _, ok := j.pending[j.seq]; !ok && j.seq > 0 {
+		pending = append(pending, JournalEntry{Seq: j.seq, Ack: true, Time: time.Now()})
+	}
+
+	tmp := j.path + ".compact"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("compact journal: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	for _, e := range pending {
+		line, err := json.Marshal(e)
+		if err == nil {
+			_, err = w.Write(append(line, '\n'))
+		}
+		if err != nil {
+			f.Close()
+			os.Remove(tmp)
+			return fmt.Errorf("compact journal: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("compact journal: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("compact journal: %w", err)
+	}
+	if err := os.Rename(tmp, j.path); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("compact journal: %w", err)
+	}
+	syncDir(filepath.Dir(j.path))
+
+	if c, ok := j.w.(io.Closer); ok {
+		c.Close()
+	}
+	j.w = f
+	j.acked = 0
+	return nil
+}
+
+// syncDir syncs a directory so that a rename within it is not lost.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
+
+// write appends e, syncing it if the sync policy asks for it. The caller
+// must hold j.mu.
+func (j *Journal) write(e JournalEntry) error {
+	if j.closed {
+		return os.ErrClosed
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := j.w.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if j.policy == SyncAll || j.policy == SyncDurable && e.Durable {
+		if s, ok := j.w.(interface{ Sync() error }); ok {
+			return s.Sync()
+		}
+	}
+	return nil
+}
+
+// skip logs, once per type, that messages of msg's type are not journaled.
+func (j *Journal) skip(msg any, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	name := fmt.Sprintf("%T", msg)
+	if !j.skipped[name] {
+		j.skipped[name] = true
+		log.Printf("journal: not recording %s messages: %v", name, err)
+	}
+}
+
+// WithJournal records every message delivered to the registry's units in
+// j, and redelivers messages that durable units had not handled when the
+// registry starts.
+func WithJournal(j *Journal) RegistryOption {
+	return func(r *Registry) {
+		r.journal = j
+	}
+}
+
+// Durable guarantees the unit sees every message journaled for it at
+// least once: messages it had not handled when the process stopped are
+// redelivered the next time the registry starts. It has no effect on a
+// registry without a journal.
+func Durable() Option {
+	return func(c *unitConfig) {
+		c.durable = true
+	}
+}
+
+// redeliver queues the journaled messages that durable units had not
+// handled, within the units' limits. Messages for units that no longer
+// exist, or that could not be queued, stay pending.
+func (r *Registry) redeliver() {
+	for _, e := range r.journal.Pending() {
+		ref := r.getRef(e.To)
+		if ref == nil || !ref.durable {
+			continue
+		}
+		msg, err := JSON.Unmarshal(e.Msg)
+		if err != nil {
+			log.Printf("journal: dropping message %d for %s: %v", e.Seq, e.To, err)
+			r.journal.ack(e.Seq)
+			continue
+		}
+
+		from := NoSender
+		if sender := r.getRef(e.From); sender != nil {
+			from = sender.from(ref)
+		}
+		env := envelope{from: from, msg: msg, seq: e.Seq}
+		if ref.limiter != nil {
+			// There is no sender to refuse, so wait for the unit's limits
+			// whatever its policy.
+			release, err := ref.acquire(e.From, true)
+			if err != nil {
+				log.Printf("journal: redelivering message %d to %s: %v", e.Seq, e.To, err)
+				continue
+			}
+			env.release = release
+		}
+		if err := ref.mailbox.push(env); err != nil {
+			env.done()
+			log.Printf("journal: redelivering message %d to %s: %v", e.Seq, e.To, err)
+		}
+	}
+}
+
+// Replay feeds the messages recorded in entries to the registry's units
+// in the order they were journaled, waiting for each to be handled before
+// delivering the next, so that a run can be reproduced. Since the journal
+// already holds the messages units sent each other, those sent while
+// replaying are refused with ErrReplaying and replies are discarded. It
+// returns how many messages were replayed.
+func (r *Registry) Replay(ctx context.Context, entries []JournalEntry) (int, error) {
+	r.replaying.Store(true)
+	defer r.replaying.Store(false)
+
+	replayed := 0
+	for _, e := range entries {
+		if e.Ack {
+			continue
+		}
+		target := r.getRef(e.To)
+		if target == nil {
+			return replayed, fmt.Errorf("replay %d: %w: %s", e.Seq, ErrUnknownUnit, e.To)
+		}
+		msg, err := JSON.Unmarshal(e.Msg)
+		if err != nil {
+			return replayed, fmt.Errorf("replay %d: %w", e.Seq, err)
+		}
+
+		handled := make(chan struct{})
+		env := envelope{from: replayRef{name: e.From}, msg: msg, handled: handled}
+		if err := target.enqueue(env); err != nil {
+			return replayed, fmt.Errorf("replay %d to %s: %w", e.Seq, e.To, err)
+		}
+		select {
+		case <-handled:
+		case <-target.done:
+			return replayed, fmt.Errorf("replay %d: %w: %s", e.Seq, ErrUnitStopped, e.To)
+		case <-ctx.Done():
+			return replayed, ctx.Err()
+		}
+		replayed++
+	}
+	return replayed, nil
+}
+
+// replayRef stands in for the sender of a replayed message.
+type replayRef struct {
+	name string
+}
+
+func (s replayRef) Name() string       { return s.name }
+func (s replayRef) Send(msg any) error { return nil }
+func (s replayRef) Stop()              {}
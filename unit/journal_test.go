@@ -0,0 +1,326 @@
+package unit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// journalUnit hands every message to a channel, optionally after waiting
+// on release, and forwards strings to the unit named forward.
+type journalUnit struct {
+	received chan any
+	release  chan struct{}
+	forward  string
+}
+
+func (u *journalUnit) Init(ctx Ctx) {}
+
+func (u *journalUnit) Handle(ctx Ctx, from UnitRef, message any) error {
+	if u.release != nil {
+		<-u.release
+	}
+	if s, ok := message.(string); ok && u.forward != "" {
+		for _, d := range ctx.Units() {
+			if d.Name == u.forward {
+				if err := ctx.Send(d.Ref, s+"!"); !errors.Is(err, ErrReplaying) && err != nil {
+					return err
+				}
+			}
+		}
+	}
+	u.received <- message
+	return nil
+}
+
+func receive(t *testing.T, ch chan any) any {
+	t.Helper()
+	select {
+	case msg := <-ch:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for a message")
+		return nil
+	}
+}
+
+func TestJournalRedeliversUnhandledMessages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	journal, err := OpenJournal(path)
+	if err != nil {
+		t.Fatalf("OpenJournal failed: %v", err)
+	}
+
+	stuck := &journalUnit{received: make(chan any, 4), release: make(chan struct{})}
+	defer close(stuck.release)
+	first := NewRegistry(WithJournal(journal))
+	first.Register("durable", stuck, Durable())
+	first.Register("plain", &journalUnit{received: make(chan any, 4), release: stuck.release})
+	if err := first.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer first.Stop()
+
+	for _, msg := range []string{"a", "b", "c"} {
+		first.refs["durable"].Send(msg)
+		first.refs["plain"].Send(msg)
+	}
+	type unregistered struct{}
+	if err := first.refs["durable"].Send(unregistered{}); !errors.Is(err, ErrUnknownType) {
+		t.Errorf("Expected ErrUnknownType for an unjournaled message, got %v", err)
+	}
+
+	// Crash: nothing has been handled and the journal stops being written.
+	journal.Close()
+
+	journal, err = OpenJournal(path)
+	if err != nil {
+		t.Fatalf("OpenJournal failed: %v", err)
+	}
+	defer journal.Close()
+	if n := len(journal.Pending()); n != 3 {
+		t.Fatalf("Expected 3 pending messages, got %d", n)
+	}
+
+	recovered := &journalUnit{received: make(chan any, 4)}
+	plain := &journalUnit{received: make(chan any, 4)}
+	second := NewRegistry(WithJournal(journal))
+	second.Register("durable", recovered, Durable())
+	second.Register("plain", plain)
+	if err := second.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer second.Stop()
+
+	for _, want := range []string{"a", "b", "c"} {
+		if got := receive(t, recovered.received); got != want {
+			t.Errorf("Expected %q redelivered, got %v", want, got)
+		}
+	}
+	select {
+	case msg := <-plain.received:
+		t.Errorf("Expected no redelivery to a unit that is not durable, got %v", msg)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for len(journal.Pending()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := len(journal.Pending()); n != 0 {
+		t.Errorf("Expected handled messages to be acknowledged, %d pending", n)
+	}
+}
+
+func TestOpenJournalDropsTornEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	complete := `{"seq":1,"time":"2026-01-02T03:04:05Z","to":"durable","durable":true,"msg":{"type":"string","value":"a"}}` + "\n"
+	torn := `{"seq":2,"time":"2026-01-02T03:04:05Z","to":"dur`
+	if err := os.WriteFile(path, []byte(complete+torn), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	journal, err := OpenJournal(path)
+	if err != nil {
+		t.Fatalf("OpenJournal failed: %v", err)
+	}
+	if pending := journal.Pending(); len(pending) != 1 || pending[0].Seq != 1 {
+		t.Errorf("Expected entry 1 pending, got %+v", pending)
+	}
+	if _, err := journal.record("durable", NoSender, "b", true); err != nil {
+		t.Fatalf("record failed: %v", err)
+	}
+	journal.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := ReadJournal(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ReadJournal failed: %v", err)
+	}
+	if len(entries) != 2 || entries[1].Seq != 2 {
+		t.Errorf("Expected the new entry to follow entry 1, got %+v", entries)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	var buf bytes.Buffer
+	journal := NewJournal(&buf)
+
+	sink := &journalUnit{received: make(chan any, 8)}
+	relay := &journalUnit{received: make(chan any, 8), forward: "sink"}
+	recorded := NewRegistry(WithJournal(journal))
+	recorded.Register("relay", relay)
+	recorded.Register("sink", sink)
+	if err := recorded.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	recorded.refs["relay"].Send("a")
+	recorded.refs["relay"].Send("b")
+	for i := 0; i < 2; i++ {
+		receive(t, sink.received)
+	}
+	recorded.Stop()
+
+	entries, err := ReadJournal(&buf)
+	if err != nil {
+		t.Fatalf("ReadJournal failed: %v", err)
+	}
+
+	sink = &journalUnit{received: make(chan any, 8)}
+	relay = &journalUnit{received: make(chan any, 8), forward: "sink"}
+	fresh := startRegistry(t, map[string]Unit{"relay": relay, "sink": sink})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	n, err := fresh.Replay(ctx, entries)
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	if n != 4 {
+		t.Errorf("Expected 4 messages replayed, got %d", n)
+	}
+
+	// Replay waits for each message, so everything has been handled, and
+	// the relay's own sends were refused rather than delivered twice.
+	if len(relay.received) != 2 || len(sink.received) != 2 {
+		t.Fatalf("Expected 2 messages each, got relay %d and sink %d", len(relay.received), len(sink.received))
+	}
+	for _, want := range []string{"a!", "b!"} {
+		if got := <-sink.received; got != want {
+			t.Errorf("Expected sink to get %q, got %v", want, got)
+		}
+	}
+}
+
+// syncBuffer counts how often it is synced.
+type syncBuffer struct {
+	bytes.Buffer
+	syncs int
+}
+
+func (b *syncBuffer) Sync() error {
+	b.syncs++
+	return nil
+}
+
+func TestJournalSyncPolicy(t *testing.T) {
+	for _, tt := range []struct {
+		policy SyncPolicy
+		want   int
+	}{
+		{SyncDurable, 1},
+		{SyncAll, 3},
+		{SyncNone, 0},
+	} {
+		var buf syncBuffer
+		journal := NewJournal(&buf, WithSyncPolicy(tt.policy))
+		journal.record("plain", NoSender, "a", false)
+		seq, _ := journal.record("durable", NoSender, "b", true)
+		journal.ack(seq)
+		if buf.syncs != tt.want {
+			t.Errorf("%v: expected %d syncs, got %d", tt.policy, tt.want, buf.syncs)
+		}
+	}
+}
+
+func TestJournalCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	journal, err := OpenJournal(path, WithCompaction(2))
+	if err != nil {
+		t.Fatalf("OpenJournal failed: %v", err)
+	}
+	for _, msg := range []string{"a", "b", "c"} {
+		if _, err := journal.record("durable", NoSender, msg, true); err != nil {
+			t.Fatalf("record failed: %v", err)
+		}
+	}
+	journal.record("plain", NoSender, "d", false)
+	journal.ack(1)
+	journal.ack(3)
+	if _, err := journal.record("durable", NoSender, "e", true); err != nil {
+		t.Fatalf("record after compaction failed: %v", err)
+	}
+	journal.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := ReadJournal(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ReadJournal failed: %v", err)
+	}
+	var seqs []uint64
+	for _, e := range entries {
+		seqs = append(seqs, e.Seq)
+	}
+	if len(seqs) != 3 || seqs[0] != 2 || seqs[1] != 4 || seqs[2] != 5 || !entries[1].Ack {
+		t.Fatalf("Expected entry 2, the last sequence number and entry 5, got %+v", entries)
+	}
+
+	journal, err = OpenJournal(path)
+	if err != nil {
+		t.Fatalf("OpenJournal failed: %v", err)
+	}
+	defer journal.Close()
+	if pending := journal.Pending(); len(pending) != 2 || pending[0].Seq != 2 || pending[1].Seq != 5 {
+		t.Errorf("Expected entries 2 and 5 pending, got %+v", pending)
+	}
+	if seq, _ := journal.record("durable", NoSender, "f", true); seq != 6 {
+		t.Errorf("Expected numbering to carry on at 6, got %d", seq)
+	}
+
+	if err := NewJournal(&bytes.Buffer{}).Compact(); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Expected a journal without a file not to compact, got %v", err)
+	}
+}
+
+func TestJournalRedeliveryWithinLimits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	journal, err := OpenJournal(path)
+	if err != nil {
+		t.Fatalf("OpenJournal failed: %v", err)
+	}
+	defer journal.Close()
+	for _, msg := range []string{"a", "b"} {
+		if _, err := journal.record("durable", NoSender, msg, true); err != nil {
+			t.Fatalf("record failed: %v", err)
+		}
+	}
+
+	u := &journalUnit{received: make(chan any, 4), release: make(chan struct{})}
+	reg := NewRegistry(WithJournal(journal))
+	reg.Register("durable", u, Durable(), WithConcurrencyLimit(1))
+	started := make(chan error, 1)
+	go func() { started <- reg.Start() }()
+	defer reg.Stop()
+
+	// The first message is in hand, so the second must wait its turn
+	// rather than be queued past the limit.
+	ref := reg.refs["durable"]
+	deadline := time.Now().Add(time.Second)
+	for !ref.handling.Load() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if n := ref.mailbox.len(); n != 0 {
+		t.Errorf("Expected redelivery to wait for the concurrency limit, %d queued", n)
+	}
+
+	close(u.release)
+	for _, want := range []string{"a", "b"} {
+		if got := receive(t, u.received); got != want {
+			t.Errorf("Expected %q redelivered, got %v", want, got)
+		}
+	}
+	if err := <-started; err != nil {
+		t.Errorf("Start failed: %v", err)
+	}
+}
@@ -0,0 +1,312 @@
+package unit
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/eliothedeman/smol/metrics"
+)
+
+var (
+	ErrRateLimited = errors.New("rate limited")
+	ErrBusy        = errors.New("unit busy")
+)
+
+// LimitPolicy decides what happens to a message sent past a unit's rate
+// or concurrency limits.
+type LimitPolicy int
+
+const (
+	// LimitReject refuses the message with ErrRateLimited or ErrBusy.
+	LimitReject LimitPolicy = iota
+	// LimitWait makes the sender wait until the message is within the
+	// limits, or the unit stops.
+	LimitWait
+)
+
+func (p LimitPolicy) String() string {
+	switch p {
+	case LimitReject:
+		return "reject"
+	case LimitWait:
+		return "wait"
+	default:
+		return "unknown"
+	}
+}
+
+type limitConfig struct {
+	rate, senderRate               float64
+	burst, senderBurst             int
+	concurrency, senderConcurrency int
+	policy                         LimitPolicy
+}
+
+func (c limitConfig) enabled() bool {
+	return c.rate > 0 || c.senderRate > 0 || c.concurrency > 0 || c.senderConcurrency > 0
+}
+
+// WithRateLimit lets perSecond messages a second through to the unit, in
+// bursts of up to burst.
+func WithRateLimit(perSecond float64, burst int) Option {
+	return func(c *unitConfig) {
+		c.limits.rate = perSecond
+		c.limits.burst = max(burst, 1)
+	}
+}
+
+// WithSenderRateLimit applies a rate limit like WithRateLimit to each
+// sender separately.
+func WithSenderRateLimit(perSecond float64, burst int) Option {
+	return func(c *unitConfig) {
+		c.limits.senderRate = perSecond
+		c.limits.senderBurst = max(burst, 1)
+	}
+}
+
+// WithConcurrencyLimit bounds how many messages the unit may have queued
+// or in hand at once.
+func WithConcurrencyLimit(n int) Option {
+	return func(c *unitConfig) {
+		c.limits.concurrency = n
+	}
+}
+
+// WithSenderConcurrencyLimit bounds how many messages from any one sender
+// the unit may have queued or in hand at once.
+func WithSenderConcurrencyLimit(n int) Option {
+	return func(c *unitConfig) {
+		c.limits.senderConcurrency = n
+	}
+}
+
+// WithLimitPolicy sets what happens to messages past the unit's limits.
+// The default is LimitReject.
+func WithLimitPolicy(p LimitPolicy) Option {
+	return func(c *unitConfig) {
+		c.limits.policy = p
+	}
+}
+
+// limiter admits messages to a unit within its limits.
+type limiter struct {
+	cfg   limitConfig
+	clock Clock
+
+	rateLimited *metrics.Counter
+	busy        *metrics.Counter
+	waited      *metrics.Histogram
+
+	mu          sync.Mutex
+	bucket      bucket
+	senders     map[string]*senderLimits
+	outstanding int
+	// pruned is when senders were last checked for entries to forget.
+	pruned time.Time
+	// changed is closed and replaced whenever a message is released, to
+	// wake waiting senders.
+	changed chan struct{}
+}
+
+type senderLimits struct {
+	bucket      bucket
+	outstanding int
+}
+
+// bucket is a token bucket, refilled as time passes.
+type bucket struct {
+	tokens float64
+	last   time.Time
+	// filled is set once the bucket has been given its first burst.
+	filled bool
+}
+
+// wait returns how long until the bucket has a token, refilling it first.
+func (b *bucket) wait(now time.Time, rate float64, burst int) time.Duration {
+	if !b.filled {
+		b.tokens = float64(burst)
+		b.filled = true
+	} else {
+		b.tokens = min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+func newLimiter(ref *unitRef, cfg limitConfig) *limiter {
+	labels := metrics.Labels{"unit": ref.name}
+	m := ref.reg.metrics
+	return &limiter{
+		cfg:         cfg,
+		clock:       ref.reg.clock,
+		rateLimited: m.Counter("smol_unit_rate_limited_total", "Messages refused or delayed by the unit's rate limits.", labels),
+		busy:        m.Counter("smol_unit_busy_total", "Messages refused or delayed by the unit's concurrency limits.", labels),
+		waited:      m.Histogram("smol_unit_limit_wait_seconds", "Time senders waited for the unit's limits.", nil, labels),
+		senders:     make(map[string]*senderLimits),
+		changed:     make(chan struct{}),
+	}
+}
+
+// admit lets a message from sender through to the unit, or reports why
+// it cannot go. Under LimitWait it waits until the message can go, the
+// unit stops or the registry shuts down, except for messages the unit
+// sends itself, which could only be let through once it stopped waiting.
+// The returned func must be called once the message is handled or
+// dropped.
+func (r *unitRef) admit(from UnitRef) (func(), error) {
+	if r.limiter == nil {
+		return nil, nil
+	}
+	sender := from.Name()
+	return r.acquire(sender, r.limiter.cfg.policy == LimitWait && sender != r.name)
+}
+
+// acquire lets a message from sender through to the unit, waiting until
+// it can go if block is set.
+func (r *unitRef) acquire(sender string, block bool) (func(), error) {
+	l := r.limiter
+	var waiting bool
+	var waitStart time.Time
+	for {
+		if r.closed.Load() {
+			return nil, ErrUnitStopped
+		}
+		l.mu.Lock()
+		wait, err := l.check(sender)
+		if err == nil {
+			l.take(sender)
+			l.mu.Unlock()
+			if waiting {
+				l.waited.Observe(l.clock.Now().Sub(waitStart).Seconds())
+			}
+			return func() { l.release(sender) }, nil
+		}
+		changed := l.changed
+		l.mu.Unlock()
+
+		if !waiting {
+			if errors.Is(err, ErrRateLimited) {
+				l.rateLimited.Inc()
+			} else {
+				l.busy.Inc()
+			}
+			if !block {
+				return nil, fmt.Errorf("%w: %s", err, r.name)
+			}
+			waiting = true
+			waitStart = l.clock.Now()
+		}
+
+		woken := make(chan struct{})
+		stop := func() bool { return false }
+		if wait > 0 {
+			stop = l.clock.AfterFunc(wait, func() { close(woken) })
+		}
+		select {
+		case <-changed:
+		case <-woken:
+		case <-r.done:
+			stop()
+			return nil, ErrUnitStopped
+		case <-r.reg.ctx.Done():
+			stop()
+			return nil, ErrShutdown
+		}
+		stop()
+	}
+}
+
+// check reports whether a message from sender is past the limits, and
+// if it is past a rate limit, how long until it is not. The caller must
+// hold l.mu.
+func (l *limiter) check(sender string) (time.Duration, error) {
+	now := l.clock.Now()
+	l.prune(now)
+	s := l.senders[sender]
+	if s == nil {
+		s = &senderLimits{}
+		l.senders[sender] = s
+	}
+	if l.cfg.concurrency > 0 && l.outstanding >= l.cfg.concurrency ||
+		l.cfg.senderConcurrency > 0 && s.outstanding >= l.cfg.senderConcurrency {
+		return 0, ErrBusy
+	}
+
+	var wait time.Duration
+	if l.cfg.rate > 0 {
+		wait = l.bucket.wait(now, l.cfg.rate, l.cfg.burst)
+	}
+	if l.cfg.senderRate > 0 {
+		wait = max(wait, s.bucket.wait(now, l.cfg.senderRate, l.cfg.senderBurst))
+	}
+	if wait > 0 {
+		return wait, ErrRateLimited
+	}
+	return 0, nil
+}
+
+// take uses up the tokens and concurrency a message needs. The caller
+// must hold l.mu and have checked the message is within the limits.
+func (l *limiter) take(sender string) {
+	s := l.senders[sender]
+	if l.cfg.rate > 0 {
+		l.bucket.tokens--
+	}
+	if l.cfg.senderRate > 0 {
+		s.bucket.tokens--
+	}
+	l.outstanding++
+	s.outstanding++
+}
+
+func (l *limiter) release(sender string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.outstanding--
+	if s := l.senders[sender]; s != nil {
+		s.outstanding--
+		if l.idle(s, l.clock.Now()) {
+			delete(l.senders, sender)
+		}
+	}
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// idle reports whether a sender's limits are back where a new sender's
+// start, so that they need not be remembered. The caller must hold l.mu.
+func (l *limiter) idle(s *senderLimits, now time.Time) bool {
+	if s.outstanding > 0 {
+		return false
+	}
+	if l.cfg.senderRate == 0 || !s.bucket.filled {
+		return true
+	}
+	refilled := s.bucket.tokens + now.Sub(s.bucket.last).Seconds()*l.cfg.senderRate
+	return refilled >= float64(l.cfg.senderBurst)
+}
+
+// prune forgets senders whose buckets have filled up again since their
+// last message. It looks at most once in the time an empty bucket takes
+// to fill. The caller must hold l.mu.
+func (l *limiter) prune(now time.Time) {
+	if l.cfg.senderRate == 0 {
+		return
+	}
+	refill := time.Duration(float64(l.cfg.senderBurst) / l.cfg.senderRate * float64(time.Second))
+	if now.Sub(l.pruned) < refill {
+		return
+	}
+	l.pruned = now
+	for sender, s := range l.senders {
+		if l.idle(s, now) {
+			delete(l.senders, sender)
+		}
+	}
+}
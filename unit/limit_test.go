@@ -0,0 +1,248 @@
+package unit
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// senderRef is a sender known only by its name.
+type senderRef string
+
+func (s senderRef) Name() string       { return string(s) }
+func (s senderRef) Send(msg any) error { return nil }
+func (s senderRef) Stop()              {}
+
+// gateUnit holds each message until it is let through.
+type gateUnit struct {
+	gate    chan struct{}
+	handled chan any
+}
+
+func (g *gateUnit) Init(ctx Ctx) {}
+
+func (g *gateUnit) Handle(ctx Ctx, from UnitRef, message any) error {
+	<-g.gate
+	g.handled <- message
+	return nil
+}
+
+func newGate() *gateUnit {
+	return &gateUnit{gate: make(chan struct{}), handled: make(chan any, 16)}
+}
+
+func outstanding(ref *unitRef) int {
+	ref.limiter.mu.Lock()
+	defer ref.limiter.mu.Unlock()
+	return ref.limiter.outstanding
+}
+
+func startLimited(t *testing.T, clock Clock, unit Unit, opts ...Option) (*Registry, *unitRef) {
+	t.Helper()
+	registry := NewRegistry(WithClock(clock))
+	registry.Register("limited", unit, opts...)
+	if err := registry.Start(); err != nil {
+		t.Fatalf("Failed to start registry: %v", err)
+	}
+	t.Cleanup(registry.Stop)
+	return registry, registry.getRef("limited")
+}
+
+func expectMetrics(t *testing.T, registry *Registry, want ...string) {
+	t.Helper()
+	var b strings.Builder
+	registry.Metrics().WriteText(&b)
+	for _, w := range want {
+		if !strings.Contains(b.String(), w) {
+			t.Errorf("Expected %q in metrics:\n%s", w, b.String())
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	clock := &fakeClock{}
+	registry, ref := startLimited(t, clock, &testUnit{}, WithRateLimit(2, 2), WithSenderRateLimit(1, 1))
+
+	if err := ref.deliver(senderRef("a"), 1); err != nil {
+		t.Fatalf("First send failed: %v", err)
+	}
+	if err := ref.deliver(senderRef("a"), 2); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("Expected a's second send to be rate limited, got %v", err)
+	}
+	if err := ref.deliver(senderRef("b"), 3); err != nil {
+		t.Fatalf("b's send failed: %v", err)
+	}
+	if err := ref.deliver(senderRef("c"), 4); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("Expected the unit's burst to be used up, got %v", err)
+	}
+
+	clock.Advance(time.Second)
+	for _, sender := range []string{"a", "c"} {
+		if err := ref.deliver(senderRef(sender), 5); err != nil {
+			t.Errorf("Send from %s after refill failed: %v", sender, err)
+		}
+	}
+	expectMetrics(t, registry, `smol_unit_rate_limited_total{unit="limited"} 2`)
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	gate := newGate()
+	registry, ref := startLimited(t, &fakeClock{}, gate, WithConcurrencyLimit(3), WithSenderConcurrencyLimit(2))
+
+	for i, sender := range []string{"a", "a", "b"} {
+		if err := ref.deliver(senderRef(sender), i); err != nil {
+			t.Fatalf("Send %d failed: %v", i, err)
+		}
+	}
+	if err := ref.deliver(senderRef("c"), 3); !errors.Is(err, ErrBusy) {
+		t.Errorf("Expected the unit to be busy, got %v", err)
+	}
+
+	gate.gate <- struct{}{}
+	<-gate.handled
+	waitFor(t, "the message to be released", func() bool { return outstanding(ref) == 2 })
+	if err := ref.deliver(senderRef("b"), 4); err != nil {
+		t.Errorf("Send after release failed: %v", err)
+	}
+	if err := ref.deliver(senderRef("a"), 5); !errors.Is(err, ErrBusy) {
+		t.Errorf("Expected the unit to be busy again, got %v", err)
+	}
+	close(gate.gate)
+	expectMetrics(t, registry, `smol_unit_busy_total{unit="limited"} 2`)
+}
+
+func TestSenderConcurrencyLimit(t *testing.T) {
+	gate := newGate()
+	_, ref := startLimited(t, &fakeClock{}, gate, WithSenderConcurrencyLimit(1))
+	defer close(gate.gate)
+
+	if err := ref.deliver(senderRef("a"), 1); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if err := ref.deliver(senderRef("a"), 2); !errors.Is(err, ErrBusy) {
+		t.Errorf("Expected a to be held to one message, got %v", err)
+	}
+	if err := ref.deliver(senderRef("b"), 3); err != nil {
+		t.Errorf("Expected b to get through, got %v", err)
+	}
+}
+
+func TestLimitWait(t *testing.T) {
+	clock := &fakeClock{}
+	gate := newGate()
+	registry, ref := startLimited(t, clock, gate, WithConcurrencyLimit(1), WithRateLimit(1, 1), WithLimitPolicy(LimitWait))
+
+	if err := ref.deliver(senderRef("a"), 1); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	sent := make(chan error, 1)
+	go func() { sent <- ref.deliver(senderRef("a"), 2) }()
+
+	// The second send waits for the first message to be handled, then for
+	// a token.
+	waitFor(t, "the send to wait", func() bool { return ref.limiter.busy.Value() == 1 })
+	gate.gate <- struct{}{}
+	<-gate.handled
+	select {
+	case err := <-sent:
+		t.Fatalf("Expected the send to wait for a token, got %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	clock.Advance(time.Second)
+	select {
+	case err := <-sent:
+		if err != nil {
+			t.Fatalf("Waiting send failed: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for the send")
+	}
+	expectMetrics(t, registry,
+		`smol_unit_busy_total{unit="limited"} 1`,
+		`smol_unit_limit_wait_seconds_count{unit="limited"} 1`,
+	)
+
+	go func() { sent <- ref.deliver(senderRef("b"), 3) }()
+	time.Sleep(10 * time.Millisecond)
+	ref.Stop()
+	close(gate.gate)
+	select {
+	case err := <-sent:
+		if !errors.Is(err, ErrUnitStopped) {
+			t.Errorf("Expected ErrUnitStopped for a waiting sender, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for the waiting send to fail")
+	}
+}
+
+func TestLimitWaitRefusesSelf(t *testing.T) {
+	gate := newGate()
+	_, ref := startLimited(t, &fakeClock{}, gate, WithConcurrencyLimit(1), WithLimitPolicy(LimitWait))
+	defer close(gate.gate)
+
+	if err := ref.deliver(senderRef("a"), 1); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	// Waiting would deadlock a unit sending to itself from Handle.
+	if err := ref.deliver(senderRef("limited"), 2); !errors.Is(err, ErrBusy) {
+		t.Errorf("Expected the unit's send to itself to be refused, got %v", err)
+	}
+}
+
+func senders(ref *unitRef) int {
+	ref.limiter.mu.Lock()
+	defer ref.limiter.mu.Unlock()
+	return len(ref.limiter.senders)
+}
+
+func TestSenderLimitsForgotten(t *testing.T) {
+	clock := &fakeClock{}
+	_, ref := startLimited(t, clock, &testUnit{}, WithSenderRateLimit(1, 2))
+
+	for _, sender := range []string{"a", "b", "c"} {
+		if err := ref.deliver(senderRef(sender), sender); err != nil {
+			t.Fatalf("Send from %s failed: %v", sender, err)
+		}
+	}
+	waitFor(t, "the messages to be handled", func() bool { return outstanding(ref) == 0 })
+	if n := senders(ref); n != 3 {
+		t.Fatalf("Expected senders with spent tokens to be remembered, got %d", n)
+	}
+
+	// Once their buckets are full again they are forgotten.
+	clock.Advance(2 * time.Second)
+	if err := ref.deliver(senderRef("d"), "d"); err != nil {
+		t.Fatalf("Send from d failed: %v", err)
+	}
+	if n := senders(ref); n != 1 {
+		t.Errorf("Expected only d to be remembered, got %d senders", n)
+	}
+}
+
+func TestPoolWorkerLimits(t *testing.T) {
+	registry := NewRegistry()
+	gate := make(chan struct{})
+	handled := make(chan any, 16)
+	p := startPool(t, registry, 2, func() Unit { return &gateUnit{gate: gate, handled: handled} }, WithConcurrencyLimit(1))
+	defer close(gate)
+
+	// Each worker takes one message at a time, so the third message finds
+	// both busy and is refused.
+	pool := registry.getRef("pool")
+	replies := make(chan any, 1)
+	rejected := make(chan error, 1)
+	for i := 0; i < 3; i++ {
+		pool.deliver(&bridgeRef{replies: replies, rejected: rejected}, "same")
+		waitFor(t, "the message to be routed", func() bool { return poolLoad(p) == min(i+1, 2) && pool.load() == 0 })
+	}
+	select {
+	case err := <-rejected:
+		if !errors.Is(err, ErrBusy) {
+			t.Errorf("Expected ErrBusy, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for the rejection")
+	}
+}
@@ -0,0 +1,232 @@
+package unit
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// OverflowPolicy decides what a mailbox does with a message that arrives
+// while it is already full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock makes the sender wait until the mailbox has room.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest discards the oldest queued message to make room.
+	OverflowDropOldest
+	// OverflowReject refuses the new message with ErrMailboxFull.
+	OverflowReject
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowBlock:
+		return "block"
+	case OverflowDropOldest:
+		return "drop-oldest"
+	case OverflowReject:
+		return "reject"
+	default:
+		return "unknown"
+	}
+}
+
+const DefaultMailboxSize = 64
+
+var (
+	ErrMailboxFull   = errors.New("mailbox full")
+	ErrMailboxClosed = errors.New("mailbox closed")
+	// ErrUnitPaused refuses a message for a full mailbox whose unit the
+	// memory monitor has paused, instead of blocking the sender.
+	ErrUnitPaused = errors.New("unit paused")
+)
+
+type envelope struct {
+	from UnitRef
+	msg  any
+	span SpanContext
+	sent time.Time
+	// seq is the message's journal entry, when it is for a durable unit.
+	seq uint64
+	// handled, if set, is closed once the message has been handled.
+	handled chan struct{}
+	// release, if set, frees the message's place in the unit's limits.
+	release func()
+}
+
+// done releases the envelope's place in the unit's limits, once it has
+// been handled or dropped.
+func (e envelope) done() {
+	if e.release != nil {
+		e.release()
+	}
+}
+
+// mailbox is a bounded FIFO queue of envelopes drained by a single
+// dispatcher goroutine.
+type mailbox struct {
+	mu       sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	buf      []envelope
+	sys      []envelope
+	head     int
+	size     int
+	policy   OverflowPolicy
+	closed   bool
+	paused   bool
+	// dropped, if set, is given each envelope OverflowDropOldest drops,
+	// outside m.mu.
+	dropped func(envelope)
+}
+
+func newMailbox(capacity int, policy OverflowPolicy) *mailbox {
+	if capacity <= 0 {
+		capacity = DefaultMailboxSize
+	}
+	m := &mailbox{
+		buf:    make([]envelope, capacity),
+		policy: policy,
+	}
+	m.notEmpty = sync.NewCond(&m.mu)
+	m.notFull = sync.NewCond(&m.mu)
+	return m
+}
+
+func (m *mailbox) push(env envelope) error {
+	var dropped []envelope
+	defer func() {
+		for _, env := range dropped {
+			if m.dropped != nil {
+				m.dropped(env)
+			} else {
+				env.done()
+			}
+		}
+	}()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrMailboxClosed
+	}
+
+	for m.size == len(m.buf) {
+		switch m.policy {
+		case OverflowReject:
+			return ErrMailboxFull
+		case OverflowDropOldest:
+			dropped = append(dropped, m.buf[m.head])
+			m.buf[m.head] = envelope{}
+			m.head = (m.head + 1) % len(m.buf)
+			m.size--
+		default:
+			if m.paused {
+				return ErrUnitPaused
+			}
+			m.notFull.Wait()
+			if m.closed {
+				return ErrMailboxClosed
+			}
+		}
+	}
+
+	m.buf[(m.head+m.size)%len(m.buf)] = env
+	m.size++
+	m.notEmpty.Signal()
+	return nil
+}
+
+// pushSystem queues a registry control message ahead of all regular
+// messages, ignoring the capacity bound.
+func (m *mailbox) pushSystem(msg any) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return
+	}
+	m.sys = append(m.sys, envelope{from: NoSender, msg: msg})
+	m.notEmpty.Signal()
+}
+
+// pop blocks until an envelope is available. It returns false once the
+// mailbox has been closed and fully drained. While the mailbox is paused
+// only system messages are returned, unless it has been closed.
+func (m *mailbox) pop() (envelope, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for len(m.sys) == 0 && (m.size == 0 || m.paused && !m.closed) {
+		if m.closed {
+			return envelope{}, false
+		}
+		m.notEmpty.Wait()
+	}
+
+	if len(m.sys) > 0 {
+		env := m.sys[0]
+		m.sys = m.sys[1:]
+		return env, true
+	}
+
+	env := m.buf[m.head]
+	m.buf[m.head] = envelope{}
+	m.head = (m.head + 1) % len(m.buf)
+	m.size--
+	m.notFull.Signal()
+	return env, true
+}
+
+// setPaused holds regular messages in the mailbox until it is resumed.
+// Senders waiting for room give up with ErrUnitPaused.
+func (m *mailbox) setPaused(paused bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.paused = paused
+	m.notEmpty.Broadcast()
+	m.notFull.Broadcast()
+}
+
+func (m *mailbox) isPaused() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.paused
+}
+
+func (m *mailbox) len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.size
+}
+
+// drain removes and returns every regular envelope still queued.
+func (m *mailbox) drain() []envelope {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	envs := make([]envelope, 0, m.size)
+	for m.size > 0 {
+		envs = append(envs, m.buf[m.head])
+		m.buf[m.head] = envelope{}
+		m.head = (m.head + 1) % len(m.buf)
+		m.size--
+	}
+	m.sys = nil
+	m.notFull.Broadcast()
+	return envs
+}
+
+// close stops the mailbox from accepting new envelopes. Envelopes already
+// queued are still returned by pop.
+func (m *mailbox) close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.closed = true
+	m.notEmpty.Broadcast()
+	m.notFull.Broadcast()
+}
@@ -0,0 +1,109 @@
+package unit
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMailboxFIFO(t *testing.T) {
+	mb := newMailbox(4, OverflowReject)
+
+	for i := 0; i < 4; i++ {
+		if err := mb.push(envelope{msg: i}); err != nil {
+			t.Fatalf("push %d failed: %v", i, err)
+		}
+	}
+
+	for i := 0; i < 4; i++ {
+		env, ok := mb.pop()
+		if !ok {
+			t.Fatalf("pop %d returned closed", i)
+		}
+		if env.msg != i {
+			t.Errorf("Expected message %d, got %v", i, env.msg)
+		}
+	}
+}
+
+func TestMailboxReject(t *testing.T) {
+	mb := newMailbox(2, OverflowReject)
+
+	mb.push(envelope{msg: 1})
+	mb.push(envelope{msg: 2})
+
+	if err := mb.push(envelope{msg: 3}); !errors.Is(err, ErrMailboxFull) {
+		t.Errorf("Expected ErrMailboxFull, got %v", err)
+	}
+	if mb.len() != 2 {
+		t.Errorf("Expected 2 queued messages, got %d", mb.len())
+	}
+}
+
+func TestMailboxDropOldest(t *testing.T) {
+	mb := newMailbox(2, OverflowDropOldest)
+
+	mb.push(envelope{msg: 1})
+	mb.push(envelope{msg: 2})
+	if err := mb.push(envelope{msg: 3}); err != nil {
+		t.Fatalf("push failed: %v", err)
+	}
+
+	first, _ := mb.pop()
+	second, _ := mb.pop()
+	if first.msg != 2 || second.msg != 3 {
+		t.Errorf("Expected [2 3], got [%v %v]", first.msg, second.msg)
+	}
+}
+
+func TestMailboxBlock(t *testing.T) {
+	mb := newMailbox(1, OverflowBlock)
+	mb.push(envelope{msg: 1})
+
+	pushed := make(chan error)
+	go func() {
+		pushed <- mb.push(envelope{msg: 2})
+	}()
+
+	select {
+	case <-pushed:
+		t.Fatal("Expected push to block while mailbox is full")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	mb.pop()
+
+	select {
+	case err := <-pushed:
+		if err != nil {
+			t.Errorf("Blocked push failed: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for blocked push")
+	}
+}
+
+func TestMailboxClose(t *testing.T) {
+	mb := newMailbox(1, OverflowBlock)
+	mb.push(envelope{msg: 1})
+
+	blocked := make(chan error)
+	go func() {
+		blocked <- mb.push(envelope{msg: 2})
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	mb.close()
+
+	if err := <-blocked; !errors.Is(err, ErrMailboxClosed) {
+		t.Errorf("Expected ErrMailboxClosed for blocked sender, got %v", err)
+	}
+
+	// Messages queued before close are still delivered.
+	if env, ok := mb.pop(); !ok || env.msg != 1 {
+		t.Errorf("Expected queued message 1, got %v (ok=%v)", env.msg, ok)
+	}
+	if _, ok := mb.pop(); ok {
+		t.Error("Expected drained mailbox to report closed")
+	}
+}
@@ -0,0 +1,368 @@
+package unit
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"runtime/metrics"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	smolmetrics "github.com/eliothedeman/smol/metrics"
+)
+
+const (
+	// DefaultMemoryLimit is a suitable budget for smol as a whole.
+	DefaultMemoryLimit    = 2 << 30
+	DefaultMemoryInterval = time.Second
+)
+
+// MemoryBreachTopic is the topic MemoryBreach events are published on.
+const MemoryBreachTopic = "system.memory.breach"
+
+// MemoryBreachInterval is how often a breach that persists is published
+// again when nothing more is done about it.
+const MemoryBreachInterval = time.Minute
+
+// Priority orders units for eviction when memory runs short; the lowest
+// priority units are evicted or paused first and critical ones never are.
+type Priority int
+
+const (
+	PriorityLow Priority = iota
+	PriorityNormal
+	PriorityHigh
+	PriorityCritical
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityNormal:
+		return "normal"
+	case PriorityHigh:
+		return "high"
+	case PriorityCritical:
+		return "critical"
+	default:
+		return "unknown"
+	}
+}
+
+// MemoryReporter is implemented by units that can estimate the memory
+// they hold, such as loaded models. It is called from the registry's
+// memory monitor, concurrently with Handle.
+type MemoryReporter interface {
+	MemoryUsage() uint64
+}
+
+// ProcessOwner is implemented by units that run child processes. The
+// resident memory of those processes counts towards the unit's usage.
+// Like MemoryUsage, Processes is called concurrently with Handle.
+type ProcessOwner interface {
+	Processes() []int
+}
+
+// Evicter is implemented by units that can free memory on demand, for
+// example by unloading a model. Evict runs on the unit's own goroutine.
+type Evicter interface {
+	Evict(ctx Ctx)
+}
+
+// MemoryAction is what the registry did about a breach.
+type MemoryAction string
+
+const (
+	MemoryEvict MemoryAction = "evict"
+	MemoryPause MemoryAction = "pause"
+)
+
+// MemoryBreach is published on MemoryBreachTopic when a unit goes over its
+// budget, or the registry as a whole over its limit. While the breach
+// lasts it is published again on every check that takes an action, and
+// otherwise once every MemoryBreachInterval.
+type MemoryBreach struct {
+	// Unit is empty when the breach is of the registry's limit.
+	Unit  string
+	Usage uint64
+	Limit uint64
+	// Action is what was done in response, if anything, and Target the
+	// unit it was done to.
+	Action MemoryAction
+	Target string
+}
+
+// MemoryReport is the result of one memory check.
+type MemoryReport struct {
+	// Go is the memory held by the Go runtime, of which Heap is live
+	// heap objects. Children is the resident memory of child processes.
+	Go       uint64
+	Heap     uint64
+	Children uint64
+	Total    uint64
+	Limit    uint64
+	Units    []UnitMemory
+}
+
+// UnitMemory is a unit's share of a MemoryReport.
+type UnitMemory struct {
+	Name     string
+	Usage    uint64
+	Budget   uint64
+	Priority Priority
+	Paused   bool
+	Evicted  bool
+}
+
+type memoryConfig struct {
+	limit    uint64
+	interval time.Duration
+}
+
+// WithMemoryLimit has the registry check its memory use every interval,
+// evicting or pausing units while it is over limit. Paused units hold
+// their messages; once a paused unit's mailbox is full, sends to it fail
+// with ErrUnitPaused rather than wait, whatever its overflow policy.
+func WithMemoryLimit(limit uint64, interval time.Duration) RegistryOption {
+	return func(r *Registry) {
+		if interval <= 0 {
+			interval = DefaultMemoryInterval
+		}
+		r.memory = memoryConfig{limit: limit, interval: interval}
+	}
+}
+
+// WithMemoryBudget declares how much memory the unit expects to use. Going
+// over it raises a MemoryBreach and, for an Evicter, an eviction. Budgets
+// are checked by the monitor started with WithMemoryLimit.
+func WithMemoryBudget(bytes uint64) Option {
+	return func(c *unitConfig) {
+		c.budget = bytes
+	}
+}
+
+// WithPriority sets the unit's priority; the default is PriorityNormal.
+func WithPriority(p Priority) Option {
+	return func(c *unitConfig) {
+		c.priority = p
+	}
+}
+
+type evictSignal struct{}
+
+// monitorMemory checks memory on the registry's clock until shutdown.
+func (r *Registry) monitorMemory() {
+	NewTimer(r.clock, Every(r.memory.interval), func() error {
+		if r.ctx.Err() != nil {
+			return ErrShutdown
+		}
+		r.CheckMemory()
+		return nil
+	})
+}
+
+// CheckMemory measures the registry's memory use and enforces the budgets
+// and limit. While over the limit one more unit is evicted or paused on
+// each check, lowest priority first; paused units resume once usage drops
+// below 90% of the limit.
+func (r *Registry) CheckMemory() MemoryReport {
+	r.mu.RLock()
+	refs := make([]*unitRef, 0, len(r.order))
+	units := make([]Unit, 0, len(r.order))
+	for _, name := range r.order {
+		refs = append(refs, r.refs[name])
+		units = append(units, r.units[name])
+	}
+	r.mu.RUnlock()
+
+	report := MemoryReport{Limit: r.memory.limit}
+	report.Go, report.Heap = goMemory()
+
+	for i, ref := range refs {
+		children := childMemory(units[i])
+		report.Children += children
+		usage := unitMemory(units[i]) + children
+		report.Units = append(report.Units, UnitMemory{
+			Name:     ref.name,
+			Usage:    usage,
+			Budget:   ref.budget,
+			Priority: ref.priority,
+		})
+		r.metrics.Gauge("smol_unit_memory_bytes", "Memory reported by or for the unit.", smolmetrics.Labels{"unit": ref.name}).Set(float64(usage))
+
+		if ref.budget > 0 && usage > ref.budget {
+			breach := MemoryBreach{Unit: ref.name, Usage: usage, Limit: ref.budget}
+			if _, ok := units[i].(Evicter); ok && !ref.evicted.Load() {
+				r.evict(ref)
+				breach.Action, breach.Target = MemoryEvict, ref.name
+			}
+			r.publishBreach(breach)
+		} else {
+			r.forgetBreach(ref.name)
+		}
+	}
+	report.Total = report.Go + report.Children
+
+	r.metrics.Gauge("smol_memory_bytes", "Memory in use.", smolmetrics.Labels{"source": "go"}).Set(float64(report.Go))
+	r.metrics.Gauge("smol_memory_bytes", "Memory in use.", smolmetrics.Labels{"source": "children"}).Set(float64(report.Children))
+	r.metrics.Gauge("smol_memory_limit_bytes", "Memory the registry must fit in.", nil).Set(float64(report.Limit))
+
+	switch {
+	case report.Limit > 0 && report.Total > report.Limit:
+		breach := MemoryBreach{Usage: report.Total, Limit: report.Limit}
+		if target, unit := r.evictionCandidate(refs, units, report.Units); target != nil {
+			if _, ok := unit.(Evicter); ok {
+				r.evict(target)
+				breach.Action = MemoryEvict
+			} else {
+				target.mailbox.setPaused(true)
+				breach.Action = MemoryPause
+			}
+			breach.Target = target.name
+		}
+		r.publishBreach(breach)
+	case report.Limit == 0 || report.Total < report.Limit-report.Limit/10:
+		r.forgetBreach("")
+		for _, ref := range refs {
+			ref.mailbox.setPaused(false)
+			ref.evicted.Store(false)
+		}
+	}
+
+	for i, ref := range refs {
+		report.Units[i].Paused = ref.mailbox.isPaused()
+		report.Units[i].Evicted = ref.evicted.Load()
+	}
+	return report
+}
+
+// publishBreach publishes breach, unless the same unit's breach, or the
+// limit's, was published within MemoryBreachInterval and nothing new has
+// been done about it.
+func (r *Registry) publishBreach(breach MemoryBreach) {
+	now := r.clock.Now()
+	r.breachMu.Lock()
+	last, seen := r.breaches[breach.Unit]
+	if breach.Action == "" && seen && now.Sub(last) < MemoryBreachInterval {
+		r.breachMu.Unlock()
+		return
+	}
+	r.breaches[breach.Unit] = now
+	r.breachMu.Unlock()
+
+	r.publish(nil, SpanContext{}, MemoryBreachTopic, breach)
+}
+
+// forgetBreach lets the next breach of unit, or of the limit if unit is
+// empty, be published at once.
+func (r *Registry) forgetBreach(unit string) {
+	r.breachMu.Lock()
+	defer r.breachMu.Unlock()
+	delete(r.breaches, unit)
+}
+
+func (r *Registry) evict(ref *unitRef) {
+	ref.evicted.Store(true)
+	ref.mailbox.pushSystem(evictSignal{})
+}
+
+// evictionCandidate picks the lowest priority unit not already evicted or
+// paused, preferring the one using the most memory.
+func (r *Registry) evictionCandidate(refs []*unitRef, units []Unit, usage []UnitMemory) (*unitRef, Unit) {
+	candidates := make([]int, 0, len(refs))
+	for i, ref := range refs {
+		if ref.priority < PriorityCritical && !ref.evicted.Load() && !ref.mailbox.isPaused() {
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	sort.SliceStable(candidates, func(a, b int) bool {
+		ra, rb := refs[candidates[a]], refs[candidates[b]]
+		if ra.priority != rb.priority {
+			return ra.priority < rb.priority
+		}
+		return usage[candidates[a]].Usage > usage[candidates[b]].Usage
+	})
+	i := candidates[0]
+	return refs[i], units[i]
+}
+
+func unitMemory(unit Unit) uint64 {
+	if reporter, ok := unit.(MemoryReporter); ok {
+		return reporter.MemoryUsage()
+	}
+	return 0
+}
+
+func childMemory(unit Unit) uint64 {
+	owner, ok := unit.(ProcessOwner)
+	if !ok {
+		return 0
+	}
+
+	var total uint64
+	for _, pid := range owner.Processes() {
+		rss, err := processRSS(pid)
+		if err == nil {
+			total += rss
+		}
+	}
+	return total
+}
+
+// goMemory reports the memory mapped by the Go runtime, less what it has
+// returned to the OS, along with the bytes in live heap objects.
+func goMemory() (total, heap uint64) {
+	samples := []metrics.Sample{
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+		{Name: "/memory/classes/heap/objects:bytes"},
+	}
+	metrics.Read(samples)
+
+	values := make([]uint64, len(samples))
+	for i, s := range samples {
+		if s.Value.Kind() == metrics.KindUint64 {
+			values[i] = s.Value.Uint64()
+		}
+	}
+	return values[0] - values[1], values[2]
+}
+
+// processRSS reads a process's resident set size from /proc.
+func processRSS(pid int) (uint64, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, ok := strings.CutPrefix(scanner.Text(), "VmRSS:")
+		if !ok {
+			continue
+		}
+		kb, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimSpace(line), " kB"), 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		return kb * 1024, nil
+	}
+	return 0, fmt.Errorf("no VmRSS for process %d", pid)
+}
+
+// safeEvict runs Evict, ignoring a panic so that a failed eviction does
+// not take the unit down.
+func safeEvict(evicter Evicter, ctx Ctx) {
+	defer func() {
+		recover()
+	}()
+	evicter.Evict(ctx)
+}
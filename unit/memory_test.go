@@ -0,0 +1,141 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+type evictableUnit struct {
+	usage   uint64
+	evicted chan struct{}
+}
+
+func (e *evictableUnit) Init(ctx Ctx)                                    {}
+func (e *evictableUnit) Handle(ctx Ctx, from UnitRef, message any) error { return nil }
+func (e *evictableUnit) MemoryUsage() uint64                             { return e.usage }
+func (e *evictableUnit) Evict(ctx Ctx)                                   { e.evicted <- struct{}{} }
+
+type selfProcessUnit struct{ silentUnit }
+
+func (s *selfProcessUnit) Processes() []int { return []int{os.Getpid()} }
+
+func waitBreach(t *testing.T, u *topicUnit) MemoryBreach {
+	t.Helper()
+	select {
+	case pub := <-u.received:
+		<-u.senders
+		return pub.Payload.(MemoryBreach)
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for a memory breach")
+		return MemoryBreach{}
+	}
+}
+
+func TestMemoryLimitPausesLowPriorityUnits(t *testing.T) {
+	clock := &fakeClock{}
+	registry := NewRegistry(WithMemoryLimit(1, time.Hour), WithClock(clock))
+	watcher := newTopicUnit(MemoryBreachTopic)
+	registry.Register("watcher", watcher, WithPriority(PriorityCritical))
+	registry.Register("normal", &echoUnit{})
+	registry.Register("low", &echoUnit{}, WithPriority(PriorityLow), WithMailboxSize(1))
+	if err := registry.Start(); err != nil {
+		t.Fatalf("Failed to start registry: %v", err)
+	}
+	defer registry.Stop()
+
+	report := registry.CheckMemory()
+	if report.Go == 0 || report.Heap == 0 || report.Total < report.Go {
+		t.Errorf("Expected Go memory to be measured, got %+v", report)
+	}
+	breach := waitBreach(t, watcher)
+	if breach.Unit != "" || breach.Action != MemoryPause || breach.Target != "low" {
+		t.Errorf("Expected low to be paused first, got %+v", breach)
+	}
+	if !report.Units[2].Paused || report.Units[1].Paused {
+		t.Errorf("Expected only low to be paused, got %+v", report.Units)
+	}
+
+	pending := askVia("", registry.getRef("low"), "held", SpanContext{})
+	select {
+	case <-pending.Done():
+		t.Fatal("Expected a paused unit not to handle messages")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := registry.getRef("low").Send("overflow"); !errors.Is(err, ErrUnitPaused) {
+		t.Errorf("Expected a send to the full, paused unit to fail fast, got %v", err)
+	}
+
+	registry.CheckMemory()
+	if breach := waitBreach(t, watcher); breach.Target != "normal" {
+		t.Errorf("Expected normal to be paused next, got %+v", breach)
+	}
+
+	// With nothing left to pause the breach is only published again after
+	// MemoryBreachInterval.
+	registry.CheckMemory()
+	select {
+	case pub := <-watcher.received:
+		t.Errorf("Expected the repeated breach to be held back, got %+v", pub.Payload)
+	case <-time.After(50 * time.Millisecond):
+	}
+	clock.Advance(MemoryBreachInterval)
+	registry.CheckMemory()
+	if breach := waitBreach(t, watcher); breach.Action != "" || breach.Target != "" {
+		t.Errorf("Expected critical units to be left alone, got %+v", breach)
+	}
+
+	registry.memory.limit = 1 << 50
+	registry.CheckMemory()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if reply, err := pending.Await(ctx); err != nil || reply != "held" {
+		t.Errorf("Expected the held message to be handled after resuming, got %v, %v", reply, err)
+	}
+}
+
+func TestMemoryBudgetEvicts(t *testing.T) {
+	registry := NewRegistry()
+	watcher := newTopicUnit(MemoryBreachTopic)
+	model := &evictableUnit{usage: 100, evicted: make(chan struct{}, 1)}
+	registry.Register("watcher", watcher)
+	registry.Register("model", model, WithMemoryBudget(50))
+	if err := registry.Start(); err != nil {
+		t.Fatalf("Failed to start registry: %v", err)
+	}
+	defer registry.Stop()
+
+	report := registry.CheckMemory()
+	if report.Units[1].Usage != 100 || report.Units[1].Budget != 50 {
+		t.Errorf("Unexpected usage for model: %+v", report.Units[1])
+	}
+
+	breach := waitBreach(t, watcher)
+	if breach.Unit != "model" || breach.Usage != 100 || breach.Limit != 50 || breach.Action != MemoryEvict {
+		t.Errorf("Unexpected breach: %+v", breach)
+	}
+	select {
+	case <-model.evicted:
+	case <-time.After(time.Second):
+		t.Fatal("Expected model to be evicted")
+	}
+}
+
+func TestChildProcessMemory(t *testing.T) {
+	rss, err := processRSS(os.Getpid())
+	if err != nil {
+		t.Skipf("No /proc on this system: %v", err)
+	}
+	if rss == 0 {
+		t.Error("Expected a non-zero RSS for the test process")
+	}
+
+	registry := NewRegistry()
+	registry.Register("python", &selfProcessUnit{})
+	report := registry.CheckMemory()
+	if report.Children == 0 || report.Units[0].Usage != report.Children {
+		t.Errorf("Expected child memory to be attributed to the unit, got %+v", report)
+	}
+}
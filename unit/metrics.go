@@ -0,0 +1,58 @@
+package unit
+
+import (
+	"time"
+
+	"github.com/eliothedeman/smol/metrics"
+)
+
+// WithMetrics keeps the registry's metrics in m instead of a registry of
+// its own, so they can be exposed alongside others.
+func WithMetrics(m *metrics.Registry) RegistryOption {
+	return func(r *Registry) {
+		r.metrics = m
+	}
+}
+
+// Metrics returns the registry's metrics. Every unit has series for the
+// messages it handled, its errors, restarts and rejected messages, its
+// handler latency and its mailbox depth, labelled with the unit's name.
+// Units with limits also count the messages refused or delayed by them
+// and how long senders waited.
+func (r *Registry) Metrics() *metrics.Registry {
+	return r.metrics
+}
+
+type unitMetrics struct {
+	messages *metrics.Counter
+	errors   *metrics.Counter
+	restarts *metrics.Counter
+	rejected *metrics.Counter
+	latency  *metrics.Histogram
+}
+
+func (r *Registry) newUnitMetrics(ref *unitRef) *unitMetrics {
+	labels := metrics.Labels{"unit": ref.name}
+	r.metrics.GaugeFunc("smol_unit_mailbox_depth", "Messages waiting in the unit's mailbox.", labels, func() float64 {
+		return float64(ref.mailbox.len())
+	})
+	return &unitMetrics{
+		messages: r.metrics.Counter("smol_unit_messages_total", "Messages handled by the unit.", labels),
+		errors:   r.metrics.Counter("smol_unit_errors_total", "Messages the unit failed to handle.", labels),
+		restarts: r.metrics.Counter("smol_unit_restarts_total", "Times the unit was restarted by its supervisor.", labels),
+		rejected: r.metrics.Counter("smol_unit_rejected_total", "Messages refused because the unit's mailbox was full.", labels),
+		latency:  r.metrics.Histogram("smol_unit_handle_seconds", "Time the unit spent handling each message.", nil, labels),
+	}
+}
+
+func (m *unitMetrics) observe(d time.Duration, err error) {
+	m.messages.Inc()
+	m.latency.Observe(d.Seconds())
+	if err != nil {
+		m.errors.Inc()
+	}
+}
+
+func (c *registryCtx) Metrics() *metrics.Registry {
+	return c.reg.metrics.With(metrics.Labels{"unit": c.self.name})
+}
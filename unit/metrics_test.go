@@ -0,0 +1,55 @@
+package unit
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+type countingUnit struct{}
+
+func (c *countingUnit) Init(ctx Ctx) {}
+
+func (c *countingUnit) Handle(ctx Ctx, from UnitRef, message any) error {
+	ctx.Metrics().Counter("smol_test_seen_total", "", nil).Inc()
+	if message == "fail" {
+		return ErrNoReply
+	}
+	return from.Send(message)
+}
+
+func TestRegistryMetrics(t *testing.T) {
+	registry := startRegistry(t, map[string]Unit{"counter": &countingUnit{}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	registry.Ask(ctx, "counter", "one")
+	registry.Ask(ctx, "counter", "two")
+	registry.Ask(ctx, "counter", "fail")
+
+	var b strings.Builder
+	registry.Metrics().WriteText(&b)
+	text := b.String()
+	for _, want := range []string{
+		`smol_unit_messages_total{unit="counter"} 3`,
+		`smol_unit_errors_total{unit="counter"} 1`,
+		`smol_unit_handle_seconds_count{unit="counter"} 3`,
+		`smol_unit_mailbox_depth{unit="counter"} 0`,
+		`smol_test_seen_total{unit="counter"} 3`,
+		`smol_units 1`,
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected %q in metrics:\n%s", want, text)
+		}
+	}
+
+	if err := registry.Unregister("counter"); err != nil {
+		t.Fatalf("Unregister failed: %v", err)
+	}
+	b.Reset()
+	registry.Metrics().WriteText(&b)
+	if strings.Contains(b.String(), `unit="counter"`) {
+		t.Errorf("Expected the unit's series to be removed:\n%s", b.String())
+	}
+}
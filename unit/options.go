@@ -0,0 +1,45 @@
+package unit
+
+// Option configures how a unit is hosted by the registry.
+type Option func(*unitConfig)
+
+type unitConfig struct {
+	mailboxSize int
+	overflow    OverflowPolicy
+	supervisor  *Supervisor
+	dependsOn   []string
+	budget      uint64
+	priority    Priority
+	durable     bool
+	pool        poolConfig
+	limits      limitConfig
+}
+
+func newUnitConfig(opts []Option) unitConfig {
+	cfg := unitConfig{
+		mailboxSize: DefaultMailboxSize,
+		overflow:    OverflowBlock,
+		priority:    PriorityNormal,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// WithMailboxSize bounds the number of messages queued for the unit.
+func WithMailboxSize(size int) Option {
+	return func(c *unitConfig) {
+		c.mailboxSize = size
+	}
+}
+
+// WithOverflowPolicy sets what happens when the unit's mailbox is full.
+func WithOverflowPolicy(policy OverflowPolicy) Option {
+	return func(c *unitConfig) {
+		c.overflow = policy
+	}
+}
+
+// RegistryOption configures a Registry.
+type RegistryOption func(*Registry)
@@ -0,0 +1,147 @@
+package unit
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// OTLPFile is a Tracer that writes each span as an OTLP/JSON
+// ExportTraceServiceRequest on its own line, the format read by the
+// OpenTelemetry collector's otlpjsonfile receiver.
+type OTLPFile struct {
+	mu      sync.Mutex
+	enc     *json.Encoder
+	service string
+	err     error
+}
+
+func NewOTLPFile(w io.Writer, service string) *OTLPFile {
+	return &OTLPFile{enc: json.NewEncoder(w), service: service}
+}
+
+// Err returns the first error writing a span, after which no more are
+// written.
+func (o *OTLPFile) Err() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.err
+}
+
+func (o *OTLPFile) Record(span Span) {
+	req := otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpKeyValue{
+			stringAttr("service.name", o.service),
+		}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "github.com/eliothedeman/smol/unit"},
+			Spans: []otlpSpan{otlpSpanOf(span)},
+		}},
+	}}}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.err == nil {
+		o.err = o.enc.Encode(req)
+	}
+}
+
+// The types below mirror the parts of the OTLP/JSON trace encoding we
+// produce. IDs are hex and timestamps are nanoseconds as strings.
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Events            []otlpEvent    `json:"events,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string `json:"timeUnixNano"`
+	Name         string `json:"name"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string            `json:"key"`
+	Value map[string]string `json:"value"`
+}
+
+const (
+	otlpKindConsumer = 5
+	otlpStatusError  = 2
+)
+
+func otlpSpanOf(span Span) otlpSpan {
+	s := otlpSpan{
+		TraceID:           span.TraceID.String(),
+		SpanID:            span.SpanID.String(),
+		Name:              span.Unit + " " + span.Message,
+		Kind:              otlpKindConsumer,
+		StartTimeUnixNano: unixNano(span.Start),
+		EndTimeUnixNano:   unixNano(span.End),
+		Attributes: []otlpKeyValue{
+			stringAttr("unit", span.Unit),
+			stringAttr("message.type", span.Message),
+		},
+	}
+	if !span.Parent.IsZero() {
+		s.ParentSpanID = span.Parent.String()
+	}
+	if span.From != "" {
+		s.Attributes = append(s.Attributes, stringAttr("from", span.From))
+	}
+	if span.Action != "" {
+		s.Name = span.Unit + " " + span.Action
+		s.Attributes = append(s.Attributes, stringAttr("action", span.Action))
+	}
+	if !span.Sent.IsZero() {
+		s.Events = append(s.Events, otlpEvent{TimeUnixNano: unixNano(span.Sent), Name: "send"})
+	}
+	s.Events = append(s.Events, otlpEvent{TimeUnixNano: unixNano(span.Start), Name: "receive"})
+	if span.Err != nil {
+		s.Status = otlpStatus{Code: otlpStatusError, Message: span.Err.Error()}
+	}
+	return s
+}
+
+func stringAttr(key, value string) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: map[string]string{"stringValue": value}}
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
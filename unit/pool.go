@@ -0,0 +1,425 @@
+package unit
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eliothedeman/smol/metrics"
+)
+
+var ErrNoWorkers = errors.New("pool has no workers")
+
+// PoolStrategy decides which worker of a pool handles a message.
+type PoolStrategy int
+
+const (
+	// RoundRobin hands messages to each worker in turn.
+	RoundRobin PoolStrategy = iota
+	// LeastBusy hands each message to the worker with the fewest messages
+	// queued or being handled.
+	LeastBusy
+	// ConsistentHash hands messages with the same key to the same worker,
+	// moving few keys when workers come and go. See HashBy.
+	ConsistentHash
+)
+
+func (s PoolStrategy) String() string {
+	switch s {
+	case RoundRobin:
+		return "round-robin"
+	case LeastBusy:
+		return "least-busy"
+	case ConsistentHash:
+		return "consistent-hash"
+	default:
+		return "unknown"
+	}
+}
+
+const (
+	// DefaultPoolInterval is how often a pool checks whether to scale.
+	DefaultPoolInterval = time.Second
+
+	// scaleUpDepth is how many messages may wait per worker before an
+	// autoscaling pool adds one.
+	scaleUpDepth = 2
+	// ringReplicas is how many points each worker has on the hash ring.
+	ringReplicas = 32
+)
+
+type poolConfig struct {
+	strategy PoolStrategy
+	key      func(msg any) string
+	min, max int
+}
+
+// WithPoolStrategy sets how a pool picks workers. The default is
+// RoundRobin.
+func WithPoolStrategy(s PoolStrategy) Option {
+	return func(c *unitConfig) {
+		c.pool.strategy = s
+	}
+}
+
+// HashBy routes a pool's messages by consistent hashing of key(msg).
+// Without it, ConsistentHash uses the "key" field of map messages, and
+// the message's default format otherwise.
+func HashBy(key func(msg any) string) Option {
+	return func(c *unitConfig) {
+		c.pool.strategy = ConsistentHash
+		c.pool.key = key
+	}
+}
+
+// Autoscale lets a pool grow to max workers while messages queue up, and
+// shrink back to min while they are idle.
+func Autoscale(min, max int) Option {
+	return func(c *unitConfig) {
+		c.pool.min = min
+		c.pool.max = max
+	}
+}
+
+// poolTick makes a pool check its size.
+type poolTick struct{}
+
+// pool is the unit registered under a pool's name. It forwards each
+// message to one of its workers, which reply to the original sender.
+type pool struct {
+	name    string
+	factory UnitFactory
+	opts    []Option
+	cfg     poolConfig
+	metrics *metrics.Registry
+	size    *metrics.Gauge
+
+	mu      sync.Mutex
+	workers []*unitRef
+	spawned map[string]bool
+	ring    []ringPoint
+	next    int
+	// seq numbers the workers' names.
+	seq int
+
+	// proto is the first worker, which describes the pool. It is kept
+	// apart from mu since Describe is called with the registry locked.
+	proto atomic.Value
+}
+
+type ringPoint struct {
+	hash   uint32
+	worker *unitRef
+}
+
+func newPool(reg *Registry, name string, f UnitFactory, size int, opts []Option) *pool {
+	cfg := newUnitConfig(opts).pool
+	if cfg.min <= 0 && cfg.max <= 0 {
+		cfg.min, cfg.max = size, size
+	}
+	cfg.min = max(cfg.min, 1)
+	cfg.max = max(cfg.max, cfg.min)
+	if cfg.key == nil {
+		cfg.key = defaultPoolKey
+	}
+	return &pool{
+		name:    name,
+		factory: f,
+		opts:    opts,
+		cfg:     cfg,
+		metrics: reg.metrics,
+		size:    reg.metrics.Gauge("smol_pool_workers", "Workers in the pool.", metrics.Labels{"pool": name}),
+		spawned: make(map[string]bool),
+	}
+}
+
+// SpawnPool starts a pool of workers made by f and returns a ref that
+// routes messages across them. The pool starts with size workers, or the
+// minimum given by Autoscale. Other options apply to each worker.
+func (c *registryCtx) SpawnPool(name string, f UnitFactory, size int, opts ...Option) UnitRef {
+	// Restarts reuse the pool so that it keeps its workers.
+	p := newPool(c.reg, name, f, size, opts)
+	return c.Spawn(name, func() Unit { return p }, poolOptions(opts)...)
+}
+
+// RegisterPool registers a pool of workers made by f under name, as
+// SpawnPool does for units.
+func (r *Registry) RegisterPool(name string, f UnitFactory, size int, opts ...Option) {
+	r.Register(name, newPool(r, name, f, size, opts), poolOptions(opts)...)
+}
+
+// poolOptions are the options for the pool's own unit. It is never
+// durable, as the messages it forwards are not journaled again.
+func poolOptions(opts []Option) []Option {
+	return append(opts[:len(opts):len(opts)], func(c *unitConfig) {
+		c.durable = false
+	})
+}
+
+func (p *pool) Init(ctx Ctx) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for len(p.workers) < p.cfg.min {
+		p.addWorker(ctx)
+	}
+	ctx.SendEvery(ctx.Self(), poolTick{}, DefaultPoolInterval)
+}
+
+func (p *pool) Handle(ctx Ctx, from UnitRef, message any) error {
+	switch msg := message.(type) {
+	case poolTick:
+		p.mu.Lock()
+		p.scale(ctx)
+		p.mu.Unlock()
+		return nil
+	case Terminated:
+		p.mu.Lock()
+		spawned := p.spawned[msg.Unit]
+		if spawned {
+			p.removeWorker(msg.Unit)
+		}
+		p.mu.Unlock()
+		if spawned {
+			return nil
+		}
+	}
+
+	env := envelope{from: from, msg: message}
+	if c, ok := ctx.(*registryCtx); ok {
+		env.span = c.span
+		if c.reg.tracer != nil {
+			env.sent = time.Now()
+		}
+	}
+
+	// A worker may stop between being picked and being sent to, so try
+	// others before giving up. Errors go to the sender rather than
+	// failing the pool. Forwarding may wait for room, so it is done
+	// without p.mu held.
+	err := ErrNoWorkers
+	for tries := 0; ; tries++ {
+		p.mu.Lock()
+		var w *unitRef
+		if tries < len(p.workers) {
+			w = p.pick(message)
+		}
+		p.mu.Unlock()
+		if w == nil {
+			break
+		}
+		if err = w.forward(env); err == nil {
+			return nil
+		}
+	}
+	rejectPending(env, fmt.Errorf("pool %s: %w", p.name, err))
+	return nil
+}
+
+// Terminate stops the workers along with the pool.
+func (p *pool) Terminate(ctx Ctx, reason error) {
+	p.mu.Lock()
+	workers := p.workers
+	p.workers = nil
+	p.ring = nil
+	p.mu.Unlock()
+
+	p.metrics.Forget("pool", p.name)
+	for _, w := range workers {
+		ctx.Unwatch(w)
+		w.Stop()
+	}
+}
+
+// Describe describes the pool's workers.
+func (p *pool) Describe() Description {
+	if d, ok := p.proto.Load().(Describer); ok {
+		return d.Describe()
+	}
+	return Description{Summary: "Pool of workers"}
+}
+
+// Workers returns the names of the pool's workers.
+func (p *pool) Workers() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	names := make([]string, len(p.workers))
+	for i, w := range p.workers {
+		names[i] = w.name
+	}
+	return names
+}
+
+// pick chooses a running worker for msg, or returns nil if there are
+// none. The caller must hold p.mu.
+func (p *pool) pick(msg any) *unitRef {
+	switch p.cfg.strategy {
+	case LeastBusy:
+		// Start from the next worker in turn so that ties are shared.
+		var best *unitRef
+		bestLoad := 0
+		for i := range p.workers {
+			w := p.workers[(p.next+i)%len(p.workers)]
+			if load := w.load(); !w.closed.Load() && (best == nil || load < bestLoad) {
+				best, bestLoad = w, load
+			}
+		}
+		p.next++
+		return best
+	case ConsistentHash:
+		if len(p.ring) == 0 {
+			return nil
+		}
+		h := hashKey(p.cfg.key(msg))
+		i := sort.Search(len(p.ring), func(i int) bool { return p.ring[i].hash >= h })
+		// Keys of stopped workers move on around the ring.
+		for range p.ring {
+			if w := p.ring[i%len(p.ring)].worker; !w.closed.Load() {
+				return w
+			}
+			i++
+		}
+		return nil
+	default:
+		for range p.workers {
+			w := p.workers[p.next%len(p.workers)]
+			p.next++
+			if !w.closed.Load() {
+				return w
+			}
+		}
+		return nil
+	}
+}
+
+// scale replaces workers that have stopped and, when autoscaling, adds a
+// worker while messages queue up or retires one while all are idle. The
+// caller must hold p.mu.
+func (p *pool) scale(ctx Ctx) {
+	if len(p.workers) < p.cfg.min {
+		for len(p.workers) < p.cfg.min {
+			p.addWorker(ctx)
+		}
+		return
+	}
+
+	var queued, load int
+	for _, w := range p.workers {
+		queued += w.mailbox.len()
+		load += w.load()
+	}
+	switch {
+	case len(p.workers) < p.cfg.max && queued >= scaleUpDepth*len(p.workers):
+		p.addWorker(ctx)
+	case len(p.workers) > p.cfg.min && load == 0:
+		w := p.workers[len(p.workers)-1]
+		p.removeWorker(w.name)
+		ctx.Unwatch(w)
+		w.Stop()
+	}
+}
+
+// addWorker spawns a worker under the next free name. Units that already
+// have a name are skipped rather than taken over as workers. The caller
+// must hold p.mu.
+func (p *pool) addWorker(ctx Ctx) {
+	var name string
+	var ref UnitRef
+	for {
+		p.seq++
+		name = fmt.Sprintf("%s-%d", p.name, p.seq)
+		// Spawn returns the existing unit for a name in use, without
+		// calling the factory.
+		var created atomic.Bool
+		ref = ctx.Spawn(name, func() Unit {
+			created.Store(true)
+			return p.factory()
+		}, p.opts...)
+		if created.Load() {
+			break
+		}
+	}
+	p.spawned[name] = true
+
+	w, ok := ref.(*unitRef)
+	if !ok {
+		return
+	}
+	if p.proto.Load() == nil {
+		w.reg.mu.RLock()
+		p.proto.Store(w.reg.units[name])
+		w.reg.mu.RUnlock()
+	}
+	ctx.Watch(w)
+	p.workers = append(p.workers, w)
+	p.rebuild()
+}
+
+// removeWorker forgets the named worker. The caller must hold p.mu.
+func (p *pool) removeWorker(name string) {
+	for i, w := range p.workers {
+		if w.name == name {
+			p.workers = append(p.workers[:i], p.workers[i+1:]...)
+			p.rebuild()
+			return
+		}
+	}
+}
+
+// rebuild updates the hash ring and size gauge after the workers change.
+func (p *pool) rebuild() {
+	p.size.Set(float64(len(p.workers)))
+	if p.cfg.strategy != ConsistentHash {
+		return
+	}
+	p.ring = p.ring[:0]
+	for _, w := range p.workers {
+		for i := 0; i < ringReplicas; i++ {
+			p.ring = append(p.ring, ringPoint{hash: hashKey(fmt.Sprintf("%s#%d", w.name, i)), worker: w})
+		}
+	}
+	sort.Slice(p.ring, func(i, j int) bool { return p.ring[i].hash < p.ring[j].hash })
+}
+
+func hashKey(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32()
+}
+
+func defaultPoolKey(msg any) string {
+	if m, ok := msg.(map[string]any); ok {
+		if key, ok := m["key"]; ok {
+			return fmt.Sprint(key)
+		}
+	}
+	return fmt.Sprint(msg)
+}
+
+// forward queues env for the worker within the worker's limits.
+func (r *unitRef) forward(env envelope) error {
+	release, err := r.admit(env.from)
+	if err != nil {
+		return err
+	}
+	env.release = release
+	if err := r.enqueue(env); err != nil {
+		env.done()
+		return err
+	}
+	return nil
+}
+
+// load is how many messages the unit has queued or is handling.
+func (r *unitRef) load() int {
+	n := r.mailbox.len()
+	if r.handling.Load() {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,251 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// whoUnit replies with its own name, after release is closed if set.
+type whoUnit struct {
+	release chan struct{}
+}
+
+func (w *whoUnit) Init(ctx Ctx) {}
+
+func (w *whoUnit) Handle(ctx Ctx, from UnitRef, message any) error {
+	if message == "fail" {
+		return errors.New("cannot handle fail")
+	}
+	if w.release != nil {
+		<-w.release
+	}
+	return from.Send(ctx.Self().Name())
+}
+
+func startPool(t *testing.T, registry *Registry, size int, f UnitFactory, opts ...Option) *pool {
+	t.Helper()
+	registry.RegisterPool("pool", f, size, opts...)
+	if err := registry.Start(); err != nil {
+		t.Fatalf("Failed to start registry: %v", err)
+	}
+	t.Cleanup(registry.Stop)
+	return registry.units["pool"].(*pool)
+}
+
+func askPool(t *testing.T, registry *Registry, msg any) string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	reply, err := registry.Ask(ctx, "pool", msg)
+	if err != nil {
+		t.Fatalf("Ask(%v) failed: %v", msg, err)
+	}
+	return reply.(string)
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timeout waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func poolLoad(p *pool) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var load int
+	for _, w := range p.workers {
+		load += w.load()
+	}
+	return load
+}
+
+func TestPoolRoundRobin(t *testing.T) {
+	registry := NewRegistry()
+	p := startPool(t, registry, 3, func() Unit { return &whoUnit{} })
+
+	if got := p.Workers(); len(got) != 3 || got[0] != "pool-1" || got[2] != "pool-3" {
+		t.Fatalf("Unexpected workers: %v", got)
+	}
+	counts := map[string]int{}
+	for i := 0; i < 6; i++ {
+		counts[askPool(t, registry, i)]++
+	}
+	for _, name := range p.Workers() {
+		if counts[name] != 2 {
+			t.Errorf("Expected 2 messages for %s, got %v", name, counts)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := registry.Ask(ctx, "pool", "fail"); err == nil || err.Error() != "cannot handle fail" {
+		t.Errorf("Expected the worker's error, got %v", err)
+	}
+}
+
+func TestPoolConsistentHash(t *testing.T) {
+	registry := NewRegistry()
+	startPool(t, registry, 4, func() Unit { return &whoUnit{} }, WithPoolStrategy(ConsistentHash))
+
+	owners := map[string]string{}
+	workers := map[string]bool{}
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("image-%d", i)
+		owners[key] = askPool(t, registry, map[string]any{"key": key})
+		workers[owners[key]] = true
+	}
+	for key, owner := range owners {
+		if got := askPool(t, registry, map[string]any{"key": key, "retry": true}); got != owner {
+			t.Errorf("Expected %s to go to %s again, got %s", key, owner, got)
+		}
+	}
+	if len(workers) < 2 {
+		t.Errorf("Expected keys to spread across workers, got %v", workers)
+	}
+}
+
+func TestPoolLeastBusy(t *testing.T) {
+	registry := NewRegistry()
+	release := make(chan struct{})
+	p := startPool(t, registry, 3, func() Unit { return &whoUnit{release: release} }, WithPoolStrategy(LeastBusy))
+
+	replies := make(chan any, 3)
+	pool := registry.getRef("pool")
+	for i := 0; i < 3; i++ {
+		if err := pool.deliver(&bridgeRef{replies: replies}, i); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+		// Let the worker pick the message up before sending the next.
+		waitFor(t, "a busy worker", func() bool { return poolLoad(p) == i+1 })
+	}
+	close(release)
+
+	seen := map[any]bool{}
+	for i := 0; i < 3; i++ {
+		select {
+		case name := <-replies:
+			seen[name] = true
+		case <-time.After(time.Second):
+			t.Fatal("Timeout waiting for replies")
+		}
+	}
+	if len(seen) != 3 {
+		t.Errorf("Expected each worker to get one message, got %v", seen)
+	}
+}
+
+func TestPoolAutoscale(t *testing.T) {
+	clock := &fakeClock{}
+	registry := NewRegistry(WithClock(clock))
+	release := make(chan struct{})
+	p := startPool(t, registry, 1, func() Unit { return &whoUnit{release: release} }, Autoscale(1, 3))
+
+	pool := registry.getRef("pool")
+	replies := make(chan any, 16)
+	for i := 0; i < 8; i++ {
+		pool.deliver(&bridgeRef{replies: replies}, i)
+	}
+	for want := 2; want <= 3; want++ {
+		clock.Advance(DefaultPoolInterval)
+		waitFor(t, fmt.Sprintf("%d workers", want), func() bool { return len(p.Workers()) == want })
+	}
+	clock.Advance(DefaultPoolInterval)
+	time.Sleep(10 * time.Millisecond)
+	if n := len(p.Workers()); n != 3 {
+		t.Errorf("Expected the pool to stop at 3 workers, got %d", n)
+	}
+
+	close(release)
+	for i := 0; i < 8; i++ {
+		<-replies
+	}
+	for want := 2; want >= 1; want-- {
+		waitFor(t, "idle workers", func() bool { return poolLoad(p) == 0 })
+		clock.Advance(DefaultPoolInterval)
+		waitFor(t, fmt.Sprintf("%d workers", want), func() bool { return len(p.Workers()) == want })
+	}
+	waitFor(t, "retired workers to stop", func() bool {
+		return registry.getRef("pool-2") == nil && registry.getRef("pool-3") == nil
+	})
+}
+
+func TestPoolReplacesAndStopsWorkers(t *testing.T) {
+	clock := &fakeClock{}
+	registry := NewRegistry(WithClock(clock))
+	p := startPool(t, registry, 2, func() Unit { return &whoUnit{} })
+
+	registry.getRef("pool-1").Stop()
+	waitFor(t, "the stopped worker to leave", func() bool { return len(p.Workers()) == 1 })
+	clock.Advance(DefaultPoolInterval)
+	waitFor(t, "a replacement", func() bool { return len(p.Workers()) == 2 })
+	if got := p.Workers(); got[0] != "pool-2" || got[1] != "pool-3" {
+		t.Errorf("Unexpected workers: %v", got)
+	}
+	if got := askPool(t, registry, "hi"); got != "pool-2" && got != "pool-3" {
+		t.Errorf("Unexpected reply from %s", got)
+	}
+
+	if err := registry.Unregister("pool"); err != nil {
+		t.Fatalf("Unregister failed: %v", err)
+	}
+	waitFor(t, "the workers to stop", func() bool {
+		return registry.getRef("pool-2") == nil && registry.getRef("pool-3") == nil
+	})
+	var b strings.Builder
+	registry.Metrics().WriteText(&b)
+	if strings.Contains(b.String(), `pool="pool"`) {
+		t.Errorf("Expected the pool's series to be removed:\n%s", b.String())
+	}
+}
+
+func TestPoolSkipsNamesInUse(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register("pool-1", &silentUnit{})
+	p := startPool(t, registry, 2, func() Unit { return &whoUnit{} })
+
+	if got := p.Workers(); len(got) != 2 || got[0] != "pool-2" || got[1] != "pool-3" {
+		t.Fatalf("Expected pool-1 to be skipped, got %v", got)
+	}
+	for i := 0; i < 2; i++ {
+		if got := askPool(t, registry, i); got == "pool-1" {
+			t.Errorf("Expected the unrelated pool-1 not to get messages")
+		}
+	}
+}
+
+func TestPoolWaitsForRoomUnlocked(t *testing.T) {
+	registry := NewRegistry()
+	release := make(chan struct{})
+	p := startPool(t, registry, 1, func() Unit { return &whoUnit{release: release} }, WithMailboxSize(1))
+	defer close(release)
+
+	// The first message is being handled and the second fills the
+	// worker's mailbox, so the pool waits to forward the third.
+	ref := registry.getRef("pool")
+	for i := 0; i < 3; i++ {
+		ref.Send(i)
+	}
+	waitFor(t, "the pool to wait for room", func() bool {
+		return ref.handling.Load() && ref.mailbox.len() == 0
+	})
+
+	workers := make(chan []string, 1)
+	go func() { workers <- p.Workers() }()
+	select {
+	case got := <-workers:
+		if len(got) != 1 {
+			t.Errorf("Unexpected workers: %v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the pool not to hold its lock while waiting")
+	}
+}
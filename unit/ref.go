@@ -0,0 +1,47 @@
+package unit
+
+import "errors"
+
+var (
+	ErrNoSender    = errors.New("message has no sender")
+	ErrUnknownUnit = errors.New("unknown unit")
+)
+
+// Rejecter is implemented by senders that want to know when the message
+// they sent could not be handled, such as the sender of an Ask.
+type Rejecter interface {
+	Reject(err error)
+}
+
+// NoSender is passed to Handle as the sender of messages that did not
+// originate from a unit, such as those sent directly on a UnitRef.
+var NoSender UnitRef = noSender{}
+
+type noSender struct{}
+
+func (noSender) Name() string       { return "" }
+func (noSender) Send(msg any) error { return ErrNoSender }
+func (noSender) Stop()              {}
+
+// boundRef is the sender a unit sees in Handle. Sending on it delivers to
+// target with sender as the origin, so the reply's receiver can in turn
+// answer the unit that replied.
+type boundRef struct {
+	target *unitRef
+	sender *unitRef
+	// span is that of the message the sender is handling, so replies
+	// stay in its trace.
+	span SpanContext
+}
+
+func (b *boundRef) Name() string {
+	return b.target.name
+}
+
+func (b *boundRef) Send(msg any) error {
+	return b.target.deliverTraced(b.sender.from(b.target), msg, b.span)
+}
+
+func (b *boundRef) Stop() {
+	b.target.Stop()
+}
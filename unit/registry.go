@@ -2,65 +2,198 @@ package unit
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eliothedeman/smol/metrics"
 )
 
 type Registry struct {
 	units         map[string]Unit
 	refs          map[string]*unitRef
 	subscriptions map[string]map[string]struct{}
+	watchers      map[string]map[string]struct{}
+	topics        map[string]map[string]struct{}
+	order         []string
 	mu            sync.RWMutex
 	ctx           context.Context
 	cancel        context.CancelFunc
+	dispatchers   sync.WaitGroup
+	root          *Supervisor
+	stopping      atomic.Bool
+	tracer        Tracer
+	metrics       *metrics.Registry
+	unitCount     *metrics.Gauge
+	memory        memoryConfig
+	breachMu      sync.Mutex
+	breaches      map[string]time.Time
+	journal       *Journal
+	replaying     atomic.Bool
+	clock         Clock
 }
 
 type unitRef struct {
-	name   string
-	reg    *Registry
-	closed bool
+	name       string
+	reg        *Registry
+	mailbox    *mailbox
+	factory    UnitFactory
+	supervisor *Supervisor
+	dependsOn  []string
+	closed     atomic.Bool
+	done       chan struct{}
+	metrics    *unitMetrics
+	budget     uint64
+	priority   Priority
+	evicted    atomic.Bool
+	durable    bool
+	// handling is set while the unit is handling a message.
+	handling atomic.Bool
+	limiter  *limiter
+
+	mu      sync.Mutex
+	started bool
+	reason  error
+	timers  map[*Timer]struct{}
 }
 
 type registryCtx struct {
 	context.Context
 	reg  *Registry
 	self *unitRef
+	// span is that of the message being handled, if any.
+	span SpanContext
 }
 
-func NewRegistry() *Registry {
+func NewRegistry(opts ...RegistryOption) *Registry {
 	ctx, cancel := context.WithCancel(context.Background())
-	return &Registry{
+	r := &Registry{
 		units:         make(map[string]Unit),
 		refs:          make(map[string]*unitRef),
 		subscriptions: make(map[string]map[string]struct{}),
+		watchers:      make(map[string]map[string]struct{}),
+		topics:        make(map[string]map[string]struct{}),
+		breaches:      make(map[string]time.Time),
 		ctx:           ctx,
 		cancel:        cancel,
+		root:          NewSupervisor("root", OneForOne),
+		metrics:       metrics.NewRegistry(),
+		clock:         systemClock{},
+	}
+	for _, opt := range opts {
+		opt(r)
 	}
+	r.unitCount = r.metrics.Gauge("smol_units", "Units in the registry.", nil)
+	return r
 }
 
-func (r *Registry) Register(name string, unit Unit) {
+func (r *Registry) Register(name string, unit Unit, opts ...Option) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	ref := &unitRef{
-		name: name,
-		reg:  r,
-	}
+	r.add(name, unit, r.newRef(name, nil, opts))
+}
 
+// add records the unit under name, keeping track of the order units were
+// added in. The caller must hold r.mu.
+func (r *Registry) add(name string, unit Unit, ref *unitRef) {
+	if _, exists := r.refs[name]; !exists {
+		r.order = append(r.order, name)
+	}
 	r.units[name] = unit
 	r.refs[name] = ref
+	r.unitCount.Set(float64(len(r.refs)))
 }
 
+func (r *Registry) newRef(name string, factory UnitFactory, opts []Option) *unitRef {
+	cfg := newUnitConfig(opts)
+	if cfg.supervisor == nil {
+		cfg.supervisor = r.root
+	}
+
+	ref := &unitRef{
+		name:       name,
+		reg:        r,
+		mailbox:    newMailbox(cfg.mailboxSize, cfg.overflow),
+		factory:    factory,
+		supervisor: cfg.supervisor,
+		dependsOn:  cfg.dependsOn,
+		done:       make(chan struct{}),
+		budget:     cfg.budget,
+		priority:   cfg.priority,
+		durable:    cfg.durable,
+	}
+	ref.mailbox.dropped = ref.dropped
+	ref.metrics = r.newUnitMetrics(ref)
+	if cfg.limits.enabled() {
+		ref.limiter = newLimiter(ref, cfg.limits)
+	}
+	cfg.supervisor.adopt(r, ref)
+	return ref
+}
+
+// Start initializes every registered unit after the units it depends on,
+// then starts delivering their messages. It refuses to start anything if a
+// dependency is missing or the dependencies form a cycle. If an Init
+// fails, the units not yet started are removed and those already started
+// are stopped again, dependents first.
 func (r *Registry) Start() error {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
+	r.mu.Lock()
+	deps := make(map[string][]string, len(r.order))
+	for _, name := range r.order {
+		deps[name] = dependenciesOf(r.units[name], r.refs[name])
+	}
+	order, err := startOrder(r.order, deps)
+	if err != nil {
+		r.mu.Unlock()
+		return err
+	}
+	// Shutdown stops units in reverse of this order.
+	r.order = order
+
+	units := make(map[string]Unit, len(order))
+	refs := make(map[string]*unitRef, len(order))
+	for _, name := range order {
+		units[name] = r.units[name]
+		refs[name] = r.refs[name]
+	}
+	r.mu.Unlock()
 
-	for name, unit := range r.units {
+	// Init runs without the lock held so units can subscribe, spawn or
+	// look each other up while initializing.
+	for i, name := range order {
+		unit := units[name]
 		ctx := &registryCtx{
 			Context: r.ctx,
 			reg:     r,
-			self:    r.refs[name],
+			self:    refs[name],
+		}
+		if err := safeInit(unit, ctx); err != nil {
+			err = fmt.Errorf("init %s: %w", name, err)
+			// Remove this unit and those not yet started, then stop the
+			// units already started, dependents first.
+			for _, rest := range order[i:] {
+				refs[rest].stop(err)
+			}
+			for j := i - 1; j >= 0; j-- {
+				ref := refs[order[j]]
+				ref.stop(err)
+				<-ref.done
+			}
+			return err
 		}
-		unit.Init(ctx)
+		r.dispatch(ctx, unit)
+	}
+
+	if r.journal != nil {
+		r.redeliver()
+	}
+	if r.memory.limit > 0 {
+		r.monitorMemory()
 	}
 	return nil
 }
@@ -74,14 +207,136 @@ func (r *Registry) Stop() {
 	for name := range r.subscriptions {
 		delete(r.subscriptions, name)
 	}
+	for _, ref := range r.refs {
+		ref.mailbox.close()
+	}
 }
 
-func (r *Registry) getUnit(name string) (Unit, bool) {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
+// dispatch starts the goroutine that feeds the unit's mailbox to Handle,
+// one message at a time and in the order they were sent. Failures are
+// handed to the unit's supervisor.
+func (r *Registry) dispatch(ctx *registryCtx, unit Unit) {
+	ref := ctx.self
+	if !ref.markStarted() {
+		return
+	}
+
+	r.dispatchers.Add(1)
+	go func() {
+		defer r.dispatchers.Done()
+		for {
+			env, ok := ref.mailbox.pop()
+			if !ok {
+				if ref.closed.Load() {
+					r.finish(ctx, unit, true)
+				}
+				return
+			}
+
+			switch sig := env.msg.(type) {
+			case restartSignal:
+				unit = r.restart(ctx, unit)
+				continue
+			case evictSignal:
+				if evicter, ok := unit.(Evicter); ok {
+					safeEvict(evicter, ctx)
+				}
+				continue
+			case stopSignal:
+				ref.markStopped(sig.reason)
+				for _, env := range ref.mailbox.drain() {
+					env.done()
+					rejectPending(env, ErrUnitStopped)
+				}
+				r.finish(ctx, unit, true)
+				return
+			}
 
-	unit, exists := r.units[name]
-	return unit, exists
+			ref.handling.Store(true)
+			err := r.handle(ctx, unit, env)
+			ref.handling.Store(false)
+			env.done()
+			if env.seq != 0 {
+				r.journal.ack(env.seq)
+			}
+			if env.handled != nil {
+				close(env.handled)
+			}
+			if err != nil {
+				rejectPending(env, err)
+				ref.supervisor.childFailed(ref, err)
+			}
+		}
+	}()
+}
+
+// restart re-initializes the unit, replacing it with a fresh instance
+// when it was created from a factory.
+func (r *Registry) restart(ctx *registryCtx, unit Unit) Unit {
+	ref := ctx.self
+	ref.metrics.restarts.Inc()
+	ref.stopTimers()
+	if ref.factory != nil {
+		unit = ref.factory()
+		r.mu.Lock()
+		r.units[ref.name] = unit
+		r.mu.Unlock()
+	}
+
+	if err := safeInit(unit, ctx); err != nil {
+		ref.supervisor.childFailed(ref, err)
+	}
+	return unit
+}
+
+// rejectPending tells the sender of a message that could not be handled,
+// failing the future of an Ask.
+func rejectPending(env envelope, err error) {
+	if r, ok := env.from.(Rejecter); ok {
+		r.Reject(err)
+	}
+}
+
+// dropped finishes a message the mailbox dropped to make room, as the
+// dispatcher finishes one it has handled: an Ask for it fails, Replay
+// stops waiting for it and a durable unit's journal entry is acknowledged,
+// since it will never be handled.
+func (r *unitRef) dropped(env envelope) {
+	r.metrics.rejected.Inc()
+	env.done()
+	if env.seq != 0 {
+		r.reg.journal.ack(env.seq)
+	}
+	if env.handled != nil {
+		close(env.handled)
+	}
+	rejectPending(env, fmt.Errorf("%w: %s", ErrMailboxFull, r.name))
+}
+
+// Ask sends msg to the named unit and waits for its reply until ctx is done.
+func (r *Registry) Ask(ctx context.Context, name string, msg any) (any, error) {
+	target := r.getRef(name)
+	if target == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownUnit, name)
+	}
+	return await(ctx, askVia("", target, msg, SpanContext{}))
+}
+
+// Deliver sends msg to the named unit as if it came from from, so that the
+// unit's replies are sent on from. It is meant for bridges such as network
+// transports; units should use Ctx.Send.
+func (r *Registry) Deliver(name string, from UnitRef, msg any) error {
+	if r.stopping.Load() {
+		return ErrShuttingDown
+	}
+	target := r.getRef(name)
+	if target == nil {
+		return fmt.Errorf("%w: %s", ErrUnknownUnit, name)
+	}
+	if from == nil {
+		from = NoSender
+	}
+	return target.deliver(from, msg)
 }
 
 func (r *Registry) getRef(name string) *unitRef {
@@ -95,47 +350,95 @@ func (r *unitRef) Name() string {
 	return r.name
 }
 
-func (r *unitRef) Send(msg any) {
-	if r.closed {
-		return
+// Send delivers msg without a sender; replies to it are discarded.
+// Units should use Ctx.Send so the receiver can answer them.
+func (r *unitRef) Send(msg any) error {
+	if r.reg.stopping.Load() {
+		return ErrShuttingDown
 	}
+	return r.deliver(NoSender, msg)
+}
 
-	unit, exists := r.reg.getUnit(r.name)
-	if !exists {
-		return
+func (r *unitRef) deliver(from UnitRef, msg any) error {
+	return r.deliverTraced(from, msg, SpanContext{})
+}
+
+// deliverTraced queues msg as part of the trace of span, the span of the
+// message the sender was handling when it sent msg.
+func (r *unitRef) deliverTraced(from UnitRef, msg any, span SpanContext) error {
+	if r.closed.Load() {
+		return ErrUnitStopped
+	}
+	if r.reg.replaying.Load() {
+		return ErrReplaying
 	}
 
-	ctx := &registryCtx{
-		Context: r.reg.ctx,
-		reg:     r.reg,
-		self:    r,
+	release, err := r.admit(from)
+	if err != nil {
+		return err
+	}
+	env := envelope{from: from, msg: msg, span: span, release: release}
+	if r.reg.tracer != nil {
+		env.sent = time.Now()
+	}
+	if j := r.reg.journal; j != nil {
+		seq, err := j.record(r.name, from, msg, r.durable)
+		if err != nil {
+			if r.durable {
+				env.done()
+				return err
+			}
+			log.Printf("%v", err)
+		}
+		if r.durable {
+			env.seq = seq
+		}
 	}
 
-	go unit.Handle(ctx, r, msg)
+	err = r.enqueue(env)
+	if err != nil {
+		env.done()
+		if env.seq != 0 {
+			r.reg.journal.ack(env.seq)
+		}
+	}
+	return err
+}
+
+// enqueue queues env for the unit and copies it to the unit's subscribers.
+func (r *unitRef) enqueue(env envelope) error {
+	if err := r.mailbox.push(env); err != nil {
+		if errors.Is(err, ErrMailboxFull) {
+			r.metrics.rejected.Inc()
+		}
+		return err
+	}
+	env.seq = 0
+	env.handled = nil
+	env.release = nil
 
 	r.reg.mu.RLock()
-	subscribers := r.reg.subscriptions[r.name]
+	var subscribers []*unitRef
+	for subscriberName := range r.reg.subscriptions[r.name] {
+		if subRef := r.reg.refs[subscriberName]; subRef != nil {
+			subscribers = append(subscribers, subRef)
+		}
+	}
 	r.reg.mu.RUnlock()
 
-	for subscriberName := range subscribers {
-		subUnit, exists := r.reg.getUnit(subscriberName)
-		if !exists {
-			continue
-		}
-		subRef := r.reg.getRef(subscriberName)
-		if subRef != nil && !subRef.closed {
-			subCtx := &registryCtx{
-				Context: r.reg.ctx,
-				reg:     r.reg,
-				self:    subRef,
-			}
-			go subUnit.Handle(subCtx, r, msg)
+	for _, subRef := range subscribers {
+		if !subRef.closed.Load() {
+			env.from = r.from(subRef)
+			subRef.mailbox.push(env)
 		}
 	}
+	return nil
 }
 
-func (r *unitRef) Stop() {
-	r.closed = true
+// from returns the ref a message sent by r is seen to come from when
+// delivered to receiver, so that replies made through it reach r.
+func (r *unitRef) from(receiver *unitRef) UnitRef {
+	return &boundRef{target: r, sender: receiver}
 }
 
 func (c *registryCtx) Units() []UnitDesc {
@@ -143,31 +446,33 @@ func (c *registryCtx) Units() []UnitDesc {
 	defer c.reg.mu.RUnlock()
 
 	var units []UnitDesc
-	for name, unit := range c.reg.units {
-		units = append(units, UnitDesc{
+	for _, name := range c.reg.order {
+		unit := c.reg.units[name]
+		desc := UnitDesc{
 			Name:  name,
 			Proxy: unit,
-		})
+			Ref:   c.reg.refs[name],
+		}
+		if d, ok := unit.(Describer); ok {
+			description := d.Describe()
+			desc.Description = &description
+		}
+		units = append(units, desc)
 	}
 	return units
 }
 
-func (c *registryCtx) Spawn(name string, f UnitFactory) UnitRef {
+func (c *registryCtx) Spawn(name string, f UnitFactory, opts ...Option) UnitRef {
 	c.reg.mu.Lock()
-	defer c.reg.mu.Unlock()
-
-	if _, exists := c.reg.units[name]; exists {
-		return c.reg.refs[name]
+	if ref, exists := c.reg.refs[name]; exists {
+		c.reg.mu.Unlock()
+		return ref
 	}
 
 	unit := f()
-	ref := &unitRef{
-		name: name,
-		reg:  c.reg,
-	}
-
-	c.reg.units[name] = unit
-	c.reg.refs[name] = ref
+	ref := c.reg.newRef(name, f, opts)
+	c.reg.add(name, unit, ref)
+	c.reg.mu.Unlock()
 
 	ctx := &registryCtx{
 		Context: c.reg.ctx,
@@ -175,7 +480,13 @@ func (c *registryCtx) Spawn(name string, f UnitFactory) UnitRef {
 		self:    ref,
 	}
 
-	unit.Init(ctx)
+	if err := safeInit(unit, ctx); err != nil {
+		err = fmt.Errorf("init %s: %w", name, err)
+		ref.supervisor.report(Failure{Supervisor: ref.supervisor.name, Unit: name, Err: err, Time: time.Now()})
+		ref.stop(err)
+		return ref
+	}
+	c.reg.dispatch(ctx, unit)
 	return ref
 }
 
@@ -183,19 +494,43 @@ func (c *registryCtx) Self() UnitRef {
 	return c.self
 }
 
+// Send delivers msg to a registered unit with this unit as the sender.
+// Other refs, such as the sender of an Ask or a network peer, are sent to
+// directly.
+func (c *registryCtx) Send(to UnitRef, msg any) error {
+	switch to.(type) {
+	case *unitRef, *boundRef:
+	default:
+		return to.Send(msg)
+	}
+	target := c.reg.getRef(to.Name())
+	if target == nil {
+		return fmt.Errorf("%w: %s", ErrUnknownUnit, to.Name())
+	}
+	return target.deliverTraced(c.self.from(target), msg, c.span)
+}
+
+func (c *registryCtx) Ask(to UnitRef, msg any) (any, error) {
+	return await(c, c.AskAsync(to, msg))
+}
+
+func (c *registryCtx) AskAsync(to UnitRef, msg any) *Future {
+	target := c.reg.getRef(to.Name())
+	if target == nil {
+		return Resolved(nil, fmt.Errorf("%w: %s", ErrUnknownUnit, to.Name()))
+	}
+	return askVia(c.self.name, target, msg, c.span)
+}
+
+// Subscribe delivers a copy of every message sent to other to this unit
+// as well. Prefer SubscribeTopic, which does not tie the subscriber to the
+// publisher's identity.
 func (c *registryCtx) Subscribe(other Unit) {
 	c.reg.mu.Lock()
 	defer c.reg.mu.Unlock()
 
-	otherName := ""
-	for name, unit := range c.reg.units {
-		if unit == other {
-			otherName = name
-			break
-		}
-	}
-
-	if otherName == "" {
+	otherName, ok := c.reg.nameOf(other)
+	if !ok {
 		return
 	}
 
@@ -210,23 +545,30 @@ func (c *registryCtx) Unsubscribe(other Unit) {
 	c.reg.mu.Lock()
 	defer c.reg.mu.Unlock()
 
-	otherName := ""
-	for name, unit := range c.reg.units {
-		if unit == other {
-			otherName = name
-			break
-		}
-	}
-
-	if otherName == "" {
+	otherName, ok := c.reg.nameOf(other)
+	if !ok {
 		return
 	}
 
 	selfName := c.self.name
-	if c.reg.subscriptions[selfName] != nil {
-		delete(c.reg.subscriptions[selfName], otherName)
-		if len(c.reg.subscriptions[selfName]) == 0 {
-			delete(c.reg.subscriptions, selfName)
+	if c.reg.subscriptions[otherName] != nil {
+		delete(c.reg.subscriptions[otherName], selfName)
+		if len(c.reg.subscriptions[otherName]) == 0 {
+			delete(c.reg.subscriptions, otherName)
+		}
+	}
+}
+
+// nameOf finds the name other is registered under. The caller must hold
+// r.mu.
+func (r *Registry) nameOf(other Unit) (string, bool) {
+	if other == nil || !reflect.TypeOf(other).Comparable() {
+		return "", false
+	}
+	for name, unit := range r.units {
+		if unit == other {
+			return name, true
 		}
 	}
+	return "", false
 }
@@ -0,0 +1,85 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrShutdown     = errors.New("registry shut down")
+	ErrShuttingDown = errors.New("registry is shutting down")
+)
+
+// ShutdownError lists the units that had not stopped when the shutdown
+// deadline passed.
+type ShutdownError struct {
+	Units []string
+	Err   error
+}
+
+func (e *ShutdownError) Error() string {
+	return fmt.Sprintf("units did not stop in time: %s: %v", strings.Join(e.Units, ", "), e.Err)
+}
+
+func (e *ShutdownError) Unwrap() error {
+	return e.Err
+}
+
+// Shutdown stops the registry in an orderly way. Sends from outside the
+// registry are refused from the start, while units may keep messaging each
+// other as they wind down. Units are then stopped one at a time in the
+// reverse of the order they were started: each drains its mailbox and runs
+// its Terminate hook before the next one is asked to stop. If ctx ends
+// first, the remaining units are told to stop without waiting and are
+// reported in a *ShutdownError.
+func (r *Registry) Shutdown(ctx context.Context) error {
+	r.stopping.Store(true)
+	defer r.cancel()
+
+	var missed []string
+	seen := make(map[*unitRef]bool)
+	// Units spawned while shutting down are picked up by another pass.
+	for {
+		refs := r.pendingShutdown(seen)
+		if len(refs) == 0 {
+			break
+		}
+
+		for _, ref := range refs {
+			seen[ref] = true
+			ref.stop(ErrShutdown)
+			if len(missed) > 0 {
+				missed = append(missed, ref.name)
+				continue
+			}
+
+			select {
+			case <-ref.done:
+			case <-ctx.Done():
+				missed = append(missed, ref.name)
+			}
+		}
+	}
+
+	if len(missed) > 0 {
+		return &ShutdownError{Units: missed, Err: ctx.Err()}
+	}
+	return nil
+}
+
+// pendingShutdown returns the units not yet in seen, most recently
+// started first.
+func (r *Registry) pendingShutdown(seen map[*unitRef]bool) []*unitRef {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var refs []*unitRef
+	for i := len(r.order) - 1; i >= 0; i-- {
+		if ref := r.refs[r.order[i]]; !seen[ref] {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
@@ -0,0 +1,116 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingStopper struct {
+	name  string
+	mu    *sync.Mutex
+	order *[]string
+	delay time.Duration
+}
+
+func (r *recordingStopper) Init(ctx Ctx) {}
+
+func (r *recordingStopper) Handle(ctx Ctx, from UnitRef, message any) error {
+	return nil
+}
+
+func (r *recordingStopper) Terminate(ctx Ctx, reason error) {
+	time.Sleep(r.delay)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	*r.order = append(*r.order, r.name)
+}
+
+func TestShutdownStopsInReverseOrder(t *testing.T) {
+	registry := NewRegistry()
+
+	var mu sync.Mutex
+	var order []string
+	for _, name := range []string{"storage", "registers", "executor"} {
+		registry.Register(name, &recordingStopper{name: name, mu: &mu, order: &order})
+	}
+	if err := registry.Start(); err != nil {
+		t.Fatalf("Failed to start registry: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := registry.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	want := []string{"executor", "registers", "storage"}
+	if len(order) != len(want) {
+		t.Fatalf("Expected Terminate order %v, got %v", want, order)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("Expected Terminate order %v, got %v", want, order)
+		}
+	}
+	if registry.ctx.Err() == nil {
+		t.Error("Expected registry context to be cancelled after shutdown")
+	}
+}
+
+func TestShutdownDrainsMailboxes(t *testing.T) {
+	registry := NewRegistry()
+	unit := newStoppable()
+	registry.Register("worker", unit)
+	if err := registry.Start(); err != nil {
+		t.Fatalf("Failed to start registry: %v", err)
+	}
+
+	ref := registry.getRef("worker")
+	for i := 0; i < 5; i++ {
+		ref.Send(i)
+	}
+
+	if err := registry.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if unit.handled.Load() != 5 {
+		t.Errorf("Expected 5 messages handled before shutdown, got %d", unit.handled.Load())
+	}
+	if reason := <-unit.terminated; !errors.Is(reason, ErrShutdown) {
+		t.Errorf("Expected ErrShutdown reason, got %v", reason)
+	}
+	if err := ref.Send("late"); !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("Expected ErrShuttingDown after shutdown, got %v", err)
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	registry := NewRegistry()
+
+	var mu sync.Mutex
+	var order []string
+	registry.Register("fast", &recordingStopper{name: "fast", mu: &mu, order: &order})
+	registry.Register("slow", &recordingStopper{name: "slow", mu: &mu, order: &order, delay: 200 * time.Millisecond})
+	registry.Register("last", &recordingStopper{name: "last", mu: &mu, order: &order})
+	if err := registry.Start(); err != nil {
+		t.Fatalf("Failed to start registry: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	err := registry.Shutdown(ctx)
+	var shutdownErr *ShutdownError
+	if !errors.As(err, &shutdownErr) {
+		t.Fatalf("Expected ShutdownError, got %v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected error to wrap DeadlineExceeded, got %v", err)
+	}
+	if len(shutdownErr.Units) != 2 || shutdownErr.Units[0] != "slow" || shutdownErr.Units[1] != "fast" {
+		t.Errorf("Expected [slow fast] to miss the deadline, got %v", shutdownErr.Units)
+	}
+}
@@ -0,0 +1,218 @@
+package unit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var ErrUnregistered = errors.New("unit unregistered")
+
+// Stopper is implemented by units that need to release resources when
+// they are removed from the registry. reason says why the unit stopped.
+type Stopper interface {
+	Terminate(ctx Ctx, reason error)
+}
+
+// Terminated is sent to every watcher of a unit once it has stopped.
+type Terminated struct {
+	Unit   string
+	Reason error
+}
+
+type terminatedJSON struct {
+	Unit   string
+	Reason string `json:",omitempty"`
+}
+
+// MarshalJSON writes Reason as its message, since errors have no JSON
+// form of their own.
+func (t Terminated) MarshalJSON() ([]byte, error) {
+	m := terminatedJSON{Unit: t.Unit}
+	if t.Reason != nil {
+		m.Reason = t.Reason.Error()
+	}
+	return json.Marshal(m)
+}
+
+func (t *Terminated) UnmarshalJSON(data []byte) error {
+	var m terminatedJSON
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	t.Unit = m.Unit
+	t.Reason = nil
+	if m.Reason != "" {
+		t.Reason = errors.New(m.Reason)
+	}
+	return nil
+}
+
+// Unregister stops the named unit and waits until it has handled the
+// messages already in its mailbox, run its Terminate hook and been removed
+// from the registry. It must not be called from the unit's own Handle;
+// use ctx.Self().Stop() there instead.
+func (r *Registry) Unregister(name string) error {
+	ref := r.getRef(name)
+	if ref == nil {
+		return fmt.Errorf("%w: %s", ErrUnknownUnit, name)
+	}
+
+	ref.stop(ErrUnregistered)
+	<-ref.done
+	return nil
+}
+
+// Stop asks the unit to stop once it has handled the messages already
+// queued. It returns immediately; watchers are told when it is done.
+func (r *unitRef) Stop() {
+	r.stop(ErrUnitStopped)
+}
+
+func (r *unitRef) stop(reason error) {
+	if !r.markStopped(reason) {
+		return
+	}
+	r.stopTimers()
+
+	r.mu.Lock()
+	started := r.started
+	r.mu.Unlock()
+
+	// Without a dispatcher, drain and clean up here.
+	if !started {
+		for _, env := range r.mailbox.drain() {
+			env.done()
+			rejectPending(env, ErrUnitStopped)
+		}
+		r.reg.finish(&registryCtx{Context: r.reg.ctx, reg: r.reg, self: r}, nil, false)
+	}
+}
+
+// markStopped closes the unit to new messages, recording why. It reports
+// whether this call was the one that stopped it.
+func (r *unitRef) markStopped(reason error) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.closed.CompareAndSwap(false, true) {
+		return false
+	}
+	r.reason = reason
+	r.mailbox.close()
+	return true
+}
+
+func (r *unitRef) markStarted() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed.Load() {
+		return false
+	}
+	r.started = true
+	return true
+}
+
+// finish runs the unit's Terminate hook, removes it from the registry and
+// its supervisor, and notifies its watchers.
+func (r *Registry) finish(ctx *registryCtx, unit Unit, initialized bool) {
+	ref := ctx.self
+	ref.stopTimers()
+
+	ref.mu.Lock()
+	reason := ref.reason
+	ref.mu.Unlock()
+
+	if stopper, ok := unit.(Stopper); ok && initialized {
+		safeTerminate(stopper, ctx, reason)
+	}
+
+	r.mu.Lock()
+	current := r.refs[ref.name] == ref
+	if current {
+		delete(r.refs, ref.name)
+		delete(r.units, ref.name)
+		for i, name := range r.order {
+			if name == ref.name {
+				r.order = append(r.order[:i], r.order[i+1:]...)
+				break
+			}
+		}
+		r.unitCount.Set(float64(len(r.refs)))
+	}
+	delete(r.subscriptions, ref.name)
+	for publisher, subscribers := range r.subscriptions {
+		delete(subscribers, ref.name)
+		if len(subscribers) == 0 {
+			delete(r.subscriptions, publisher)
+		}
+	}
+	for pattern := range r.topics {
+		r.unsubscribeTopic(pattern, ref.name)
+	}
+	var watchers []*unitRef
+	for watcher := range r.watchers[ref.name] {
+		if w := r.refs[watcher]; w != nil {
+			watchers = append(watchers, w)
+		}
+	}
+	delete(r.watchers, ref.name)
+	for target, set := range r.watchers {
+		delete(set, ref.name)
+		if len(set) == 0 {
+			delete(r.watchers, target)
+		}
+	}
+	r.mu.Unlock()
+
+	ref.supervisor.release(ref)
+	if current {
+		r.metrics.Forget("unit", ref.name)
+	}
+
+	for _, w := range watchers {
+		w.deliver(NoSender, Terminated{Unit: ref.name, Reason: reason})
+	}
+	close(ref.done)
+}
+
+// Watch arranges for a Terminated message when other stops. Watching a
+// unit that no longer exists reports it as terminated straight away.
+func (c *registryCtx) Watch(other UnitRef) {
+	name := other.Name()
+
+	c.reg.mu.Lock()
+	if _, exists := c.reg.refs[name]; !exists {
+		c.reg.mu.Unlock()
+		c.self.deliver(NoSender, Terminated{Unit: name, Reason: ErrUnknownUnit})
+		return
+	}
+	if c.reg.watchers[name] == nil {
+		c.reg.watchers[name] = make(map[string]struct{})
+	}
+	c.reg.watchers[name][c.self.name] = struct{}{}
+	c.reg.mu.Unlock()
+}
+
+func (c *registryCtx) Unwatch(other UnitRef) {
+	c.reg.mu.Lock()
+	defer c.reg.mu.Unlock()
+
+	name := other.Name()
+	if c.reg.watchers[name] != nil {
+		delete(c.reg.watchers[name], c.self.name)
+		if len(c.reg.watchers[name]) == 0 {
+			delete(c.reg.watchers, name)
+		}
+	}
+}
+
+// safeTerminate runs Terminate, ignoring a panic since the unit is going
+// away regardless.
+func safeTerminate(stopper Stopper, ctx Ctx, reason error) {
+	defer func() {
+		recover()
+	}()
+	stopper.Terminate(ctx, reason)
+}
@@ -0,0 +1,180 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type stoppableUnit struct {
+	handled    atomic.Int32
+	terminated chan error
+}
+
+func (s *stoppableUnit) Init(ctx Ctx) {}
+
+func (s *stoppableUnit) Handle(ctx Ctx, from UnitRef, message any) error {
+	if message == "stop" {
+		ctx.Self().Stop()
+		return nil
+	}
+	time.Sleep(time.Millisecond)
+	s.handled.Add(1)
+	return nil
+}
+
+func (s *stoppableUnit) Terminate(ctx Ctx, reason error) {
+	s.terminated <- reason
+}
+
+func newStoppable() *stoppableUnit {
+	return &stoppableUnit{terminated: make(chan error, 1)}
+}
+
+type watcherUnit struct {
+	target     string
+	terminated chan Terminated
+}
+
+func (w *watcherUnit) Init(ctx Ctx) {
+	for _, desc := range ctx.Units() {
+		if desc.Name == w.target {
+			ctx.Watch(desc.Ref)
+		}
+	}
+}
+
+func (w *watcherUnit) Handle(ctx Ctx, from UnitRef, message any) error {
+	if t, ok := message.(Terminated); ok {
+		w.terminated <- t
+	}
+	return nil
+}
+
+func TestUnregisterDrainsMailbox(t *testing.T) {
+	registry := NewRegistry()
+	unit := newStoppable()
+	registry.Register("worker", unit)
+	if err := registry.Start(); err != nil {
+		t.Fatalf("Failed to start registry: %v", err)
+	}
+	defer registry.Stop()
+
+	ref := registry.getRef("worker")
+	for i := 0; i < 10; i++ {
+		ref.Send(i)
+	}
+
+	if err := registry.Unregister("worker"); err != nil {
+		t.Fatalf("Unregister failed: %v", err)
+	}
+
+	if unit.handled.Load() != 10 {
+		t.Errorf("Expected all 10 queued messages to be handled, got %d", unit.handled.Load())
+	}
+	if reason := <-unit.terminated; !errors.Is(reason, ErrUnregistered) {
+		t.Errorf("Expected ErrUnregistered reason, got %v", reason)
+	}
+	if registry.getRef("worker") != nil {
+		t.Error("Expected unit to be removed from the registry")
+	}
+	if err := ref.Send("late"); !errors.Is(err, ErrUnitStopped) {
+		t.Errorf("Expected ErrUnitStopped after unregister, got %v", err)
+	}
+	if err := registry.Unregister("worker"); !errors.Is(err, ErrUnknownUnit) {
+		t.Errorf("Expected ErrUnknownUnit for second unregister, got %v", err)
+	}
+}
+
+func TestSelfStopNotifiesWatchers(t *testing.T) {
+	registry := NewRegistry()
+	unit := newStoppable()
+	watcher := &watcherUnit{target: "worker", terminated: make(chan Terminated, 1)}
+	registry.Register("worker", unit)
+	if err := registry.Start(); err != nil {
+		t.Fatalf("Failed to start registry: %v", err)
+	}
+	defer registry.Stop()
+
+	// Spawn the watcher after the worker so Init can find it.
+	ctx := &registryCtx{Context: context.Background(), reg: registry, self: registry.getRef("worker")}
+	ctx.Spawn("watcher", func() Unit { return watcher })
+
+	registry.getRef("worker").Send("stop")
+
+	select {
+	case term := <-watcher.terminated:
+		if term.Unit != "worker" || !errors.Is(term.Reason, ErrUnitStopped) {
+			t.Errorf("Unexpected termination notice: %+v", term)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for termination notice")
+	}
+}
+
+func TestUnregisterRemovesSubscriptions(t *testing.T) {
+	registry := NewRegistry()
+	publisher := &testUnit{}
+	subscriber := newStoppable()
+	registry.Register("publisher", publisher)
+	registry.Register("subscriber", subscriber)
+	if err := registry.Start(); err != nil {
+		t.Fatalf("Failed to start registry: %v", err)
+	}
+	defer registry.Stop()
+
+	ctx := &registryCtx{Context: context.Background(), reg: registry, self: registry.getRef("subscriber")}
+	ctx.Subscribe(publisher)
+
+	if err := registry.Unregister("subscriber"); err != nil {
+		t.Fatalf("Unregister failed: %v", err)
+	}
+
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	if len(registry.subscriptions) != 0 {
+		t.Errorf("Expected subscriptions to be cleared, got %v", registry.subscriptions)
+	}
+}
+
+func TestSpawnAfterUnregister(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register("spawner", &silentUnit{})
+	if err := registry.Start(); err != nil {
+		t.Fatalf("Failed to start registry: %v", err)
+	}
+	defer registry.Stop()
+
+	ctx := &registryCtx{Context: context.Background(), reg: registry, self: registry.getRef("spawner")}
+	first := ctx.Spawn("child", func() Unit { return &echoUnit{} })
+	if err := registry.Unregister("child"); err != nil {
+		t.Fatalf("Unregister failed: %v", err)
+	}
+
+	second := ctx.Spawn("child", func() Unit { return &echoUnit{} })
+	if first == second {
+		t.Error("Expected a fresh ref after the old unit was unregistered")
+	}
+	askOK(t, registry, "child", "ping")
+}
+
+func TestStopBeforeStart(t *testing.T) {
+	registry := NewRegistry()
+	unit := newStoppable()
+	registry.Register("worker", unit)
+
+	if err := registry.Unregister("worker"); err != nil {
+		t.Fatalf("Unregister failed: %v", err)
+	}
+	select {
+	case <-unit.terminated:
+		t.Error("Expected Terminate not to run for a unit that was never initialized")
+	default:
+	}
+	if err := registry.Start(); err != nil {
+		t.Fatalf("Failed to start registry: %v", err)
+	}
+	registry.Stop()
+}
@@ -0,0 +1,335 @@
+package unit
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// RestartStrategy decides which children are restarted when one fails.
+type RestartStrategy int
+
+const (
+	// OneForOne restarts only the child that failed.
+	OneForOne RestartStrategy = iota
+	// OneForAll restarts every child of the supervisor.
+	OneForAll
+)
+
+func (s RestartStrategy) String() string {
+	switch s {
+	case OneForOne:
+		return "one-for-one"
+	case OneForAll:
+		return "one-for-all"
+	default:
+		return "unknown"
+	}
+}
+
+const (
+	DefaultMaxRestarts   = 3
+	DefaultRestartWindow = 5 * time.Second
+)
+
+var ErrUnitStopped = errors.New("unit stopped")
+
+// PanicError is reported when a unit panics while handling a message.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Failure is sent to a supervisor's observer whenever one of its children
+// fails.
+type Failure struct {
+	Supervisor string
+	Unit       string
+	Err        error
+	Time       time.Time
+	// Restarted is set when the failure caused a restart.
+	Restarted bool
+	// GaveUp is set when the restart intensity was exceeded.
+	GaveUp bool
+}
+
+// Supervisor watches a group of units, restarting them when they panic
+// (or return an error, with RestartOnError) according to its strategy.
+// Under OneForOne the restart intensity is counted for each child, and
+// under OneForAll for the whole group. A supervisor that exceeds it
+// escalates to its parent, which restarts the whole group. Without a
+// parent only the failing child is stopped, or the whole group under
+// OneForAll.
+type Supervisor struct {
+	name           string
+	strategy       RestartStrategy
+	maxRestarts    int
+	window         time.Duration
+	observer       string
+	restartOnError bool
+	parent         *Supervisor
+
+	mu       sync.Mutex
+	reg      *Registry
+	children []*unitRef
+	subs     []*Supervisor
+	// restarts holds recent restart times for each child (a *unitRef or
+	// *Supervisor) under OneForOne, or for the supervisor itself under
+	// OneForAll.
+	restarts map[any][]time.Time
+}
+
+type SupervisorOption func(*Supervisor)
+
+func NewSupervisor(name string, strategy RestartStrategy, opts ...SupervisorOption) *Supervisor {
+	s := &Supervisor{
+		name:        name,
+		strategy:    strategy,
+		maxRestarts: DefaultMaxRestarts,
+		window:      DefaultRestartWindow,
+		restarts:    make(map[any][]time.Time),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.parent != nil {
+		s.parent.mu.Lock()
+		s.parent.subs = append(s.parent.subs, s)
+		s.parent.mu.Unlock()
+	}
+	return s
+}
+
+// WithRestartIntensity allows at most max restarts within window before
+// the supervisor gives up.
+func WithRestartIntensity(max int, window time.Duration) SupervisorOption {
+	return func(s *Supervisor) {
+		s.maxRestarts = max
+		s.window = window
+	}
+}
+
+// WithObserver sends a Failure to the named unit for every child failure.
+func WithObserver(unitName string) SupervisorOption {
+	return func(s *Supervisor) {
+		s.observer = unitName
+	}
+}
+
+// RestartOnError treats errors returned from Handle like panics.
+func RestartOnError() SupervisorOption {
+	return func(s *Supervisor) {
+		s.restartOnError = true
+	}
+}
+
+// WithParent places the supervisor under parent in the supervision tree.
+func WithParent(parent *Supervisor) SupervisorOption {
+	return func(s *Supervisor) {
+		s.parent = parent
+	}
+}
+
+// SupervisedBy places the unit under s instead of the registry's root
+// supervisor.
+func SupervisedBy(s *Supervisor) Option {
+	return func(c *unitConfig) {
+		c.supervisor = s
+	}
+}
+
+func (s *Supervisor) Name() string {
+	return s.name
+}
+
+func (s *Supervisor) adopt(reg *Registry, ref *unitRef) {
+	s.mu.Lock()
+	s.children = append(s.children, ref)
+	s.mu.Unlock()
+
+	for sup := s; sup != nil; sup = sup.parent {
+		sup.mu.Lock()
+		sup.reg = reg
+		sup.mu.Unlock()
+	}
+}
+
+func (s *Supervisor) release(ref *unitRef) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.restarts, ref)
+	for i, child := range s.children {
+		if child == ref {
+			s.children = append(s.children[:i], s.children[i+1:]...)
+			return
+		}
+	}
+}
+
+// childFailed decides what happens to ref after err. The child and any
+// siblings are told to restart or stop through system messages, so the
+// work always happens on each child's own dispatcher goroutine.
+func (s *Supervisor) childFailed(ref *unitRef, err error) {
+	var panicErr *PanicError
+	failure := Failure{
+		Supervisor: s.name,
+		Unit:       ref.name,
+		Err:        err,
+		Time:       time.Now(),
+	}
+
+	if !errors.As(err, &panicErr) && !s.restartOnError {
+		s.report(failure)
+		return
+	}
+
+	if !s.allowRestart(ref, failure.Time) {
+		failure.GaveUp = true
+		s.report(failure)
+		switch {
+		case s.parent != nil:
+			s.parent.subFailed(s, err)
+		case s.strategy == OneForAll:
+			s.signalAll(stopSignal{reason: err})
+		default:
+			ref.mailbox.pushSystem(stopSignal{reason: err})
+		}
+		return
+	}
+
+	failure.Restarted = true
+	s.report(failure)
+	if s.strategy == OneForAll {
+		s.signalAll(restartSignal{})
+	} else {
+		ref.mailbox.pushSystem(restartSignal{})
+	}
+}
+
+// subFailed handles a child supervisor that gave up, treating its whole
+// group as a single child.
+func (s *Supervisor) subFailed(sub *Supervisor, err error) {
+	failure := Failure{
+		Supervisor: s.name,
+		Unit:       sub.name,
+		Err:        err,
+		Time:       time.Now(),
+	}
+
+	if !s.allowRestart(sub, failure.Time) {
+		failure.GaveUp = true
+		s.report(failure)
+		switch {
+		case s.parent != nil:
+			s.parent.subFailed(s, err)
+		case s.strategy == OneForAll:
+			s.signalAll(stopSignal{reason: err})
+		default:
+			sub.signalAll(stopSignal{reason: err})
+		}
+		return
+	}
+
+	failure.Restarted = true
+	s.report(failure)
+	if s.strategy == OneForAll {
+		s.signalAll(restartSignal{})
+	} else {
+		sub.signalAll(restartSignal{})
+	}
+}
+
+// allowRestart records a restart of child, unless it would exceed the
+// restart intensity.
+func (s *Supervisor) allowRestart(child any, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.strategy == OneForAll {
+		child = s
+	}
+	var recent []time.Time
+	for _, t := range s.restarts[child] {
+		if now.Sub(t) < s.window {
+			recent = append(recent, t)
+		}
+	}
+
+	if len(recent) >= s.maxRestarts {
+		delete(s.restarts, child)
+		return false
+	}
+	s.restarts[child] = append(recent, now)
+	return true
+}
+
+func (s *Supervisor) signalAll(sig any) {
+	s.mu.Lock()
+	children := append([]*unitRef(nil), s.children...)
+	subs := append([]*Supervisor(nil), s.subs...)
+	s.mu.Unlock()
+
+	for _, child := range children {
+		child.mailbox.pushSystem(sig)
+	}
+	for _, sub := range subs {
+		sub.signalAll(sig)
+	}
+}
+
+func (s *Supervisor) report(failure Failure) {
+	s.mu.Lock()
+	reg := s.reg
+	s.mu.Unlock()
+
+	if s.observer != "" && reg != nil {
+		if observer := reg.getRef(s.observer); observer != nil {
+			observer.deliver(NoSender, failure)
+			return
+		}
+	}
+
+	switch {
+	case failure.GaveUp:
+		log.Printf("supervisor %s: %s failed too often, giving up: %v", s.name, failure.Unit, failure.Err)
+	case failure.Restarted:
+		log.Printf("supervisor %s: restarting %s: %v", s.name, failure.Unit, failure.Err)
+	default:
+		log.Printf("supervisor %s: %s: %v", s.name, failure.Unit, failure.Err)
+	}
+}
+
+type restartSignal struct{}
+
+type stopSignal struct {
+	reason error
+}
+
+// safeHandle runs Handle, converting a panic into a PanicError.
+func safeHandle(unit Unit, ctx Ctx, from UnitRef, msg any) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &PanicError{Value: v, Stack: debug.Stack()}
+		}
+	}()
+	return unit.Handle(ctx, from, msg)
+}
+
+// safeInit runs Init, converting a panic into a PanicError.
+func safeInit(unit Unit, ctx Ctx) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &PanicError{Value: v, Stack: debug.Stack()}
+		}
+	}()
+	unit.Init(ctx)
+	return nil
+}
@@ -0,0 +1,270 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type flakyUnit struct {
+	inits   atomic.Int32
+	handled atomic.Int32
+}
+
+func (f *flakyUnit) Init(ctx Ctx) {
+	f.inits.Add(1)
+}
+
+func (f *flakyUnit) Handle(ctx Ctx, from UnitRef, message any) error {
+	switch message {
+	case "panic":
+		panic("boom")
+	case "error":
+		return errors.New("bad input")
+	}
+	f.handled.Add(1)
+	return from.Send(message)
+}
+
+type observerUnit struct {
+	failures chan Failure
+}
+
+func (o *observerUnit) Init(ctx Ctx) {}
+
+func (o *observerUnit) Handle(ctx Ctx, from UnitRef, message any) error {
+	if failure, ok := message.(Failure); ok {
+		o.failures <- failure
+	}
+	return nil
+}
+
+func newObserver() *observerUnit {
+	return &observerUnit{failures: make(chan Failure, 16)}
+}
+
+func waitFailure(t *testing.T, o *observerUnit) Failure {
+	t.Helper()
+	select {
+	case f := <-o.failures:
+		return f
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for failure report")
+		return Failure{}
+	}
+}
+
+func askOK(t *testing.T, registry *Registry, name string, msg any) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := registry.Ask(ctx, name, msg); err != nil {
+		t.Fatalf("Ask %s failed: %v", name, err)
+	}
+}
+
+func TestPanicIsRecoveredAndRestarted(t *testing.T) {
+	registry := NewRegistry()
+	observer := newObserver()
+	sup := NewSupervisor("workers", OneForOne, WithObserver("observer"))
+
+	flaky := &flakyUnit{}
+	registry.Register("observer", observer)
+	registry.Register("flaky", flaky, SupervisedBy(sup))
+	if err := registry.Start(); err != nil {
+		t.Fatalf("Failed to start registry: %v", err)
+	}
+	defer registry.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_, err := registry.Ask(ctx, "flaky", "panic")
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) {
+		t.Fatalf("Expected PanicError from Ask, got %v", err)
+	}
+
+	failure := waitFailure(t, observer)
+	if failure.Unit != "flaky" || !failure.Restarted {
+		t.Errorf("Expected restart of flaky, got %+v", failure)
+	}
+
+	askOK(t, registry, "flaky", "ping")
+	if flaky.inits.Load() != 2 {
+		t.Errorf("Expected 2 Init calls after restart, got %d", flaky.inits.Load())
+	}
+}
+
+func TestReturnedErrorIsReported(t *testing.T) {
+	registry := NewRegistry()
+	observer := newObserver()
+	sup := NewSupervisor("workers", OneForOne, WithObserver("observer"))
+
+	flaky := &flakyUnit{}
+	registry.Register("observer", observer)
+	registry.Register("flaky", flaky, SupervisedBy(sup))
+	if err := registry.Start(); err != nil {
+		t.Fatalf("Failed to start registry: %v", err)
+	}
+	defer registry.Stop()
+
+	registry.getRef("flaky").Send("error")
+
+	failure := waitFailure(t, observer)
+	if failure.Err == nil || failure.Err.Error() != "bad input" {
+		t.Errorf("Expected 'bad input' failure, got %+v", failure)
+	}
+	if failure.Restarted {
+		t.Error("Expected returned errors not to restart without RestartOnError")
+	}
+}
+
+func TestOneForAllRestartsSiblings(t *testing.T) {
+	registry := NewRegistry()
+	sup := NewSupervisor("group", OneForAll, WithObserver("observer"))
+
+	first := &flakyUnit{}
+	second := &flakyUnit{}
+	registry.Register("observer", newObserver())
+	registry.Register("first", first, SupervisedBy(sup))
+	registry.Register("second", second, SupervisedBy(sup))
+	if err := registry.Start(); err != nil {
+		t.Fatalf("Failed to start registry: %v", err)
+	}
+	defer registry.Stop()
+
+	registry.getRef("first").Send("panic")
+
+	askOK(t, registry, "first", "ping")
+	askOK(t, registry, "second", "ping")
+
+	if first.inits.Load() != 2 || second.inits.Load() != 2 {
+		t.Errorf("Expected both units to be restarted, got inits %d and %d",
+			first.inits.Load(), second.inits.Load())
+	}
+}
+
+func TestRestartIntensityStopsUnit(t *testing.T) {
+	registry := NewRegistry()
+	observer := newObserver()
+	sup := NewSupervisor("workers", OneForOne,
+		WithObserver("observer"),
+		WithRestartIntensity(2, time.Minute))
+
+	registry.Register("observer", observer)
+	registry.Register("flaky", &flakyUnit{}, SupervisedBy(sup))
+	if err := registry.Start(); err != nil {
+		t.Fatalf("Failed to start registry: %v", err)
+	}
+	defer registry.Stop()
+
+	ref := registry.getRef("flaky")
+	for i := 0; i < 3; i++ {
+		ref.Send("panic")
+		waitFailure(t, observer)
+	}
+
+	<-ref.done
+	if err := ref.Send("ping"); !errors.Is(err, ErrUnitStopped) {
+		t.Errorf("Expected stopped unit to refuse messages, got %v", err)
+	}
+	if registry.getRef("flaky") != nil {
+		t.Error("Expected stopped unit to be removed from the registry")
+	}
+}
+
+func TestCrashLoopSparesSiblings(t *testing.T) {
+	registry := NewRegistry()
+	healthy := &flakyUnit{}
+	registry.Register("flaky", &flakyUnit{})
+	registry.Register("healthy", healthy)
+	if err := registry.Start(); err != nil {
+		t.Fatalf("Failed to start registry: %v", err)
+	}
+	defer registry.Stop()
+
+	// A crash-looping unit under the root supervisor is stopped on its own.
+	ref := registry.getRef("flaky")
+	deadline := time.After(time.Second)
+	for stopped := false; !stopped; {
+		ref.Send("panic")
+		select {
+		case <-ref.done:
+			stopped = true
+		case <-deadline:
+			t.Fatal("Timeout waiting for the crash-looping unit to stop")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	askOK(t, registry, "healthy", "ping")
+	if healthy.inits.Load() != 1 {
+		t.Errorf("Expected the healthy sibling not to restart, got %d inits", healthy.inits.Load())
+	}
+}
+
+func TestEscalationToParent(t *testing.T) {
+	registry := NewRegistry()
+	observer := newObserver()
+	parent := NewSupervisor("parent", OneForOne, WithObserver("observer"))
+	child := NewSupervisor("child", OneForOne,
+		WithParent(parent),
+		WithObserver("observer"),
+		WithRestartIntensity(1, time.Minute))
+
+	flaky := &flakyUnit{}
+	sibling := &flakyUnit{}
+	registry.Register("observer", observer)
+	registry.Register("flaky", flaky, SupervisedBy(child))
+	registry.Register("sibling", sibling, SupervisedBy(child))
+	if err := registry.Start(); err != nil {
+		t.Fatalf("Failed to start registry: %v", err)
+	}
+	defer registry.Stop()
+
+	ref := registry.getRef("flaky")
+	ref.Send("panic")
+	waitFailure(t, observer)
+	ref.Send("panic")
+
+	gaveUp := waitFailure(t, observer)
+	if !gaveUp.GaveUp || gaveUp.Supervisor != "child" {
+		t.Errorf("Expected child supervisor to give up, got %+v", gaveUp)
+	}
+	escalated := waitFailure(t, observer)
+	if escalated.Supervisor != "parent" || escalated.Unit != "child" || !escalated.Restarted {
+		t.Errorf("Expected parent to restart child group, got %+v", escalated)
+	}
+
+	askOK(t, registry, "flaky", "ping")
+	askOK(t, registry, "sibling", "ping")
+	if sibling.inits.Load() != 2 {
+		t.Errorf("Expected sibling to be restarted with its group, got %d inits", sibling.inits.Load())
+	}
+}
+
+func TestSpawnedUnitRestartsFromFactory(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register("spawner", &silentUnit{})
+	if err := registry.Start(); err != nil {
+		t.Fatalf("Failed to start registry: %v", err)
+	}
+	defer registry.Stop()
+
+	var created atomic.Int32
+	ctx := &registryCtx{Context: context.Background(), reg: registry, self: registry.getRef("spawner")}
+	ref := ctx.Spawn("child", func() Unit {
+		created.Add(1)
+		return &flakyUnit{}
+	})
+
+	ref.Send("panic")
+	askOK(t, registry, "child", "ping")
+
+	if created.Load() != 2 {
+		t.Errorf("Expected factory to build a fresh unit on restart, got %d", created.Load())
+	}
+}
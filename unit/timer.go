@@ -0,0 +1,235 @@
+package unit
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and runs functions later. The registry uses the
+// system clock unless given another with WithClock, such as the virtual
+// clock of package unittest.
+type Clock interface {
+	Now() time.Time
+	// AfterFunc calls f once d has passed. Calling stop before then
+	// cancels it, reporting whether it did.
+	AfterFunc(d time.Duration, f func()) (stop func() bool)
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) AfterFunc(d time.Duration, f func()) func() bool {
+	return time.AfterFunc(d, f).Stop
+}
+
+// WithClock drives the registry's timers and memory checks from clock.
+func WithClock(clock Clock) RegistryOption {
+	return func(r *Registry) {
+		r.clock = clock
+	}
+}
+
+// Schedule decides when a timer fires.
+type Schedule interface {
+	// Next returns the first time after t to fire at, or the zero time
+	// if the timer should not fire again.
+	Next(t time.Time) time.Time
+}
+
+type at time.Time
+
+// At fires once, at t, if t is still to come.
+func At(t time.Time) Schedule {
+	return at(t)
+}
+
+func (a at) Next(t time.Time) time.Time {
+	if t.Before(time.Time(a)) {
+		return time.Time(a)
+	}
+	return time.Time{}
+}
+
+type every time.Duration
+
+// Every fires each time interval has passed. A non-positive interval
+// never fires.
+func Every(interval time.Duration) Schedule {
+	return every(interval)
+}
+
+func (e every) Next(t time.Time) time.Time {
+	if e <= 0 {
+		return time.Time{}
+	}
+	return t.Add(time.Duration(e))
+}
+
+// Timer is a message scheduled with Ctx.SendAfter, SendEvery or SendOn.
+// The zero Timer is stopped.
+type Timer struct {
+	clock    Clock
+	schedule Schedule
+	send     func() error
+	// release is told when the timer stops, so its owner can forget it.
+	release func(*Timer)
+
+	mu      sync.Mutex
+	cancel  func() bool
+	stopped bool
+}
+
+// NewTimer calls send at every time in schedule, according to clock,
+// until stopped or until send reports that its target has stopped. It is
+// for Ctx implementations outside the registry; units should use
+// Ctx.SendOn.
+func NewTimer(clock Clock, schedule Schedule, send func() error) *Timer {
+	t := &Timer{clock: clock, schedule: schedule, send: send}
+	t.start(time.Time{})
+	return t
+}
+
+// start arms the timer to fire first at first, or at the schedule's next
+// time when first is zero.
+func (t *Timer) start(first time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.clock.Now()
+	if first.IsZero() {
+		first = t.schedule.Next(now)
+	}
+	t.arm(first, now)
+}
+
+// arm schedules the timer to fire at next, or stops it if next is zero.
+// The caller must hold t.mu.
+func (t *Timer) arm(next, now time.Time) {
+	if t.stopped {
+		return
+	}
+	if next.IsZero() {
+		t.stopLocked()
+		return
+	}
+	t.cancel = t.clock.AfterFunc(max(next.Sub(now), 0), func() { t.fire(next) })
+}
+
+func (t *Timer) fire(scheduled time.Time) {
+	t.mu.Lock()
+	stopped := t.stopped
+	t.mu.Unlock()
+	if stopped {
+		return
+	}
+
+	err := t.send()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if errors.Is(err, ErrUnitStopped) || errors.Is(err, ErrUnknownUnit) ||
+		errors.Is(err, ErrShuttingDown) || errors.Is(err, ErrShutdown) {
+		t.stopLocked()
+		return
+	}
+
+	// Firings missed because the timer ran late are skipped rather than
+	// sent in a burst.
+	now := t.clock.Now()
+	next := t.schedule.Next(scheduled)
+	if !next.IsZero() && next.Before(now) {
+		next = t.schedule.Next(now)
+	}
+	t.arm(next, now)
+}
+
+// Stop cancels the timer, reporting whether it was still scheduled.
+func (t *Timer) Stop() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.stopped || t.cancel == nil {
+		t.stopped = true
+		return false
+	}
+	t.stopLocked()
+	return true
+}
+
+func (t *Timer) stopLocked() {
+	t.stopped = true
+	if t.cancel != nil {
+		t.cancel()
+	}
+	if t.release != nil {
+		t.release(t)
+	}
+}
+
+// SendAfter sends msg to to once d has passed.
+func (c *registryCtx) SendAfter(to UnitRef, msg any, d time.Duration) *Timer {
+	when := c.reg.clock.Now().Add(d)
+	return c.schedule(to, msg, At(when), when)
+}
+
+// SendEvery sends msg to to each time interval passes.
+func (c *registryCtx) SendEvery(to UnitRef, msg any, interval time.Duration) *Timer {
+	return c.SendOn(to, msg, Every(interval))
+}
+
+// SendOn sends msg to to at every time in schedule. The unit's timers are
+// stopped when it stops or is restarted.
+func (c *registryCtx) SendOn(to UnitRef, msg any, schedule Schedule) *Timer {
+	return c.schedule(to, msg, schedule, time.Time{})
+}
+
+func (c *registryCtx) schedule(to UnitRef, msg any, schedule Schedule, first time.Time) *Timer {
+	sender := &registryCtx{Context: c.reg.ctx, reg: c.reg, self: c.self}
+	t := &Timer{
+		clock:    c.reg.clock,
+		schedule: schedule,
+		send:     func() error { return sender.Send(to, msg) },
+		release:  c.self.forgetTimer,
+	}
+	if c.self.addTimer(t) {
+		t.start(first)
+	} else {
+		t.stopped = true
+	}
+	return t
+}
+
+// addTimer records a timer of the unit, unless the unit has stopped.
+func (r *unitRef) addTimer(t *Timer) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed.Load() {
+		return false
+	}
+	if r.timers == nil {
+		r.timers = make(map[*Timer]struct{})
+	}
+	r.timers[t] = struct{}{}
+	return true
+}
+
+func (r *unitRef) forgetTimer(t *Timer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.timers, t)
+}
+
+// stopTimers stops every timer the unit has scheduled.
+func (r *unitRef) stopTimers() {
+	r.mu.Lock()
+	timers := r.timers
+	r.timers = nil
+	r.mu.Unlock()
+
+	for t := range timers {
+		t.Stop()
+	}
+}
@@ -0,0 +1,257 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock runs timers only when advanced.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	when    time.Time
+	f       func()
+	stopped bool
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) func() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &fakeTimer{when: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		stopped := t.stopped
+		t.stopped = true
+		return !stopped
+	}
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	c.mu.Unlock()
+
+	for {
+		c.mu.Lock()
+		sort.SliceStable(c.timers, func(i, j int) bool { return c.timers[i].when.Before(c.timers[j].when) })
+		var due *fakeTimer
+		for i, t := range c.timers {
+			if !t.stopped && !t.when.After(end) {
+				due = t
+				c.timers = append(c.timers[:i], c.timers[i+1:]...)
+				break
+			}
+		}
+		if due == nil {
+			c.now = end
+			c.mu.Unlock()
+			return
+		}
+		due.stopped = true
+		c.now = due.when
+		c.mu.Unlock()
+		due.f()
+	}
+}
+
+func (c *fakeClock) live() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var n int
+	for _, t := range c.timers {
+		if !t.stopped {
+			n++
+		}
+	}
+	return n
+}
+
+// tickUnit forwards every message it handles.
+type tickUnit struct {
+	got chan any
+}
+
+func (u *tickUnit) Init(ctx Ctx) {}
+
+func (u *tickUnit) Handle(ctx Ctx, from UnitRef, message any) error {
+	u.got <- message
+	return nil
+}
+
+func expectTicks(t *testing.T, got chan any, want ...any) {
+	t.Helper()
+	for _, w := range want {
+		select {
+		case msg := <-got:
+			if msg != w {
+				t.Fatalf("Expected %v, got %v", w, msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timeout waiting for %v", w)
+		}
+	}
+	select {
+	case msg := <-got:
+		t.Fatalf("Unexpected message %v", msg)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestSendAfterAndEvery(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	registry := NewRegistry(WithClock(clock))
+	target := &tickUnit{got: make(chan any, 16)}
+	registry.Register("target", target)
+	registry.Register("scheduler", &testUnit{})
+	if err := registry.Start(); err != nil {
+		t.Fatalf("Failed to start registry: %v", err)
+	}
+	defer registry.Stop()
+
+	ctx := &registryCtx{Context: context.Background(), reg: registry, self: registry.getRef("scheduler")}
+	to := registry.getRef("target")
+	ctx.SendAfter(to, "once", 5*time.Second)
+	every := ctx.SendEvery(to, "tick", 2*time.Second)
+	ctx.SendAfter(to, "now", 0)
+
+	clock.Advance(0)
+	expectTicks(t, target.got, "now")
+	clock.Advance(4 * time.Second)
+	expectTicks(t, target.got, "tick", "tick")
+	clock.Advance(time.Second)
+	expectTicks(t, target.got, "once")
+
+	if !every.Stop() || every.Stop() {
+		t.Errorf("Expected Stop to cancel the timer once")
+	}
+	clock.Advance(10 * time.Second)
+	expectTicks(t, target.got)
+}
+
+func TestTimersStopWithUnit(t *testing.T) {
+	clock := &fakeClock{}
+	registry := NewRegistry(WithClock(clock))
+	target := &tickUnit{got: make(chan any, 16)}
+	registry.Register("target", target)
+	registry.Register("scheduler", &testUnit{})
+	if err := registry.Start(); err != nil {
+		t.Fatalf("Failed to start registry: %v", err)
+	}
+	defer registry.Stop()
+
+	scheduler := registry.getRef("scheduler")
+	ctx := &registryCtx{Context: context.Background(), reg: registry, self: scheduler}
+	timer := ctx.SendEvery(registry.getRef("target"), "tick", time.Second)
+
+	clock.Advance(time.Second)
+	expectTicks(t, target.got, "tick")
+
+	if err := registry.Unregister("scheduler"); err != nil {
+		t.Fatalf("Unregister failed: %v", err)
+	}
+	if timer.Stop() {
+		t.Error("Expected the timer to have been stopped with its unit")
+	}
+	if n := clock.live(); n != 0 {
+		t.Errorf("Expected no live clock timers, got %d", n)
+	}
+	clock.Advance(5 * time.Second)
+	expectTicks(t, target.got)
+
+	if late := ctx.SendEvery(registry.getRef("target"), "tick", time.Second); late.Stop() {
+		t.Error("Expected a stopped unit's new timer to be stopped")
+	}
+}
+
+func TestTimerStopsWhenTargetStops(t *testing.T) {
+	clock := &fakeClock{}
+	registry := NewRegistry(WithClock(clock))
+	registry.Register("target", &tickUnit{got: make(chan any, 16)})
+	registry.Register("scheduler", &testUnit{})
+	if err := registry.Start(); err != nil {
+		t.Fatalf("Failed to start registry: %v", err)
+	}
+	defer registry.Stop()
+
+	ctx := &registryCtx{Context: context.Background(), reg: registry, self: registry.getRef("scheduler")}
+	timer := ctx.SendEvery(registry.getRef("target"), "tick", time.Second)
+	if err := registry.Unregister("target"); err != nil {
+		t.Fatalf("Unregister failed: %v", err)
+	}
+
+	clock.Advance(time.Second)
+	if timer.Stop() {
+		t.Error("Expected the timer to stop once its target had")
+	}
+}
+
+func TestParseCron(t *testing.T) {
+	base := time.Date(2024, time.March, 15, 10, 30, 0, 0, time.UTC) // a Friday
+	tests := []struct {
+		spec string
+		want []time.Time
+	}{
+		{"*/15 * * * *", []time.Time{
+			time.Date(2024, time.March, 15, 10, 45, 0, 0, time.UTC),
+			time.Date(2024, time.March, 15, 11, 0, 0, 0, time.UTC),
+		}},
+		{"0 9-17/4 * * mon-fri", []time.Time{
+			time.Date(2024, time.March, 15, 13, 0, 0, 0, time.UTC),
+			time.Date(2024, time.March, 15, 17, 0, 0, 0, time.UTC),
+			time.Date(2024, time.March, 18, 9, 0, 0, 0, time.UTC),
+		}},
+		{"@monthly", []time.Time{
+			time.Date(2024, time.April, 1, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, time.May, 1, 0, 0, 0, 0, time.UTC),
+		}},
+		// Day of month and day of week match either.
+		{"0 0 1 * 7", []time.Time{
+			time.Date(2024, time.March, 17, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, time.March, 24, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, time.March, 31, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, time.April, 1, 0, 0, 0, 0, time.UTC),
+		}},
+		{"0 12 29 feb *", []time.Time{
+			time.Date(2028, time.February, 29, 12, 0, 0, 0, time.UTC),
+		}},
+		{"0 0 30 feb *", []time.Time{{}}},
+	}
+
+	for _, tt := range tests {
+		schedule, err := ParseCron(tt.spec)
+		if err != nil {
+			t.Fatalf("ParseCron(%q): %v", tt.spec, err)
+		}
+		at := base
+		for _, want := range tt.want {
+			at = schedule.Next(at)
+			if !at.Equal(want) {
+				t.Errorf("%q: expected %v, got %v", tt.spec, want, at)
+				break
+			}
+		}
+	}
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "* * * foo *"} {
+		if _, err := ParseCron(spec); !errors.Is(err, ErrInvalidCron) {
+			t.Errorf("ParseCron(%q): expected ErrInvalidCron, got %v", spec, err)
+		}
+	}
+}
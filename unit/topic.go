@@ -0,0 +1,148 @@
+package unit
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrInvalidTopic = errors.New("invalid topic")
+
+// Publication is what subscribers of a topic receive for each message
+// published on it.
+type Publication struct {
+	Topic   string
+	Payload any
+}
+
+// ValidateTopic checks a topic, or a subscription pattern when pattern is
+// set. Topics are dot-separated segments such as "vision.detections.cam1".
+// Subscription patterns may use "*" to match exactly one segment and ">"
+// as the last segment to match one or more remaining segments.
+func ValidateTopic(topic string, pattern bool) error {
+	if topic == "" {
+		return fmt.Errorf("%w: empty", ErrInvalidTopic)
+	}
+
+	segments := strings.Split(topic, ".")
+	for i, seg := range segments {
+		switch {
+		case seg == "":
+			return fmt.Errorf("%w: empty segment in %q", ErrInvalidTopic, topic)
+		case seg == "*" || seg == ">":
+			if !pattern {
+				return fmt.Errorf("%w: wildcard in published topic %q", ErrInvalidTopic, topic)
+			}
+			if seg == ">" && i != len(segments)-1 {
+				return fmt.Errorf("%w: '>' must be the last segment in %q", ErrInvalidTopic, topic)
+			}
+		case strings.ContainsAny(seg, "*>"):
+			return fmt.Errorf("%w: wildcard inside segment %q", ErrInvalidTopic, seg)
+		}
+	}
+	return nil
+}
+
+// MatchTopic reports whether topic matches the subscription pattern.
+func MatchTopic(pattern, topic string) bool {
+	ps := strings.Split(pattern, ".")
+	ts := strings.Split(topic, ".")
+
+	for i, p := range ps {
+		if p == ">" {
+			return len(ts) > i
+		}
+		if i >= len(ts) {
+			return false
+		}
+		if p != "*" && p != ts[i] {
+			return false
+		}
+	}
+	return len(ps) == len(ts)
+}
+
+// topicSubscribers returns the refs of every unit with a pattern matching
+// topic, each one once.
+func (r *Registry) topicSubscribers(topic string) []*unitRef {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	var refs []*unitRef
+	for pattern, subscribers := range r.topics {
+		if !MatchTopic(pattern, topic) {
+			continue
+		}
+		for name := range subscribers {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			if ref := r.refs[name]; ref != nil {
+				refs = append(refs, ref)
+			}
+		}
+	}
+	return refs
+}
+
+// Publish delivers msg to every unit subscribed to a matching pattern,
+// with no sender.
+func (r *Registry) Publish(topic string, msg any) error {
+	if r.stopping.Load() {
+		return ErrShuttingDown
+	}
+	return r.publish(nil, SpanContext{}, topic, msg)
+}
+
+func (r *Registry) publish(from *unitRef, span SpanContext, topic string, msg any) error {
+	if err := ValidateTopic(topic, false); err != nil {
+		return err
+	}
+
+	pub := Publication{Topic: topic, Payload: msg}
+	for _, sub := range r.topicSubscribers(topic) {
+		sender := NoSender
+		if from != nil {
+			sender = from.from(sub)
+		}
+		sub.deliverTraced(sender, pub, span)
+	}
+	return nil
+}
+
+func (c *registryCtx) Publish(topic string, msg any) error {
+	return c.reg.publish(c.self, c.span, topic, msg)
+}
+
+func (c *registryCtx) SubscribeTopic(pattern string) error {
+	if err := ValidateTopic(pattern, true); err != nil {
+		return err
+	}
+
+	c.reg.mu.Lock()
+	defer c.reg.mu.Unlock()
+
+	if c.reg.topics[pattern] == nil {
+		c.reg.topics[pattern] = make(map[string]struct{})
+	}
+	c.reg.topics[pattern][c.self.name] = struct{}{}
+	return nil
+}
+
+func (c *registryCtx) UnsubscribeTopic(pattern string) {
+	c.reg.mu.Lock()
+	defer c.reg.mu.Unlock()
+
+	c.reg.unsubscribeTopic(pattern, c.self.name)
+}
+
+func (r *Registry) unsubscribeTopic(pattern, name string) {
+	if r.topics[pattern] != nil {
+		delete(r.topics[pattern], name)
+		if len(r.topics[pattern]) == 0 {
+			delete(r.topics, pattern)
+		}
+	}
+}
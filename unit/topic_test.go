@@ -0,0 +1,158 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMatchTopic(t *testing.T) {
+	tests := []struct {
+		pattern string
+		topic   string
+		want    bool
+	}{
+		{"vision.detections", "vision.detections", true},
+		{"vision.detections", "vision.classes", false},
+		{"vision.*", "vision.detections", true},
+		{"vision.*", "vision.detections.cam1", false},
+		{"*.detections", "vision.detections", true},
+		{"vision.>", "vision.detections", true},
+		{"vision.>", "vision.detections.cam1", true},
+		{"vision.>", "vision", false},
+		{">", "anything.at.all", true},
+		{"vision.detections.cam1", "vision.detections", false},
+	}
+
+	for _, tt := range tests {
+		if got := MatchTopic(tt.pattern, tt.topic); got != tt.want {
+			t.Errorf("MatchTopic(%q, %q) = %v, want %v", tt.pattern, tt.topic, got, tt.want)
+		}
+	}
+}
+
+func TestValidateTopic(t *testing.T) {
+	tests := []struct {
+		topic   string
+		pattern bool
+		wantErr bool
+	}{
+		{"vision.detections", false, false},
+		{"vision.*", true, false},
+		{"vision.>", true, false},
+		{"vision.*", false, true},
+		{"vision.>.cam1", true, true},
+		{"vision..cam1", true, true},
+		{"vision.cam*", true, true},
+		{"", false, true},
+	}
+
+	for _, tt := range tests {
+		err := ValidateTopic(tt.topic, tt.pattern)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ValidateTopic(%q, %v) error = %v, wantErr %v", tt.topic, tt.pattern, err, tt.wantErr)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidTopic) {
+			t.Errorf("Expected ErrInvalidTopic, got %v", err)
+		}
+	}
+}
+
+type topicUnit struct {
+	patterns []string
+	received chan Publication
+	senders  chan string
+}
+
+func (u *topicUnit) Init(ctx Ctx) {
+	for _, p := range u.patterns {
+		ctx.SubscribeTopic(p)
+	}
+}
+
+func (u *topicUnit) Handle(ctx Ctx, from UnitRef, message any) error {
+	switch msg := message.(type) {
+	case Publication:
+		u.received <- msg
+		u.senders <- from.Name()
+	case string:
+		return ctx.Publish("vision.detections.cam1", msg)
+	}
+	return nil
+}
+
+func newTopicUnit(patterns ...string) *topicUnit {
+	return &topicUnit{
+		patterns: patterns,
+		received: make(chan Publication, 8),
+		senders:  make(chan string, 8),
+	}
+}
+
+func TestPublishFanOut(t *testing.T) {
+	producer := newTopicUnit()
+	exact := newTopicUnit("vision.detections.cam1")
+	wildcard := newTopicUnit("vision.*.cam1", "vision.>")
+	other := newTopicUnit("audio.>")
+
+	registry := startRegistry(t, map[string]Unit{
+		"producer": producer,
+		"exact":    exact,
+		"wildcard": wildcard,
+		"other":    other,
+	})
+
+	registry.getRef("producer").Send("3 cats")
+
+	for name, consumer := range map[string]*topicUnit{"exact": exact, "wildcard": wildcard} {
+		select {
+		case pub := <-consumer.received:
+			if pub.Topic != "vision.detections.cam1" || pub.Payload != "3 cats" {
+				t.Errorf("%s: unexpected publication %+v", name, pub)
+			}
+			if sender := <-consumer.senders; sender != "producer" {
+				t.Errorf("%s: expected sender 'producer', got %q", name, sender)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s: timeout waiting for publication", name)
+		}
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if len(wildcard.received) != 0 {
+		t.Error("Expected one delivery per subscriber even when several patterns match")
+	}
+	if len(other.received) != 0 {
+		t.Error("Expected non-matching subscriber to receive nothing")
+	}
+}
+
+func TestUnsubscribeTopic(t *testing.T) {
+	consumer := newTopicUnit("jobs.>")
+	registry := startRegistry(t, map[string]Unit{"consumer": consumer})
+
+	registry.Publish("jobs.done", 1)
+	select {
+	case <-consumer.received:
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for publication")
+	}
+
+	ctx := &registryCtx{Context: context.Background(), reg: registry, self: registry.getRef("consumer")}
+	ctx.UnsubscribeTopic("jobs.>")
+
+	registry.Publish("jobs.done", 2)
+	time.Sleep(10 * time.Millisecond)
+	if len(consumer.received) != 0 {
+		t.Error("Expected no publications after unsubscribing")
+	}
+}
+
+func TestPublishInvalidTopic(t *testing.T) {
+	registry := startRegistry(t, nil)
+
+	if err := registry.Publish("jobs.*", 1); !errors.Is(err, ErrInvalidTopic) {
+		t.Errorf("Expected ErrInvalidTopic, got %v", err)
+	}
+}
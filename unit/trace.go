@@ -0,0 +1,129 @@
+package unit
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// TraceID identifies every message caused, directly or indirectly, by one
+// message sent from outside the registry.
+type TraceID [16]byte
+
+// SpanID identifies the handling of one message.
+type SpanID [8]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+
+func (t TraceID) IsZero() bool { return t == TraceID{} }
+func (s SpanID) IsZero() bool  { return s == SpanID{} }
+
+// SpanContext travels with each message. Messages sent while handling a
+// message carry that message's span as their parent.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+func (sc SpanContext) IsValid() bool {
+	return !sc.TraceID.IsZero() && !sc.SpanID.IsZero()
+}
+
+// child starts a span under sc, or a new trace if sc is not valid.
+func (sc SpanContext) child() SpanContext {
+	child := SpanContext{TraceID: sc.TraceID}
+	if !sc.IsValid() {
+		putUint64(child.TraceID[:8], rand.Uint64())
+		putUint64(child.TraceID[8:], rand.Uint64())
+	}
+	putUint64(child.SpanID[:], rand.Uint64())
+	return child
+}
+
+func putUint64(b []byte, v uint64) {
+	for i := range b {
+		b[i] = byte(v >> (8 * i))
+	}
+}
+
+// Span records how one message was handled.
+type Span struct {
+	SpanContext
+	Parent SpanID
+	// Unit handled the message; From sent it, if it came from a unit.
+	Unit string
+	From string
+	// Message is the Go type of the message, and Action its "action"
+	// field when it was a map carrying one.
+	Message string
+	Action  string
+	// Sent is when the message was queued, Start when the unit began
+	// handling it and End when Handle returned.
+	Sent  time.Time
+	Start time.Time
+	End   time.Time
+	Err   error
+}
+
+// Tracer receives a Span for every message a unit handles. Record is
+// called from the units' dispatcher goroutines and must be safe for
+// concurrent use.
+type Tracer interface {
+	Record(span Span)
+}
+
+// WithTracer records a span for every handled message.
+func WithTracer(t Tracer) RegistryOption {
+	return func(r *Registry) {
+		r.tracer = t
+	}
+}
+
+// SpanFrom returns the span of the message ctx is handling.
+func SpanFrom(ctx Ctx) (SpanContext, bool) {
+	c, ok := ctx.(*registryCtx)
+	if !ok || !c.span.IsValid() {
+		return SpanContext{}, false
+	}
+	return c.span, true
+}
+
+// handle runs Handle within a new span under the message's own, updating
+// the unit's metrics and recording the span if the registry has a tracer.
+func (r *Registry) handle(ctx *registryCtx, unit Unit, env envelope) error {
+	msgCtx := *ctx
+	msgCtx.span = env.span.child()
+
+	from := env.from
+	if b, ok := from.(*boundRef); ok {
+		from = &boundRef{target: b.target, sender: b.sender, span: msgCtx.span}
+	}
+
+	start := time.Now()
+	err := safeHandle(unit, &msgCtx, from, env.msg)
+	end := time.Now()
+	ctx.self.metrics.observe(end.Sub(start), err)
+
+	if r.tracer == nil {
+		return err
+	}
+
+	span := Span{
+		SpanContext: msgCtx.span,
+		Parent:      env.span.SpanID,
+		Unit:        ctx.self.name,
+		From:        env.from.Name(),
+		Message:     fmt.Sprintf("%T", env.msg),
+		Sent:        env.sent,
+		Start:       start,
+		End:         end,
+		Err:         err,
+	}
+	if m, ok := env.msg.(map[string]any); ok {
+		span.Action, _ = m["action"].(string)
+	}
+	r.tracer.Record(span)
+	return err
+}
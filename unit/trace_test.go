@@ -0,0 +1,165 @@
+package unit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []Span
+}
+
+func (t *recordingTracer) Record(span Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.spans = append(t.spans, span)
+}
+
+func (t *recordingTracer) waitFor(tb testing.TB, n int) []Span {
+	tb.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		t.mu.Lock()
+		if len(t.spans) >= n {
+			spans := append([]Span(nil), t.spans...)
+			t.mu.Unlock()
+			return spans
+		}
+		t.mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+	}
+	tb.Fatalf("Timed out waiting for %d spans", n)
+	return nil
+}
+
+// forwardUnit sends requests on to next and records the replies, along
+// with the span it saw while handling each message.
+type forwardUnit struct {
+	next  string
+	mu    sync.Mutex
+	spans []SpanContext
+}
+
+func (f *forwardUnit) Init(ctx Ctx) {}
+
+func (f *forwardUnit) Handle(ctx Ctx, from UnitRef, message any) error {
+	span, _ := SpanFrom(ctx)
+	f.mu.Lock()
+	f.spans = append(f.spans, span)
+	f.mu.Unlock()
+
+	if message == "request" {
+		return ctx.Send(&unitRef{name: f.next}, map[string]any{"action": "ping"})
+	}
+	return nil
+}
+
+func TestTracePropagatesAcrossUnits(t *testing.T) {
+	tracer := &recordingTracer{}
+	registry := NewRegistry(WithTracer(tracer))
+	front := &forwardUnit{next: "echo"}
+	registry.Register("front", front)
+	registry.Register("echo", &echoUnit{})
+	if err := registry.Start(); err != nil {
+		t.Fatalf("Failed to start registry: %v", err)
+	}
+	defer registry.Stop()
+
+	registry.getRef("front").Send("request")
+	spans := tracer.waitFor(t, 3)
+
+	request, ping, reply := spans[0], spans[1], spans[2]
+	if request.Unit != "front" || ping.Unit != "echo" || reply.Unit != "front" {
+		t.Fatalf("Unexpected span order: %s, %s, %s", request.Unit, ping.Unit, reply.Unit)
+	}
+	if !request.Parent.IsZero() || request.From != "" {
+		t.Errorf("Expected the first span to be a root without sender, got %+v", request)
+	}
+	for _, span := range spans {
+		if span.TraceID != request.TraceID {
+			t.Errorf("Span %s has trace %s, want %s", span.Unit, span.TraceID, request.TraceID)
+		}
+		if span.Sent.IsZero() || span.End.Before(span.Start) || span.Start.Before(span.Sent) {
+			t.Errorf("Span %s has inconsistent times: %+v", span.Unit, span)
+		}
+	}
+	if ping.Parent != request.SpanID || ping.From != "front" || ping.Action != "ping" {
+		t.Errorf("Expected ping to be a child of the request from front, got %+v", ping)
+	}
+	if reply.Parent != ping.SpanID || reply.From != "echo" {
+		t.Errorf("Expected the reply to be a child of ping from echo, got %+v", reply)
+	}
+
+	front.mu.Lock()
+	defer front.mu.Unlock()
+	if front.spans[0] != request.SpanContext {
+		t.Errorf("SpanFrom = %v, want %v", front.spans[0], request.SpanContext)
+	}
+}
+
+func TestOTLPFile(t *testing.T) {
+	var buf bytes.Buffer
+	exporter := NewOTLPFile(&buf, "test")
+
+	start := time.Unix(10, 0)
+	parent := SpanContext{}.child()
+	span := Span{
+		SpanContext: parent.child(),
+		Parent:      parent.SpanID,
+		Unit:        "math",
+		From:        "executor",
+		Message:     "map[string]interface {}",
+		Action:      "add",
+		Sent:        start,
+		Start:       start.Add(time.Millisecond),
+		End:         start.Add(2 * time.Millisecond),
+		Err:         errors.New("boom"),
+	}
+	exporter.Record(span)
+	exporter.Record(Span{SpanContext: parent, Unit: "executor", Message: "string", Start: start, End: start})
+	if err := exporter.Err(); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("Expected one line per span, got %d", len(lines))
+	}
+
+	var req otlpRequest
+	if err := json.Unmarshal(lines[0], &req); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	got := req.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	if got.TraceID != parent.TraceID.String() || len(got.TraceID) != 32 {
+		t.Errorf("traceId = %q", got.TraceID)
+	}
+	if got.ParentSpanID != parent.SpanID.String() || got.Name != "math add" {
+		t.Errorf("Unexpected span: %+v", got)
+	}
+	if got.StartTimeUnixNano != "10001000000" || got.EndTimeUnixNano != "10002000000" {
+		t.Errorf("Unexpected times: %s - %s", got.StartTimeUnixNano, got.EndTimeUnixNano)
+	}
+	if len(got.Events) != 2 || got.Events[0].Name != "send" {
+		t.Errorf("Expected send and receive events, got %+v", got.Events)
+	}
+	if got.Status.Code != otlpStatusError || got.Status.Message != "boom" {
+		t.Errorf("Unexpected status: %+v", got.Status)
+	}
+	if req.ResourceSpans[0].Resource.Attributes[0].Value["stringValue"] != "test" {
+		t.Errorf("Unexpected resource: %+v", req.ResourceSpans[0].Resource)
+	}
+
+	var rootReq otlpRequest
+	if err := json.Unmarshal(lines[1], &rootReq); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if root := rootReq.ResourceSpans[0].ScopeSpans[0].Spans[0]; root.ParentSpanID != "" {
+		t.Errorf("Expected a root span without parent, got %q", root.ParentSpanID)
+	}
+}
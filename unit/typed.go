@@ -0,0 +1,184 @@
+package unit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// ErrInvalidMessage is returned when a message cannot be decoded into the
+// type a handler expects, or fails its validation.
+var ErrInvalidMessage = errors.New("invalid message")
+
+// Validator is implemented by request types that check their own fields
+// once decoded.
+type Validator interface {
+	Validate() error
+}
+
+// Decode converts msg into a T. msg may already be a T or *T, JSON as a
+// string, []byte or json.RawMessage, or any value that marshals to JSON,
+// such as a map[string]any. Required fields, as reported by SchemaOf, must
+// be present.
+func Decode[T any](msg any) (T, error) {
+	var req T
+	switch m := msg.(type) {
+	case T:
+		req = m
+	case *T:
+		if m == nil {
+			return req, fmt.Errorf("%w: nil %T", ErrInvalidMessage, msg)
+		}
+		req = *m
+	default:
+		data, err := jsonOf(msg)
+		if err != nil {
+			return req, fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+		}
+		if err := json.Unmarshal(data, &req); err != nil {
+			return req, fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+		}
+		if err := checkRequired(reflect.TypeOf((*T)(nil)).Elem(), data); err != nil {
+			return req, err
+		}
+	}
+
+	if v, ok := any(&req).(Validator); ok {
+		if err := v.Validate(); err != nil {
+			return req, fmt.Errorf("%w: %w", ErrInvalidMessage, err)
+		}
+	}
+	return req, nil
+}
+
+func jsonOf(msg any) ([]byte, error) {
+	switch m := msg.(type) {
+	case json.RawMessage:
+		return m, nil
+	case []byte:
+		return m, nil
+	case string:
+		return []byte(m), nil
+	default:
+		return json.Marshal(msg)
+	}
+}
+
+func checkRequired(t reflect.Type, data []byte) error {
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var present map[string]json.RawMessage
+	if err := json.Unmarshal(data, &present); err != nil {
+		return nil
+	}
+	for _, f := range structFields(t) {
+		if _, ok := present[f.name]; f.required && !ok {
+			return fmt.Errorf("%w: missing field %q", ErrInvalidMessage, f.name)
+		}
+	}
+	return nil
+}
+
+// TypedUnit is a Unit built from a handler for a single request type.
+type TypedUnit[Req, Resp any] struct {
+	handle func(ctx Ctx, req Req) (Resp, error)
+}
+
+// Typed turns handle into a Unit. Each message is decoded into a Req and
+// the Resp is sent back to the sender; decoding and handler errors are
+// returned from Handle, which fails the sender's Ask.
+func Typed[Req, Resp any](handle func(ctx Ctx, req Req) (Resp, error)) *TypedUnit[Req, Resp] {
+	return &TypedUnit[Req, Resp]{handle: handle}
+}
+
+func (t *TypedUnit[Req, Resp]) Init(ctx Ctx) {}
+
+func (t *TypedUnit[Req, Resp]) Handle(ctx Ctx, from UnitRef, msg any) error {
+	req, err := Decode[Req](msg)
+	if err != nil {
+		return err
+	}
+	resp, err := t.handle(ctx, req)
+	if err != nil {
+		return err
+	}
+	from.Send(resp)
+	return nil
+}
+
+// ActionHandler is one typed action of an ActionSet, made with Action.
+type ActionHandler interface {
+	describe() ActionDesc
+	handle(ctx Ctx, from UnitRef, msg any) error
+}
+
+type action[Req, Resp any] struct {
+	name    string
+	summary string
+	unit    *TypedUnit[Req, Resp]
+}
+
+// Action names a typed handler so it can be routed to by an ActionSet.
+func Action[Req, Resp any](name, summary string, handle func(ctx Ctx, req Req) (Resp, error)) ActionHandler {
+	return &action[Req, Resp]{name: name, summary: summary, unit: Typed(handle)}
+}
+
+func (a *action[Req, Resp]) describe() ActionDesc {
+	return ActionDesc{
+		Name:    a.name,
+		Summary: a.summary,
+		Args:    SchemaOf[Req](),
+		Reply:   SchemaOf[Resp](),
+	}
+}
+
+func (a *action[Req, Resp]) handle(ctx Ctx, from UnitRef, msg any) error {
+	return a.unit.Handle(ctx, from, msg)
+}
+
+// ActionSet is a Unit that routes map or JSON messages to typed handlers
+// by their "action" field. It describes itself from the handlers' types.
+type ActionSet struct {
+	summary string
+	actions []ActionHandler
+	byName  map[string]ActionHandler
+}
+
+func Actions(summary string, actions ...ActionHandler) *ActionSet {
+	s := &ActionSet{
+		summary: summary,
+		actions: actions,
+		byName:  make(map[string]ActionHandler, len(actions)),
+	}
+	for _, a := range actions {
+		s.byName[a.describe().Name] = a
+	}
+	return s
+}
+
+func (s *ActionSet) Init(ctx Ctx) {}
+
+func (s *ActionSet) Handle(ctx Ctx, from UnitRef, msg any) error {
+	head, err := Decode[struct {
+		Action string `json:"action"`
+	}](msg)
+	if err != nil {
+		return err
+	}
+	a, ok := s.byName[head.Action]
+	if !ok {
+		return fmt.Errorf("%w: unknown action %q", ErrInvalidMessage, head.Action)
+	}
+	return a.handle(ctx, from, msg)
+}
+
+func (s *ActionSet) Describe() Description {
+	desc := Description{Summary: s.summary}
+	for _, a := range s.actions {
+		desc.Actions = append(desc.Actions, a.describe())
+	}
+	return desc
+}
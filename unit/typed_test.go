@@ -0,0 +1,114 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type divideReq struct {
+	A    float64 `json:"a"`
+	B    float64 `json:"b" desc:"must not be zero"`
+	Note string  `json:"note,omitempty"`
+}
+
+func (r *divideReq) Validate() error {
+	if r.B == 0 {
+		return fmt.Errorf("b must not be zero")
+	}
+	return nil
+}
+
+func TestDecode(t *testing.T) {
+	want := divideReq{A: 6, B: 3}
+
+	inputs := []any{
+		want,
+		&want,
+		map[string]any{"a": 6, "b": 3},
+		`{"a": 6, "b": 3}`,
+		[]byte(`{"a": 6, "b": 3}`),
+	}
+	for _, in := range inputs {
+		got, err := Decode[divideReq](in)
+		if err != nil || got != want {
+			t.Errorf("Decode(%T) = %+v, %v; want %+v", in, got, err, want)
+		}
+	}
+
+	invalid := []any{
+		map[string]any{"a": 6},
+		map[string]any{"a": 6, "b": 0},
+		map[string]any{"a": "six", "b": 3},
+		"not json",
+	}
+	for _, in := range invalid {
+		if _, err := Decode[divideReq](in); !errors.Is(err, ErrInvalidMessage) {
+			t.Errorf("Decode(%v) error = %v, want ErrInvalidMessage", in, err)
+		}
+	}
+}
+
+func TestTypedUnit(t *testing.T) {
+	divide := Typed(func(ctx Ctx, req divideReq) (float64, error) {
+		return req.A / req.B, nil
+	})
+	registry := startRegistry(t, map[string]Unit{"divide": divide})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	reply, err := registry.Ask(ctx, "divide", map[string]any{"a": 6.0, "b": 3.0})
+	if err != nil || reply != 2.0 {
+		t.Errorf("Ask = %v, %v; want 2", reply, err)
+	}
+
+	if _, err := registry.Ask(ctx, "divide", map[string]any{"a": 6.0, "b": 0}); !errors.Is(err, ErrInvalidMessage) {
+		t.Errorf("Ask with b=0 error = %v, want ErrInvalidMessage", err)
+	}
+}
+
+func TestActionSet(t *testing.T) {
+	set := Actions("Arithmetic",
+		Action("divide", "Divide a by b", func(ctx Ctx, req divideReq) (float64, error) {
+			return req.A / req.B, nil
+		}),
+		Action("zero", "Always zero", func(ctx Ctx, req struct{}) (int, error) {
+			return 0, nil
+		}),
+	)
+	registry := startRegistry(t, map[string]Unit{"math": set})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	reply, err := registry.Ask(ctx, "math", `{"action": "divide", "a": 9, "b": 3}`)
+	if err != nil || reply != 3.0 {
+		t.Errorf("divide = %v, %v; want 3", reply, err)
+	}
+	reply, err = registry.Ask(ctx, "math", map[string]any{"action": "zero"})
+	if err != nil || reply != 0 {
+		t.Errorf("zero = %v, %v; want 0", reply, err)
+	}
+	if _, err := registry.Ask(ctx, "math", map[string]any{"action": "nope"}); !errors.Is(err, ErrInvalidMessage) {
+		t.Errorf("unknown action error = %v, want ErrInvalidMessage", err)
+	}
+
+	desc := set.Describe()
+	action, ok := desc.Action("divide")
+	if !ok {
+		t.Fatal("divide is not described")
+	}
+	props := action.Args["properties"].(map[string]any)
+	if props["b"].(Schema)["description"] != "must not be zero" {
+		t.Errorf("b schema = %v", props["b"])
+	}
+	if required := action.Args["required"].([]string); len(required) != 2 {
+		t.Errorf("required = %v, want a and b", required)
+	}
+	if action.Reply["type"] != "number" {
+		t.Errorf("reply = %v, want number", action.Reply)
+	}
+}
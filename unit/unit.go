@@ -1,26 +1,52 @@
 package unit
 
-import "context"
+import (
+	"context"
+	"time"
+
+	"github.com/eliothedeman/smol/metrics"
+)
 
 type UnitDesc struct {
 	Name  string
 	Proxy Unit
+	Ref   UnitRef
+	// Description is set for units that implement Describer.
+	Description *Description
 }
 
 type Ctx interface {
 	context.Context
 	Units() []UnitDesc
-	Spawn(name string, f UnitFactory) UnitRef
+	Spawn(name string, f UnitFactory, opts ...Option) UnitRef
+	// SpawnPool spawns size workers made by f behind one ref that routes
+	// messages across them.
+	SpawnPool(name string, f UnitFactory, size int, opts ...Option) UnitRef
 	Self() UnitRef
+	Send(to UnitRef, msg any) error
+	Ask(to UnitRef, msg any) (any, error)
+	AskAsync(to UnitRef, msg any) *Future
 	Subscribe(other Unit)
 	Unsubscribe(other Unit)
+	Publish(topic string, msg any) error
+	SubscribeTopic(pattern string) error
+	UnsubscribeTopic(pattern string)
+	Watch(other UnitRef)
+	Unwatch(other UnitRef)
+	// SendAfter, SendEvery and SendOn send msg to to later, from the unit.
+	// They are stopped when the unit stops or restarts.
+	SendAfter(to UnitRef, msg any, d time.Duration) *Timer
+	SendEvery(to UnitRef, msg any, interval time.Duration) *Timer
+	SendOn(to UnitRef, msg any, schedule Schedule) *Timer
+	// Metrics registers the unit's own metrics, labelled with its name.
+	Metrics() *metrics.Registry
 }
 
 type UnitFactory func() Unit
 
 type UnitRef interface {
 	Name() string
-	Send(msg any)
+	Send(msg any) error
 	Stop()
 }
 
@@ -0,0 +1,109 @@
+package unittest
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Epoch is the time a harness's clock starts at unless told otherwise.
+var Epoch = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Clock is a virtual clock. Its time only moves when Advance is called,
+// which runs the functions scheduled with AfterFunc that have come due.
+type Clock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*timer
+	seq    int
+}
+
+type timer struct {
+	when    time.Time
+	seq     int
+	f       func()
+	stopped bool
+}
+
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start}
+}
+
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// AfterFunc calls f once the clock has advanced by d. Calling stop before
+// then cancels it, reporting whether it did.
+func (c *Clock) AfterFunc(d time.Duration, f func()) (stop func() bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.seq++
+	t := &timer{when: c.now.Add(d), seq: c.seq, f: f}
+	c.timers = append(c.timers, t)
+	return func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if t.stopped {
+			return false
+		}
+		t.stopped = true
+		return true
+	}
+}
+
+// Advance moves the clock forward by d, running due timers in the order
+// they fall due, each with the clock set to its due time.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	c.mu.Unlock()
+
+	for {
+		t := c.nextDue(end)
+		if t == nil {
+			break
+		}
+		t.f()
+	}
+
+	c.mu.Lock()
+	c.now = end
+	c.mu.Unlock()
+}
+
+// nextDue removes and returns the earliest live timer due by end, moving
+// the clock to its due time.
+func (c *Clock) nextDue(end time.Time) *timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	live := c.timers[:0]
+	for _, t := range c.timers {
+		if !t.stopped {
+			live = append(live, t)
+		}
+	}
+	c.timers = live
+	sort.Slice(c.timers, func(i, j int) bool {
+		a, b := c.timers[i], c.timers[j]
+		if !a.when.Equal(b.when) {
+			return a.when.Before(b.when)
+		}
+		return a.seq < b.seq
+	})
+
+	if len(c.timers) == 0 || c.timers[0].when.After(end) {
+		return nil
+	}
+	t := c.timers[0]
+	c.timers = c.timers[1:]
+	t.stopped = true
+	if t.when.After(c.now) {
+		c.now = t.when
+	}
+	return t
+}
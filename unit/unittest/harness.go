@@ -0,0 +1,715 @@
+// Package unittest runs units deterministically in tests. A Harness hosts
+// units on the test's goroutine: sent messages are queued, and Step hands
+// them to Handle one at a time in an order picked by a seeded scheduler,
+// so any interleaving a test finds can be reproduced. Every send is
+// recorded, time comes from a virtual Clock, and Probes stand in for the
+// units a test wants to see replies from.
+package unittest
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eliothedeman/smol/metrics"
+	"github.com/eliothedeman/smol/unit"
+)
+
+// DefaultMaxSteps bounds Run so that units messaging each other forever
+// fail the test instead of hanging it.
+const DefaultMaxSteps = 10000
+
+// Delivery is a message sent within a harness.
+type Delivery struct {
+	// Seq numbers deliveries in the order they were sent, from 1.
+	Seq  int
+	From string
+	To   string
+	Msg  any
+	// At is the clock's time when the message was sent.
+	At time.Time
+	// Handled is set once the message has been delivered, and Err to
+	// what Handle returned.
+	Handled bool
+	Err     error
+
+	from unit.UnitRef
+}
+
+func (d Delivery) String() string {
+	from := d.From
+	if from == "" {
+		from = "-"
+	}
+	return fmt.Sprintf("#%d %s->%s %#v", d.Seq, from, d.To, d.Msg)
+}
+
+type Option func(*Harness)
+
+// WithSeed makes the scheduler pick which unit handles a message next at
+// random, seeded with seed. Messages to any one unit are still handled in
+// the order they were sent. Without it, messages are handled strictly in
+// the order they were sent.
+func WithSeed(seed int64) Option {
+	return func(h *Harness) {
+		h.rand = rand.New(rand.NewSource(seed))
+	}
+}
+
+// WithClock uses clock instead of one starting at Epoch.
+func WithClock(clock *Clock) Option {
+	return func(h *Harness) {
+		h.clock = clock
+	}
+}
+
+// WithMaxSteps bounds how many messages Run delivers.
+func WithMaxSteps(n int) Option {
+	return func(h *Harness) {
+		h.maxSteps = n
+	}
+}
+
+// Harness hosts units for a test. It is not safe for concurrent use.
+type Harness struct {
+	tb       testing.TB
+	ctx      context.Context
+	clock    *Clock
+	rand     *rand.Rand
+	maxSteps int
+	metrics  *metrics.Registry
+
+	units    map[string]*hosted
+	order    []string
+	queue    []*Delivery
+	sent     []*Delivery
+	subs     map[string]map[string]bool
+	topics   map[string]map[string]bool
+	watchers map[string]map[string]bool
+}
+
+type hosted struct {
+	name    string
+	unit    unit.Unit
+	probe   *Probe
+	busy    bool
+	stopped bool
+	timers  []*unit.Timer
+}
+
+func New(tb testing.TB, opts ...Option) *Harness {
+	h := &Harness{
+		tb:       tb,
+		ctx:      tb.Context(),
+		maxSteps: DefaultMaxSteps,
+		metrics:  metrics.NewRegistry(),
+		units:    make(map[string]*hosted),
+		subs:     make(map[string]map[string]bool),
+		topics:   make(map[string]map[string]bool),
+		watchers: make(map[string]map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	if h.clock == nil {
+		h.clock = NewClock(Epoch)
+	}
+	return h
+}
+
+// Add hosts u under name and runs its Init.
+func (h *Harness) Add(name string, u unit.Unit) unit.UnitRef {
+	h.tb.Helper()
+	if _, exists := h.units[name]; exists {
+		h.tb.Fatalf("unittest: unit %s added twice", name)
+	}
+	hu := &hosted{name: name, unit: u}
+	h.units[name] = hu
+	h.order = append(h.order, name)
+
+	if err := safeInit(u, h.Ctx(name)); err != nil {
+		h.tb.Fatalf("unittest: init %s: %v", name, err)
+	}
+	return h.Ref(name)
+}
+
+// Ref returns a ref for sending to the named unit from outside the
+// harness.
+func (h *Harness) Ref(name string) unit.UnitRef {
+	return &ref{h: h, name: name}
+}
+
+// Ctx returns the context the named unit is given, for calling its
+// methods directly.
+func (h *Harness) Ctx(name string) unit.Ctx {
+	return &unitCtx{Context: h.ctx, h: h, self: name}
+}
+
+func (h *Harness) Clock() *Clock {
+	return h.clock
+}
+
+// Advance moves the clock forward by d.
+func (h *Harness) Advance(d time.Duration) {
+	h.clock.Advance(d)
+}
+
+func (h *Harness) Metrics() *metrics.Registry {
+	return h.metrics
+}
+
+// Send queues msg for the named unit with no sender.
+func (h *Harness) Send(to string, msg any) error {
+	return h.deliver(unit.NoSender, to, msg)
+}
+
+// Ask sends msg to the named unit and delivers messages until it replies.
+func (h *Harness) Ask(to string, msg any) (any, error) {
+	f, promise := unit.NewPromise("")
+	if err := h.deliver(promise, to, msg); err != nil {
+		return nil, err
+	}
+	return h.await(f)
+}
+
+// Step delivers one queued message, returning it once handled. It
+// reports false when nothing can be delivered.
+func (h *Harness) Step() (Delivery, bool) {
+	d := h.next()
+	if d == nil {
+		return Delivery{}, false
+	}
+	h.handle(d)
+	return *d, true
+}
+
+// Run delivers messages until none are left, returning how many it
+// delivered. It fails the test after the harness's maximum number of
+// steps.
+func (h *Harness) Run() int {
+	h.tb.Helper()
+	for n := 0; ; n++ {
+		if n == h.maxSteps {
+			h.tb.Fatalf("unittest: still delivering after %d messages", n)
+		}
+		if _, ok := h.Step(); !ok {
+			return n
+		}
+	}
+}
+
+// Sent returns every message sent so far, in order.
+func (h *Harness) Sent() []Delivery {
+	sent := make([]Delivery, len(h.sent))
+	for i, d := range h.sent {
+		sent[i] = *d
+	}
+	return sent
+}
+
+// Pending returns the messages not yet delivered, in the order sent.
+func (h *Harness) Pending() []Delivery {
+	pending := make([]Delivery, len(h.queue))
+	for i, d := range h.queue {
+		pending[i] = *d
+	}
+	return pending
+}
+
+// ExpectSent delivers messages until one matching match is sent to the
+// named unit, failing the test if none has been after within more
+// deliveries. Messages sent earlier count too.
+func (h *Harness) ExpectSent(to string, match Matcher, within int) Delivery {
+	h.tb.Helper()
+	for steps := 0; ; steps++ {
+		for _, d := range h.sent {
+			if d.To == to && match.Match(d.Msg) {
+				return *d
+			}
+		}
+		if steps == within {
+			break
+		}
+		if _, ok := h.Step(); !ok {
+			break
+		}
+	}
+	h.tb.Fatalf("unittest: no message %s sent to %s within %d messages; sent:\n%s", match, to, within, h.describe(h.sent))
+	return Delivery{}
+}
+
+func (h *Harness) deliver(from unit.UnitRef, to string, msg any) error {
+	target := h.units[to]
+	if target == nil {
+		return fmt.Errorf("%w: %s", unit.ErrUnknownUnit, to)
+	}
+	if target.stopped {
+		return unit.ErrUnitStopped
+	}
+	h.enqueue(from, to, msg)
+
+	var subscribers []string
+	for name := range h.subs[to] {
+		subscribers = append(subscribers, name)
+	}
+	sort.Strings(subscribers)
+	for _, name := range subscribers {
+		if sub := h.units[name]; sub != nil && !sub.stopped {
+			h.enqueue(&ref{h: h, name: to, sender: name}, name, msg)
+		}
+	}
+	return nil
+}
+
+func (h *Harness) enqueue(from unit.UnitRef, to string, msg any) {
+	d := &Delivery{
+		Seq:  len(h.sent) + 1,
+		From: from.Name(),
+		To:   to,
+		Msg:  msg,
+		At:   h.clock.Now(),
+		from: from,
+	}
+	h.sent = append(h.sent, d)
+	h.queue = append(h.queue, d)
+}
+
+// next removes the next delivery from the queue: the oldest message for
+// a unit picked by the scheduler from those not busy handling one.
+func (h *Harness) next() *Delivery {
+	var candidates []int
+	seen := make(map[string]bool)
+	for i, d := range h.queue {
+		if seen[d.To] || h.units[d.To].busy {
+			continue
+		}
+		seen[d.To] = true
+		candidates = append(candidates, i)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	i := candidates[0]
+	if h.rand != nil {
+		i = candidates[h.rand.Intn(len(candidates))]
+	}
+	d := h.queue[i]
+	h.queue = append(h.queue[:i], h.queue[i+1:]...)
+	return d
+}
+
+func (h *Harness) handle(d *Delivery) {
+	target := h.units[d.To]
+	d.Handled = true
+	if target.probe != nil {
+		target.probe.received = append(target.probe.received, *d)
+		return
+	}
+
+	target.busy = true
+	d.Err = safeHandle(target.unit, h.Ctx(d.To), d.from, d.Msg)
+	target.busy = false
+	if d.Err != nil {
+		if r, ok := d.from.(unit.Rejecter); ok {
+			r.Reject(d.Err)
+		}
+	}
+}
+
+// await delivers messages until f completes. Units waiting on an Ask are
+// skipped, as they would be blocked in the registry.
+func (h *Harness) await(f *unit.Future) (any, error) {
+	for {
+		select {
+		case <-f.Done():
+			return f.Result()
+		default:
+		}
+		if _, ok := h.Step(); !ok {
+			return nil, fmt.Errorf("%w: request %d: no messages left to deliver", unit.ErrNoReply, f.ID())
+		}
+	}
+}
+
+func (h *Harness) stop(name string, reason error) {
+	target := h.units[name]
+	if target == nil || target.stopped {
+		return
+	}
+	target.stopped = true
+	for _, t := range target.timers {
+		t.Stop()
+	}
+	target.timers = nil
+
+	queue := h.queue[:0]
+	for _, d := range h.queue {
+		if d.To != name {
+			queue = append(queue, d)
+			continue
+		}
+		if r, ok := d.from.(unit.Rejecter); ok {
+			r.Reject(unit.ErrUnitStopped)
+		}
+	}
+	h.queue = queue
+
+	if stopper, ok := target.unit.(unit.Stopper); ok {
+		stopper.Terminate(h.Ctx(name), reason)
+	}
+
+	delete(h.subs, name)
+	for _, subs := range h.subs {
+		delete(subs, name)
+	}
+	for _, subs := range h.topics {
+		delete(subs, name)
+	}
+	watchers := h.watchers[name]
+	delete(h.watchers, name)
+
+	var names []string
+	for w := range watchers {
+		names = append(names, w)
+	}
+	sort.Strings(names)
+	for _, w := range names {
+		h.deliver(unit.NoSender, w, unit.Terminated{Unit: name, Reason: reason})
+	}
+}
+
+func (h *Harness) describe(ds []*Delivery) string {
+	if len(ds) == 0 {
+		return "  (none)"
+	}
+	lines := make([]string, len(ds))
+	for i, d := range ds {
+		lines[i] = "  " + d.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ref reaches the unit called name. Messages sent on it come from sender,
+// or have no sender when it is empty.
+type ref struct {
+	h      *Harness
+	name   string
+	sender string
+}
+
+func (r *ref) Name() string {
+	return r.name
+}
+
+func (r *ref) Send(msg any) error {
+	var from unit.UnitRef = unit.NoSender
+	if r.sender != "" {
+		from = &ref{h: r.h, name: r.sender, sender: r.name}
+	}
+	return r.h.deliver(from, r.name, msg)
+}
+
+func (r *ref) Stop() {
+	r.h.stop(r.name, unit.ErrUnitStopped)
+}
+
+// Probe is a stand-in unit that records what it receives, so that tests
+// can check the replies units send.
+type Probe struct {
+	h        *Harness
+	name     string
+	received []Delivery
+	next     int
+}
+
+// Probe adds a probe under name.
+func (h *Harness) Probe(name string) *Probe {
+	h.tb.Helper()
+	if _, exists := h.units[name]; exists {
+		h.tb.Fatalf("unittest: unit %s added twice", name)
+	}
+	p := &Probe{h: h, name: name}
+	h.units[name] = &hosted{name: name, probe: p}
+	return p
+}
+
+// Ref returns a ref for sending to the probe.
+func (p *Probe) Ref() unit.UnitRef {
+	return p.h.Ref(p.name)
+}
+
+// Send queues msg for the named unit as if sent by the probe, so that
+// replies come back to it.
+func (p *Probe) Send(to string, msg any) error {
+	return p.h.deliver(&ref{h: p.h, name: p.name, sender: to}, to, msg)
+}
+
+// Received returns every message the probe has received.
+func (p *Probe) Received() []Delivery {
+	return append([]Delivery(nil), p.received...)
+}
+
+// ExpectReply delivers messages until the probe receives one matching
+// match and returns it, failing the test if none has arrived after
+// within more deliveries. Each message received is matched at most once,
+// so successive calls expect successive replies.
+func (p *Probe) ExpectReply(match Matcher, within int) any {
+	p.h.tb.Helper()
+	for steps := 0; ; steps++ {
+		for p.next < len(p.received) {
+			d := p.received[p.next]
+			p.next++
+			if match.Match(d.Msg) {
+				return d.Msg
+			}
+		}
+		if steps == within {
+			break
+		}
+		if _, ok := p.h.Step(); !ok {
+			break
+		}
+	}
+
+	got := make([]*Delivery, len(p.received))
+	for i := range p.received {
+		got[i] = &p.received[i]
+	}
+	p.h.tb.Fatalf("unittest: %s got no message %s within %d messages; received:\n%s", p.name, match, within, p.h.describe(got))
+	return nil
+}
+
+// ExpectNoReply delivers up to within messages, failing the test if the
+// probe receives anything it has not already been asked to expect.
+func (p *Probe) ExpectNoReply(within int) {
+	p.h.tb.Helper()
+	for steps := 0; steps < within; steps++ {
+		if _, ok := p.h.Step(); !ok {
+			break
+		}
+	}
+	if p.next < len(p.received) {
+		p.h.tb.Fatalf("unittest: %s got unexpected message %v", p.name, p.received[p.next])
+	}
+}
+
+// unitCtx is the Ctx a hosted unit is given.
+type unitCtx struct {
+	context.Context
+	h    *Harness
+	self string
+}
+
+func (c *unitCtx) Units() []unit.UnitDesc {
+	var descs []unit.UnitDesc
+	for _, name := range c.h.order {
+		hu := c.h.units[name]
+		if hu.stopped {
+			continue
+		}
+		desc := unit.UnitDesc{Name: name, Proxy: hu.unit, Ref: &ref{h: c.h, name: name, sender: c.self}}
+		if d, ok := hu.unit.(unit.Describer); ok {
+			description := d.Describe()
+			desc.Description = &description
+		}
+		descs = append(descs, desc)
+	}
+	return descs
+}
+
+func (c *unitCtx) Spawn(name string, f unit.UnitFactory, opts ...unit.Option) unit.UnitRef {
+	c.h.Add(name, f())
+	return &ref{h: c.h, name: name, sender: c.self}
+}
+
+// SpawnPool hosts size workers named after the pool, behind a unit that
+// hands messages to each in turn. Pool options are ignored: the harness
+// always picks workers in turn and never scales the pool.
+func (c *unitCtx) SpawnPool(name string, f unit.UnitFactory, size int, opts ...unit.Option) unit.UnitRef {
+	workers := make([]string, max(size, 1))
+	for i := range workers {
+		workers[i] = fmt.Sprintf("%s-%d", name, i+1)
+		c.h.Add(workers[i], f())
+	}
+	c.h.Add(name, &poolRouter{h: c.h, workers: workers})
+	return &ref{h: c.h, name: name, sender: c.self}
+}
+
+func (c *unitCtx) Self() unit.UnitRef {
+	return &ref{h: c.h, name: c.self}
+}
+
+func (c *unitCtx) Send(to unit.UnitRef, msg any) error {
+	if r, ok := to.(*ref); ok && r.h == c.h {
+		return c.h.deliver(&ref{h: c.h, name: c.self, sender: r.name}, r.name, msg)
+	}
+	return to.Send(msg)
+}
+
+// Ask delivers messages until to replies, as the registry would while the
+// unit waited.
+func (c *unitCtx) Ask(to unit.UnitRef, msg any) (any, error) {
+	return c.h.await(c.AskAsync(to, msg))
+}
+
+func (c *unitCtx) AskAsync(to unit.UnitRef, msg any) *unit.Future {
+	f, promise := unit.NewPromise(c.self)
+	if err := c.h.deliver(promise, to.Name(), msg); err != nil {
+		return unit.Resolved(nil, err)
+	}
+	return f
+}
+
+func (c *unitCtx) Subscribe(other unit.Unit) {
+	if name, ok := c.nameOf(other); ok {
+		if c.h.subs[name] == nil {
+			c.h.subs[name] = make(map[string]bool)
+		}
+		c.h.subs[name][c.self] = true
+	}
+}
+
+func (c *unitCtx) Unsubscribe(other unit.Unit) {
+	if name, ok := c.nameOf(other); ok {
+		delete(c.h.subs[name], c.self)
+	}
+}
+
+func (c *unitCtx) nameOf(other unit.Unit) (string, bool) {
+	for _, name := range c.h.order {
+		if c.h.units[name].unit == other {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func (c *unitCtx) Publish(topic string, msg any) error {
+	if err := unit.ValidateTopic(topic, false); err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	var subscribers []string
+	for pattern, subs := range c.h.topics {
+		if !unit.MatchTopic(pattern, topic) {
+			continue
+		}
+		for name := range subs {
+			if !seen[name] {
+				seen[name] = true
+				subscribers = append(subscribers, name)
+			}
+		}
+	}
+	sort.Strings(subscribers)
+
+	pub := unit.Publication{Topic: topic, Payload: msg}
+	for _, name := range subscribers {
+		c.h.deliver(&ref{h: c.h, name: c.self, sender: name}, name, pub)
+	}
+	return nil
+}
+
+func (c *unitCtx) SubscribeTopic(pattern string) error {
+	if err := unit.ValidateTopic(pattern, true); err != nil {
+		return err
+	}
+	if c.h.topics[pattern] == nil {
+		c.h.topics[pattern] = make(map[string]bool)
+	}
+	c.h.topics[pattern][c.self] = true
+	return nil
+}
+
+func (c *unitCtx) UnsubscribeTopic(pattern string) {
+	delete(c.h.topics[pattern], c.self)
+}
+
+func (c *unitCtx) Watch(other unit.UnitRef) {
+	name := other.Name()
+	if hu := c.h.units[name]; hu == nil || hu.stopped {
+		c.h.deliver(unit.NoSender, c.self, unit.Terminated{Unit: name, Reason: unit.ErrUnknownUnit})
+		return
+	}
+	if c.h.watchers[name] == nil {
+		c.h.watchers[name] = make(map[string]bool)
+	}
+	c.h.watchers[name][c.self] = true
+}
+
+func (c *unitCtx) Unwatch(other unit.UnitRef) {
+	delete(c.h.watchers[other.Name()], c.self)
+}
+
+// SendAfter sends msg once the harness's clock has advanced by d, or
+// straight away if d is not positive.
+func (c *unitCtx) SendAfter(to unit.UnitRef, msg any, d time.Duration) *unit.Timer {
+	if d <= 0 {
+		c.Send(to, msg)
+		return &unit.Timer{}
+	}
+	return c.SendOn(to, msg, unit.At(c.h.clock.Now().Add(d)))
+}
+
+func (c *unitCtx) SendEvery(to unit.UnitRef, msg any, interval time.Duration) *unit.Timer {
+	return c.SendOn(to, msg, unit.Every(interval))
+}
+
+// SendOn sends msg at every time in schedule as the harness's clock
+// advances. The messages are queued like any other and delivered by Step.
+func (c *unitCtx) SendOn(to unit.UnitRef, msg any, schedule unit.Schedule) *unit.Timer {
+	hu := c.h.units[c.self]
+	if hu == nil || hu.stopped {
+		return &unit.Timer{}
+	}
+	t := unit.NewTimer(c.h.clock, schedule, func() error { return c.Send(to, msg) })
+	hu.timers = append(hu.timers, t)
+	return t
+}
+
+func (c *unitCtx) Metrics() *metrics.Registry {
+	return c.h.metrics.With(metrics.Labels{"unit": c.self})
+}
+
+// poolRouter forwards messages to a pool's workers, keeping their sender.
+type poolRouter struct {
+	h       *Harness
+	workers []string
+	next    int
+}
+
+func (p *poolRouter) Init(ctx unit.Ctx) {}
+
+func (p *poolRouter) Handle(ctx unit.Ctx, from unit.UnitRef, message any) error {
+	w := p.workers[p.next%len(p.workers)]
+	p.next++
+	return p.h.deliver(from, w, message)
+}
+
+// safeHandle runs Handle, converting a panic into a unit.PanicError as
+// the registry does.
+func safeHandle(u unit.Unit, ctx unit.Ctx, from unit.UnitRef, msg any) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &unit.PanicError{Value: v, Stack: debug.Stack()}
+		}
+	}()
+	return u.Handle(ctx, from, msg)
+}
+
+func safeInit(u unit.Unit, ctx unit.Ctx) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &unit.PanicError{Value: v, Stack: debug.Stack()}
+		}
+	}()
+	u.Init(ctx)
+	return nil
+}
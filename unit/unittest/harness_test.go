@@ -0,0 +1,307 @@
+package unittest
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/eliothedeman/smol/unit"
+)
+
+type echoUnit struct{}
+
+func (e *echoUnit) Init(ctx unit.Ctx) {}
+
+func (e *echoUnit) Handle(ctx unit.Ctx, from unit.UnitRef, message any) error {
+	if message == "fail" {
+		return errors.New("cannot handle fail")
+	}
+	return from.Send(fmt.Sprintf("echo %v", message))
+}
+
+// askingUnit asks the unit named to and replies with its answer.
+type askingUnit struct {
+	to string
+}
+
+func (a *askingUnit) Init(ctx unit.Ctx) {}
+
+func (a *askingUnit) Handle(ctx unit.Ctx, from unit.UnitRef, message any) error {
+	for _, d := range ctx.Units() {
+		if d.Name == a.to {
+			reply, err := ctx.Ask(d.Ref, message)
+			if err != nil {
+				return err
+			}
+			return from.Send(reply)
+		}
+	}
+	return fmt.Errorf("no unit %s", a.to)
+}
+
+// logUnit appends every message it handles to a shared log.
+type logUnit struct {
+	name string
+	log  *[]string
+}
+
+func (l *logUnit) Init(ctx unit.Ctx) {}
+
+func (l *logUnit) Handle(ctx unit.Ctx, from unit.UnitRef, message any) error {
+	*l.log = append(*l.log, fmt.Sprintf("%s:%v", l.name, message))
+	return nil
+}
+
+type watcherUnit struct {
+	target string
+}
+
+func (w *watcherUnit) Init(ctx unit.Ctx) {
+	for _, d := range ctx.Units() {
+		if d.Name == w.target {
+			ctx.Watch(d.Ref)
+		}
+	}
+}
+
+func (w *watcherUnit) Handle(ctx unit.Ctx, from unit.UnitRef, message any) error {
+	return ctx.Publish("watch.terminated", message)
+}
+
+func TestProbeExpectsReplies(t *testing.T) {
+	h := New(t)
+	h.Add("echo", &echoUnit{})
+	client := h.Probe("client")
+
+	client.Send("echo", "a")
+	client.Send("echo", "b")
+	if got := client.ExpectReply(Equal("echo a"), 3); got != "echo a" {
+		t.Errorf("Expected echo a, got %v", got)
+	}
+	client.ExpectReply(Equal("echo b"), 2)
+	client.ExpectNoReply(10)
+
+	sent := h.Sent()
+	if len(sent) != 4 || sent[0].From != "client" || sent[1].To != "echo" || sent[2].To != "client" {
+		t.Errorf("Unexpected sends: %v", sent)
+	}
+}
+
+func TestAskWithinHandle(t *testing.T) {
+	h := New(t)
+	h.Add("echo", &echoUnit{})
+	h.Add("asker", &askingUnit{to: "echo"})
+	client := h.Probe("client")
+
+	client.Send("asker", "ping")
+	client.ExpectReply(Equal("echo ping"), 5)
+
+	if _, err := h.Ask("asker", "fail"); err == nil || err.Error() != "cannot handle fail" {
+		t.Errorf("Expected the handler error, got %v", err)
+	}
+	if _, err := h.Ask("missing", "ping"); !errors.Is(err, unit.ErrUnknownUnit) {
+		t.Errorf("Expected ErrUnknownUnit, got %v", err)
+	}
+}
+
+func interleaving(t *testing.T, seed int64) []string {
+	var log []string
+	h := New(t, WithSeed(seed))
+	for _, name := range []string{"a", "b", "c"} {
+		h.Add(name, &logUnit{name: name, log: &log})
+	}
+	for i := 0; i < 3; i++ {
+		for _, name := range []string{"a", "b", "c"} {
+			h.Send(name, i)
+		}
+	}
+	if n := h.Run(); n != 9 {
+		t.Fatalf("Expected 9 deliveries, got %d", n)
+	}
+	return log
+}
+
+func TestSeededSchedulerIsReproducible(t *testing.T) {
+	first := interleaving(t, 42)
+	if again := interleaving(t, 42); !reflect.DeepEqual(first, again) {
+		t.Errorf("Same seed gave %v, then %v", first, again)
+	}
+
+	distinct := map[string]bool{}
+	for seed := int64(0); seed < 10; seed++ {
+		order := interleaving(t, seed)
+		distinct[fmt.Sprint(order)] = true
+
+		next := map[string]int{}
+		for _, entry := range order {
+			var name string
+			var i int
+			fmt.Sscanf(entry, "%1s:%d", &name, &i)
+			if i != next[name] {
+				t.Fatalf("Seed %d handled %s out of order: %v", seed, entry, order)
+			}
+			next[name]++
+		}
+	}
+	if len(distinct) < 2 {
+		t.Errorf("Expected different seeds to interleave differently")
+	}
+
+	fifo := New(t)
+	var log []string
+	fifo.Add("a", &logUnit{name: "a", log: &log})
+	fifo.Add("b", &logUnit{name: "b", log: &log})
+	fifo.Send("b", 1)
+	fifo.Send("a", 2)
+	fifo.Run()
+	if !reflect.DeepEqual(log, []string{"b:1", "a:2"}) {
+		t.Errorf("Expected send order without a seed, got %v", log)
+	}
+}
+
+func TestClock(t *testing.T) {
+	clock := NewClock(Epoch)
+	var fired []time.Duration
+	record := func() { fired = append(fired, clock.Now().Sub(Epoch)) }
+
+	clock.AfterFunc(3*time.Second, record)
+	clock.AfterFunc(time.Second, record)
+	stop := clock.AfterFunc(2*time.Second, record)
+	if !stop() || stop() {
+		t.Errorf("Expected stop to cancel the timer once")
+	}
+
+	clock.Advance(2 * time.Second)
+	clock.Advance(2 * time.Second)
+	if !reflect.DeepEqual(fired, []time.Duration{time.Second, 3 * time.Second}) {
+		t.Errorf("Unexpected timers fired: %v", fired)
+	}
+	if got := clock.Now().Sub(Epoch); got != 4*time.Second {
+		t.Errorf("Expected the clock at 4s, got %v", got)
+	}
+}
+
+func TestWatchAndTopics(t *testing.T) {
+	h := New(t)
+	worker := h.Add("worker", &echoUnit{})
+	h.Add("watcher", &watcherUnit{target: "worker"})
+	events := h.Probe("events")
+	h.Ctx("events").SubscribeTopic("watch.>")
+
+	worker.Stop()
+	got := events.ExpectReply(OfType[unit.Publication](), 5).(unit.Publication)
+	terminated, ok := got.Payload.(unit.Terminated)
+	if !ok || terminated.Unit != "worker" || !errors.Is(terminated.Reason, unit.ErrUnitStopped) {
+		t.Errorf("Expected worker's termination, got %+v", got)
+	}
+	if err := worker.Send("late"); !errors.Is(err, unit.ErrUnitStopped) {
+		t.Errorf("Expected ErrUnitStopped, got %v", err)
+	}
+}
+
+func TestHasFields(t *testing.T) {
+	type result struct {
+		Success bool    `json:"success"`
+		Value   float64 `json:"value"`
+		Note    string  `json:"note"`
+	}
+	m := HasFields(map[string]any{"success": true, "value": 3})
+	if !m.Match(result{Success: true, Value: 3, Note: "x"}) {
+		t.Errorf("Expected %s to match a struct", m)
+	}
+	if !m.Match(map[string]any{"success": true, "value": 3.0}) {
+		t.Errorf("Expected %s to match a map", m)
+	}
+	if m.Match(result{Success: true, Value: 4}) || m.Match("success") {
+		t.Errorf("Expected %s not to match", m)
+	}
+}
+
+// alarmUnit reports the time of every message it schedules for itself.
+type alarmUnit struct {
+	schedule unit.Schedule
+	report   unit.UnitRef
+}
+
+func (a *alarmUnit) Init(ctx unit.Ctx) {
+	ctx.SendOn(ctx.Self(), "tick", a.schedule)
+}
+
+func (a *alarmUnit) Handle(ctx unit.Ctx, from unit.UnitRef, message any) error {
+	return ctx.Send(a.report, message)
+}
+
+func TestTimersFollowTheClock(t *testing.T) {
+	hourly, err := unit.ParseCron("@hourly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := New(t)
+	client := h.Probe("client")
+	alarm := h.Add("alarm", &alarmUnit{schedule: hourly, report: client.Ref()})
+	h.Ctx("client").SendAfter(alarm, "wake", 90*time.Minute)
+
+	h.Advance(59 * time.Minute)
+	client.ExpectNoReply(10)
+
+	h.Advance(2 * time.Hour)
+	var times []time.Duration
+	for _, d := range h.Sent() {
+		if d.To == "alarm" {
+			times = append(times, d.At.Sub(Epoch))
+		}
+	}
+	want := []time.Duration{time.Hour, 90 * time.Minute, 2 * time.Hour}
+	if !reflect.DeepEqual(times, want) {
+		t.Errorf("Expected sends at %v, got %v", want, times)
+	}
+	client.ExpectReply(Equal("tick"), 4)
+	client.ExpectReply(Equal("wake"), 2)
+	client.ExpectReply(Equal("tick"), 1)
+
+	alarm.Stop()
+	h.Advance(time.Hour)
+	if pending := h.Pending(); len(pending) != 0 {
+		t.Errorf("Expected the stopped unit's timer to stop, got %v", pending)
+	}
+}
+
+// poolOwner spawns a pool of echo units and forwards messages to it.
+type poolOwner struct {
+	pool unit.UnitRef
+}
+
+func (p *poolOwner) Init(ctx unit.Ctx) {
+	p.pool = ctx.SpawnPool("echoes", func() unit.Unit { return &echoUnit{} }, 2)
+}
+
+func (p *poolOwner) Handle(ctx unit.Ctx, from unit.UnitRef, message any) error {
+	reply, err := ctx.Ask(p.pool, message)
+	if err != nil {
+		return err
+	}
+	return from.Send(reply)
+}
+
+func TestSpawnPool(t *testing.T) {
+	h := New(t)
+	h.Add("owner", &poolOwner{})
+	client := h.Probe("client")
+
+	for _, msg := range []string{"a", "b", "c"} {
+		client.Send("owner", msg)
+		client.ExpectReply(Equal("echo "+msg), 6)
+	}
+	var handled []string
+	for _, d := range h.Sent() {
+		if d.From == "owner" && d.To != "echoes" && d.To != "client" {
+			handled = append(handled, d.To)
+		}
+	}
+	// Each ask goes to the pool, then on to a worker with its sender kept.
+	if !reflect.DeepEqual(handled, []string{"echoes-1", "echoes-2", "echoes-1"}) {
+		t.Errorf("Expected workers to take turns, got %v", handled)
+	}
+}
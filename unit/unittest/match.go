@@ -0,0 +1,93 @@
+package unittest
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Matcher decides whether a message is the one a test expects.
+type Matcher interface {
+	Match(msg any) bool
+	String() string
+}
+
+type matcher struct {
+	desc  string
+	match func(msg any) bool
+}
+
+func (m matcher) Match(msg any) bool { return m.match(msg) }
+func (m matcher) String() string     { return m.desc }
+
+// Equal matches messages deeply equal to want.
+func Equal(want any) Matcher {
+	return matcher{
+		desc:  fmt.Sprintf("equal to %#v", want),
+		match: func(msg any) bool { return reflect.DeepEqual(msg, want) },
+	}
+}
+
+// OfType matches messages of type T.
+func OfType[T any]() Matcher {
+	var zero T
+	return matcher{
+		desc: fmt.Sprintf("of type %T", zero),
+		match: func(msg any) bool {
+			_, ok := msg.(T)
+			return ok
+		},
+	}
+}
+
+// HasFields matches messages with at least the given fields. Messages and
+// values are compared as JSON, so the fields of structs go by their JSON
+// names and numbers of any type compare equal.
+func HasFields(fields map[string]any) Matcher {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s=%v", k, fields[k])
+	}
+
+	return matcher{
+		desc: "with " + strings.Join(parts, ", "),
+		match: func(msg any) bool {
+			got, ok := generic(msg).(map[string]any)
+			if !ok {
+				return false
+			}
+			for k, want := range fields {
+				if !reflect.DeepEqual(got[k], generic(want)) {
+					return false
+				}
+			}
+			return true
+		},
+	}
+}
+
+// Satisfies matches messages for which f returns true.
+func Satisfies(desc string, f func(msg any) bool) Matcher {
+	return matcher{desc: desc, match: f}
+}
+
+// generic converts v to the value encoding/json would decode it as, or
+// returns it unchanged if it has no JSON form.
+func generic(v any) any {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var out any
+	if err := json.Unmarshal(data, &out); err != nil {
+		return v
+	}
+	return out
+}